@@ -0,0 +1,198 @@
+// Package arc implements the Authenticated Received Chain protocol.
+//
+// ARC is defined in RFC 8617. It allows intermediaries such as mailing lists
+// to record the authentication results they observed, so that later
+// receivers can take them into account when the original DKIM signatures
+// have been broken by message modifications.
+//
+// Like the dkim package, this package operates on a textproto.Header and uses
+// the raw representation of header fields.
+package arc
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message/internal/dkimutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+const (
+	authResultsFieldName  = "ARC-Authentication-Results"
+	msgSignatureFieldName = "ARC-Message-Signature"
+	sealFieldName         = "ARC-Seal"
+)
+
+// MaxInstance is the maximum number of ARC sets in a chain, as defined in
+// RFC 8617 section 4.2.1.
+const MaxInstance = 50
+
+// ChainValidation is an ARC chain validation status, as defined in RFC 8617
+// section 4.4.
+type ChainValidation string
+
+const (
+	ChainValidationNone ChainValidation = "none"
+	ChainValidationPass ChainValidation = "pass"
+	ChainValidationFail ChainValidation = "fail"
+)
+
+// set is an ARC set: the three header fields sharing an instance number.
+type set struct {
+	instance int
+
+	aar, ams, as    []byte // raw header fields
+	amsTags, asTags map[string]string
+}
+
+// fieldValue returns the value of a raw header field.
+func fieldValue(raw []byte) string {
+	s := string(raw)
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+func parseInstance(s string) (int, error) {
+	i, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || i < 1 || i > MaxInstance {
+		return 0, fmt.Errorf("invalid instance %q", s)
+	}
+	return i, nil
+}
+
+// parseSets collects the ARC sets of a header, sorted by instance. It fails
+// if the sets don't form a valid chain structure, as defined in RFC 8617
+// section 5.2 step 2.
+func parseSets(h *textproto.Header) ([]*set, error) {
+	m := make(map[int]*set)
+	get := func(i int) *set {
+		s, ok := m[i]
+		if !ok {
+			s = &set{instance: i}
+			m[i] = s
+		}
+		return s
+	}
+
+	fields := h.FieldsByKey(authResultsFieldName)
+	for fields.Next() {
+		raw, err := fields.Raw()
+		if err != nil {
+			return nil, err
+		}
+		// The value is "i=<instance>;" followed by an Authentication-Results
+		// payload, which isn't a tag-list
+		v := fieldValue(raw)
+		semi := strings.IndexByte(v, ';')
+		if semi < 0 {
+			return nil, fmt.Errorf("arc: malformed %v field", authResultsFieldName)
+		}
+		tags, err := dkimutil.ParseTagList(v[:semi])
+		if err != nil {
+			return nil, fmt.Errorf("arc: malformed %v field: %v", authResultsFieldName, err)
+		}
+		i, err := parseInstance(tags["i"])
+		if err != nil {
+			return nil, fmt.Errorf("arc: malformed %v field: %v", authResultsFieldName, err)
+		}
+		s := get(i)
+		if s.aar != nil {
+			return nil, fmt.Errorf("arc: duplicate %v field for instance %v", authResultsFieldName, i)
+		}
+		s.aar = raw
+	}
+
+	for _, k := range []string{msgSignatureFieldName, sealFieldName} {
+		fields := h.FieldsByKey(k)
+		for fields.Next() {
+			raw, err := fields.Raw()
+			if err != nil {
+				return nil, err
+			}
+			tags, err := dkimutil.ParseTagList(fieldValue(raw))
+			if err != nil {
+				return nil, fmt.Errorf("arc: malformed %v field: %v", k, err)
+			}
+			i, err := parseInstance(tags["i"])
+			if err != nil {
+				return nil, fmt.Errorf("arc: malformed %v field: %v", k, err)
+			}
+			s := get(i)
+			if k == msgSignatureFieldName {
+				if s.ams != nil {
+					return nil, fmt.Errorf("arc: duplicate %v field for instance %v", k, i)
+				}
+				s.ams, s.amsTags = raw, tags
+			} else {
+				if s.as != nil {
+					return nil, fmt.Errorf("arc: duplicate %v field for instance %v", k, i)
+				}
+				s.as, s.asTags = raw, tags
+			}
+		}
+	}
+
+	sets := make([]*set, 0, len(m))
+	for _, s := range m {
+		sets = append(sets, s)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].instance < sets[j].instance
+	})
+	for i, s := range sets {
+		if s.instance != i+1 {
+			return nil, fmt.Errorf("arc: missing ARC set for instance %v", i+1)
+		}
+		if s.aar == nil || s.ams == nil || s.as == nil {
+			return nil, fmt.Errorf("arc: incomplete ARC set for instance %v", s.instance)
+		}
+	}
+	return sets, nil
+}
+
+// hashSeal computes the hash signed by the ARC-Seal field of the last set, as
+// defined in RFC 8617 section 5.1.1. The ARC-Seal field of the last set
+// is hashed without its signature.
+func hashSeal(sets []*set) []byte {
+	hasher := dkimutil.HashAlgo.New()
+	for i, s := range sets {
+		hasher.Write(dkimutil.CanonicalizeHeader(s.aar, true))
+		hasher.Write(dkimutil.CanonicalizeHeader(s.ams, true))
+		if i < len(sets)-1 {
+			hasher.Write(dkimutil.CanonicalizeHeader(s.as, true))
+		} else {
+			as := dkimutil.CanonicalizeHeader(dkimutil.RemoveSignature(s.as), true)
+			hasher.Write(as[:len(as)-2]) // strip trailing CRLF
+		}
+	}
+	return hasher.Sum(nil)
+}
+
+// hashMessageSignature computes the header hash signed by an
+// ARC-Message-Signature field, in the same way as a DKIM signature.
+func hashMessageSignature(h *textproto.Header, keys []string, ams []byte, relaxed bool) []byte {
+	hasher := dkimutil.HashAlgo.New()
+	for _, raw := range dkimutil.SignedHeaderFields(h, keys) {
+		hasher.Write(dkimutil.CanonicalizeHeader(raw, relaxed))
+	}
+	ams = dkimutil.CanonicalizeHeader(dkimutil.RemoveSignature(ams), relaxed)
+	hasher.Write(ams[:len(ams)-2]) // strip trailing CRLF
+	return hasher.Sum(nil)
+}
+
+// checkHeaderKeys returns an error if the list of header keys signed by an
+// ARC-Message-Signature contains an ARC-Seal field, which is forbidden by
+// RFC 8617 section 4.1.2.
+func checkHeaderKeys(keys []string) error {
+	for _, k := range keys {
+		if strings.EqualFold(k, sealFieldName) {
+			return errors.New("arc: ARC-Seal fields must not be signed by ARC-Message-Signature")
+		}
+	}
+	return nil
+}
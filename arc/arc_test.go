@@ -0,0 +1,156 @@
+package arc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/dkim"
+	"github.com/emersion/go-message/textproto"
+)
+
+const testMessage = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: list@lists.example.org\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n"
+
+var testKeys = map[string]ed25519.PrivateKey{
+	"lists.example.org": ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize)),
+	"relay.example.net": ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize)),
+}
+
+var testValidateOptions = &ValidateOptions{
+	LookupKey: func(domain, selector string) (*dkim.PublicKey, error) {
+		key, ok := testKeys[domain]
+		if !ok || selector != "arc" {
+			return nil, fmt.Errorf("no key for %v and selector %v", domain, selector)
+		}
+		return &dkim.PublicKey{Key: key.Public()}, nil
+	},
+}
+
+func splitMessage(t *testing.T, msg string) (textproto.Header, string) {
+	br := bufio.NewReader(strings.NewReader(msg))
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		t.Fatalf("ReadHeader() = %v", err)
+	}
+	var body strings.Builder
+	if _, err := br.WriteTo(&body); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	return h, body.String()
+}
+
+func sealTestMessage(t *testing.T, msg, domain string, cv ChainValidation) string {
+	h, body := splitMessage(t, msg)
+	err := Seal(&h, strings.NewReader(body), &SealOptions{
+		Domain:                domain,
+		Selector:              "arc",
+		Signer:                testKeys[domain],
+		AuthenticationResults: domain + "; dkim=pass header.d=football.example.com",
+		ChainValidation:       cv,
+		Time:                  time.Unix(424242, 0),
+	})
+	if err != nil {
+		t.Fatalf("Seal() = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := textproto.WriteHeader(&buf, h); err != nil {
+		t.Fatalf("WriteHeader() = %v", err)
+	}
+	buf.WriteString(body)
+	return buf.String()
+}
+
+func validateTestMessage(t *testing.T, msg string) *Validation {
+	v, err := ValidateMessage(strings.NewReader(msg), testValidateOptions)
+	if err != nil {
+		t.Fatalf("ValidateMessage() = %v", err)
+	}
+	return v
+}
+
+func TestValidate_none(t *testing.T) {
+	v := validateTestMessage(t, testMessage)
+	if v.Result != ChainValidationNone || v.Err != nil {
+		t.Errorf("Expected cv=none, got %v (%v)", v.Result, v.Err)
+	}
+}
+
+func TestSeal(t *testing.T) {
+	msg := sealTestMessage(t, testMessage, "lists.example.org", "")
+	if !strings.HasPrefix(msg, "ARC-Seal: i=1; a=ed25519-sha256; cv=none;") {
+		t.Errorf("Expected the message to start with an ARC-Seal field, got:\n%v", msg)
+	}
+	if !strings.Contains(msg, "ARC-Authentication-Results: i=1; lists.example.org; dkim=pass\r\n header.d=football.example.com\r\n") {
+		t.Errorf("Expected an ARC-Authentication-Results field, got:\n%v", msg)
+	}
+
+	v := validateTestMessage(t, msg)
+	if v.Result != ChainValidationPass || v.Err != nil {
+		t.Fatalf("Expected cv=pass, got %v (%v)", v.Result, v.Err)
+	}
+	if v.Instances != 1 {
+		t.Errorf("Expected 1 instance, got %v", v.Instances)
+	}
+
+	msg = sealTestMessage(t, msg, "relay.example.net", v.Result)
+	v = validateTestMessage(t, msg)
+	if v.Result != ChainValidationPass || v.Err != nil {
+		t.Fatalf("Expected cv=pass, got %v (%v)", v.Result, v.Err)
+	}
+	if v.Instances != 2 {
+		t.Errorf("Expected 2 instances, got %v", v.Instances)
+	}
+}
+
+func TestSeal_noChainValidation(t *testing.T) {
+	msg := sealTestMessage(t, testMessage, "lists.example.org", "")
+	h, body := splitMessage(t, msg)
+	err := Seal(&h, strings.NewReader(body), &SealOptions{
+		Domain:                "relay.example.net",
+		Selector:              "arc",
+		Signer:                testKeys["relay.example.net"],
+		AuthenticationResults: "relay.example.net; arc=pass",
+	})
+	if err == nil {
+		t.Errorf("Expected an error when sealing a chain without validation status")
+	}
+}
+
+func TestValidate_fail(t *testing.T) {
+	msg := sealTestMessage(t, testMessage, "lists.example.org", "")
+
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{"body", strings.Replace(msg, "hungry", "angry", 1)},
+		{"header", strings.Replace(msg, "dinner", "lunch", 1)},
+		{"results", strings.Replace(msg, "dkim=pass", "dkim=fail", 1)},
+		{"missing-seal", msg[strings.Index(msg, "ARC-Message-Signature:"):]},
+	}
+	for _, test := range tests {
+		v := validateTestMessage(t, test.msg)
+		if v.Result != ChainValidationFail || v.Err == nil {
+			t.Errorf("%v: expected cv=fail, got %v", test.name, v.Result)
+		}
+	}
+
+	// A failed chain stays failed
+	failed := sealTestMessage(t, strings.Replace(msg, "dinner", "lunch", 1), "relay.example.net", ChainValidationFail)
+	if v := validateTestMessage(t, failed); v.Result != ChainValidationFail {
+		t.Errorf("Expected cv=fail for a chain sealed with cv=fail, got %v", v.Result)
+	}
+}
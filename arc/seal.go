@@ -0,0 +1,228 @@
+package arc
+
+import (
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/dkim"
+	"github.com/emersion/go-message/internal/dkimutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+// SealOptions contains options for Seal.
+type SealOptions struct {
+	// The domain of the sealer (d= tag). Required.
+	Domain string
+	// The selector used to publish the sealer's key (s= tag). Required.
+	Selector string
+	// The key used to sign the ARC-Message-Signature and ARC-Seal fields.
+	// Either an *rsa.PrivateKey or an ed25519.PrivateKey. Required.
+	Signer crypto.Signer
+
+	// The Authentication-Results payload recorded in the
+	// ARC-Authentication-Results field, without the instance tag, e.g.
+	// "example.org; dkim=pass header.d=example.com". Required.
+	AuthenticationResults string
+
+	// The validation status of the existing ARC chain, as returned by
+	// Validate. It's ignored if the message has no ARC set, and required
+	// otherwise.
+	ChainValidation ChainValidation
+
+	// Header and body canonicalization algorithms of the
+	// ARC-Message-Signature. If empty, the relaxed algorithm is used.
+	HeaderCanonicalization dkim.Canonicalization
+	BodyCanonicalization   dkim.Canonicalization
+
+	// A list of header fields to sign in the ARC-Message-Signature. If nil,
+	// the fields of dkim.DefaultHeaderKeys and DKIM-Signature fields present
+	// in the header are signed.
+	HeaderKeys []string
+
+	// The signature timestamp (t= tag). If zero, the current time is used.
+	Time time.Time
+}
+
+func headerKeysToSign(h *textproto.Header, opts *SealOptions) ([]string, error) {
+	keys := opts.HeaderKeys
+	if keys == nil {
+		for _, k := range dkim.DefaultHeaderKeys {
+			if h.Has(k) {
+				keys = append(keys, k)
+			}
+		}
+		for n := h.FieldsByKey("DKIM-Signature").Len(); n > 0; n-- {
+			keys = append(keys, "DKIM-Signature")
+		}
+	}
+	if err := checkHeaderKeys(keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Seal adds a new ARC set to a message with the provided header and body. The
+// ARC-Authentication-Results, ARC-Message-Signature and ARC-Seal header
+// fields are added at the top of the header.
+//
+// The raw representation of the signed header fields is used: the header
+// must not be modified or re-formatted after sealing.
+func Seal(h *textproto.Header, body io.Reader, opts *SealOptions) error {
+	if opts == nil {
+		return errors.New("arc: no options specified")
+	}
+	if opts.Domain == "" {
+		return errors.New("arc: no domain specified")
+	}
+	if opts.Selector == "" {
+		return errors.New("arc: no selector specified")
+	}
+	if opts.Signer == nil {
+		return errors.New("arc: no signer specified")
+	}
+	if opts.AuthenticationResults == "" {
+		return errors.New("arc: no authentication results specified")
+	}
+
+	algo := dkimutil.KeyAlgo(opts.Signer.Public())
+	if algo == "" {
+		return fmt.Errorf("arc: unsupported key algorithm %T", opts.Signer.Public())
+	}
+
+	headerCan := opts.HeaderCanonicalization
+	if headerCan == "" {
+		headerCan = dkim.CanonicalizationRelaxed
+	}
+	bodyCan := opts.BodyCanonicalization
+	if bodyCan == "" {
+		bodyCan = dkim.CanonicalizationRelaxed
+	}
+	for _, c := range []dkim.Canonicalization{headerCan, bodyCan} {
+		if c != dkim.CanonicalizationSimple && c != dkim.CanonicalizationRelaxed {
+			return fmt.Errorf("arc: unknown canonicalization %q", c)
+		}
+	}
+
+	sets, err := parseSets(h)
+	if err != nil {
+		return err
+	}
+	if len(sets) >= MaxInstance {
+		return fmt.Errorf("arc: too many ARC sets, maximum is %v", MaxInstance)
+	}
+
+	cv := ChainValidationNone
+	if len(sets) > 0 {
+		cv = opts.ChainValidation
+		switch cv {
+		case ChainValidationPass, ChainValidationFail:
+			// ok
+		case "":
+			return errors.New("arc: no chain validation status specified")
+		default:
+			return fmt.Errorf("arc: invalid chain validation status %q for a non-empty chain", cv)
+		}
+	}
+
+	keys, err := headerKeysToSign(h, opts)
+	if err != nil {
+		return err
+	}
+
+	// Compute the body hash
+	hasher := dkimutil.HashAlgo.New()
+	bc := dkimutil.NewBodyCanonicalizer(hasher, bodyCan == dkim.CanonicalizationRelaxed)
+	if _, err := io.Copy(bc, body); err != nil {
+		return err
+	}
+	if err := bc.Close(); err != nil {
+		return err
+	}
+	bodyHash := base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+
+	t := opts.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	instance := strconv.Itoa(len(sets) + 1)
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+
+	newSet := &set{instance: len(sets) + 1}
+	newSet.aar = formatAuthResults(instance, opts.AuthenticationResults)
+
+	amsTags := [][2]string{
+		{"i", instance},
+		{"a", algo + "-sha256"},
+		{"c", string(headerCan) + "/" + string(bodyCan)},
+		{"d", opts.Domain},
+		{"s", opts.Selector},
+		{"t", timestamp},
+		{"h", strings.Join(keys, ":")},
+		{"bh", bodyHash},
+		{"b", ""},
+	}
+	hashed := hashMessageSignature(h, keys, dkimutil.FormatTagList(msgSignatureFieldName, amsTags), headerCan == dkim.CanonicalizationRelaxed)
+	sig, err := dkimutil.Sign(opts.Signer, hashed)
+	if err != nil {
+		return fmt.Errorf("arc: failed to sign: %v", err)
+	}
+	amsTags[len(amsTags)-1][1] = base64.StdEncoding.EncodeToString(sig)
+	newSet.ams = dkimutil.FormatTagList(msgSignatureFieldName, amsTags)
+
+	asTags := [][2]string{
+		{"i", instance},
+		{"a", algo + "-sha256"},
+		{"cv", string(cv)},
+		{"d", opts.Domain},
+		{"s", opts.Selector},
+		{"t", timestamp},
+		{"b", ""},
+	}
+	newSet.as = dkimutil.FormatTagList(sealFieldName, asTags)
+	sealed := append(sets, newSet)
+	if cv == ChainValidationFail {
+		// RFC 8617 section 5.1.1: a seal with cv=fail only covers its own
+		// ARC set
+		sealed = []*set{newSet}
+	}
+	sig, err = dkimutil.Sign(opts.Signer, hashSeal(sealed))
+	if err != nil {
+		return fmt.Errorf("arc: failed to sign: %v", err)
+	}
+	asTags[len(asTags)-1][1] = base64.StdEncoding.EncodeToString(sig)
+	newSet.as = dkimutil.FormatTagList(sealFieldName, asTags)
+
+	h.AddRaw(newSet.aar)
+	h.AddRaw(newSet.ams)
+	h.AddRaw(newSet.as)
+	return nil
+}
+
+// formatAuthResults formats the ARC-Authentication-Results header field of an
+// ARC set. Long lines are folded at spaces.
+func formatAuthResults(instance, results string) []byte {
+	const maxLen = 76
+
+	results = strings.NewReplacer("\r\n", "", "\n", "").Replace(results)
+	words := strings.Split("i="+instance+"; "+strings.TrimSpace(results), " ")
+
+	var b strings.Builder
+	b.WriteString(authResultsFieldName + ":")
+	lineLen := b.Len()
+	for _, word := range words {
+		if lineLen+1+len(word) > maxLen && lineLen > len(authResultsFieldName)+1 {
+			b.WriteString("\r\n")
+			lineLen = 0
+		}
+		b.WriteString(" " + word)
+		lineLen += 1 + len(word)
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
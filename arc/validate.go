@@ -0,0 +1,213 @@
+package arc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message/dkim"
+	"github.com/emersion/go-message/internal/dkimutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+// A Validation is the result of the validation of an ARC chain.
+type Validation struct {
+	// The chain validation status.
+	Result ChainValidation
+	// The number of ARC sets in the chain.
+	Instances int
+	// If Result is ChainValidationFail, Err describes the failure.
+	Err error
+}
+
+// ValidateOptions contains options for Validate.
+type ValidateOptions struct {
+	// LookupKey fetches the public key of a domain for a selector. If nil,
+	// keys are fetched from DNS with dkim.LookupPublicKey and
+	// dkim.DefaultResolver.
+	LookupKey func(domain, selector string) (*dkim.PublicKey, error)
+}
+
+func (opts *ValidateOptions) lookupKey(domain, selector string) (*dkim.PublicKey, error) {
+	if opts.LookupKey != nil {
+		return opts.LookupKey(domain, selector)
+	}
+	return dkim.LookupPublicKey(dkim.DefaultResolver, domain, selector)
+}
+
+// verifySignature checks the signature of a hash against the key referenced
+// by the d=, s= and a= tags of an ARC field.
+func verifySignature(tags map[string]string, hashed []byte, opts *ValidateOptions) error {
+	for _, k := range []string{"a", "b", "d", "s"} {
+		if _, ok := tags[k]; !ok {
+			return fmt.Errorf("missing required tag %v", k)
+		}
+	}
+
+	algo := strings.SplitN(tags["a"], "-", 2)
+	if len(algo) != 2 || algo[1] != "sha256" {
+		return errors.New("unsupported hash algorithm")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(dkimutil.StripWhitespace(tags["b"]))
+	if err != nil {
+		return fmt.Errorf("malformed signature: %v", err)
+	}
+
+	pk, err := opts.lookupKey(tags["d"], tags["s"])
+	if err != nil {
+		return err
+	}
+	if dkimutil.KeyAlgo(pk.Key) != algo[0] {
+		return errors.New("inappropriate key algorithm")
+	}
+	if err := dkimutil.Verify(pk.Key, hashed, sig); err != nil {
+		return fmt.Errorf("signature did not verify: %v", err)
+	}
+	return nil
+}
+
+// messageSignature is a parsed ARC-Message-Signature field, waiting for its
+// body hash to be computed.
+type messageSignature struct {
+	keys     []string
+	headerC  dkim.Canonicalization
+	bodyHash []byte
+
+	hasher hash.Hash
+	bc     *dkimutil.BodyCanonicalizer
+}
+
+func parseMessageSignature(tags map[string]string) (*messageSignature, error) {
+	for _, k := range []string{"bh", "h"} {
+		if _, ok := tags[k]; !ok {
+			return nil, fmt.Errorf("missing required tag %v", k)
+		}
+	}
+
+	ms := &messageSignature{
+		keys:    dkimutil.SplitColonList(tags["h"]),
+		headerC: dkim.CanonicalizationSimple,
+	}
+	if err := checkHeaderKeys(ms.keys); err != nil {
+		return nil, err
+	}
+
+	bodyC := dkim.CanonicalizationSimple
+	if c, ok := tags["c"]; ok {
+		parts := strings.SplitN(c, "/", 2)
+		ms.headerC = dkim.Canonicalization(strings.TrimSpace(parts[0]))
+		if len(parts) == 2 {
+			bodyC = dkim.Canonicalization(strings.TrimSpace(parts[1]))
+		}
+	}
+	for _, c := range []dkim.Canonicalization{ms.headerC, bodyC} {
+		if c != dkim.CanonicalizationSimple && c != dkim.CanonicalizationRelaxed {
+			return nil, errors.New("unsupported canonicalization algorithm")
+		}
+	}
+
+	var err error
+	if ms.bodyHash, err = base64.StdEncoding.DecodeString(dkimutil.StripWhitespace(tags["bh"])); err != nil {
+		return nil, fmt.Errorf("malformed body hash: %v", err)
+	}
+
+	bodyLen := int64(-1)
+	if l, ok := tags["l"]; ok {
+		if bodyLen, err = strconv.ParseInt(dkimutil.StripWhitespace(l), 10, 64); err != nil || bodyLen < 0 {
+			return nil, errors.New("malformed body length")
+		}
+	}
+
+	ms.hasher = dkimutil.HashAlgo.New()
+	ms.bc = dkimutil.NewBodyCanonicalizer(&dkimutil.LimitWriter{W: ms.hasher, N: bodyLen}, bodyC == dkim.CanonicalizationRelaxed)
+	return ms, nil
+}
+
+// Validate checks the ARC chain of a message with the provided header and
+// body, as defined in RFC 8617 section 5.2. Only the most recent
+// ARC-Message-Signature is checked, all ARC-Seal fields are checked. The body
+// is only read if the chain structure is valid. An error is returned only if
+// the body cannot be read.
+func Validate(h *textproto.Header, body io.Reader, opts *ValidateOptions) (*Validation, error) {
+	if opts == nil {
+		opts = new(ValidateOptions)
+	}
+
+	fail := func(v *Validation, err error) (*Validation, error) {
+		v.Result = ChainValidationFail
+		v.Err = err
+		return v, nil
+	}
+
+	sets, err := parseSets(h)
+	if err != nil {
+		return fail(&Validation{}, err)
+	}
+	v := &Validation{Instances: len(sets)}
+	if len(sets) == 0 {
+		v.Result = ChainValidationNone
+		return v, nil
+	}
+
+	// Check the cv= tags
+	for _, s := range sets {
+		want := ChainValidationPass
+		if s.instance == 1 {
+			want = ChainValidationNone
+		}
+		cv := ChainValidation(s.asTags["cv"])
+		if cv == ChainValidationFail && s.instance == len(sets) {
+			return fail(v, fmt.Errorf("arc: instance %v has already failed validation", s.instance))
+		}
+		if cv != want {
+			return fail(v, fmt.Errorf("arc: invalid chain validation status %q for instance %v", cv, s.instance))
+		}
+	}
+
+	// Check the most recent ARC-Message-Signature
+	last := sets[len(sets)-1]
+	ms, err := parseMessageSignature(last.amsTags)
+	if err != nil {
+		return fail(v, fmt.Errorf("arc: malformed %v field: %v", msgSignatureFieldName, err))
+	}
+	if _, err := io.Copy(ms.bc, body); err != nil {
+		return nil, err
+	}
+	if err := ms.bc.Close(); err != nil {
+		return nil, err
+	}
+	if string(ms.hasher.Sum(nil)) != string(ms.bodyHash) {
+		return fail(v, fmt.Errorf("arc: body hash of instance %v did not verify", last.instance))
+	}
+	hashed := hashMessageSignature(h, ms.keys, last.ams, ms.headerC == dkim.CanonicalizationRelaxed)
+	if err := verifySignature(last.amsTags, hashed, opts); err != nil {
+		return fail(v, fmt.Errorf("arc: %v of instance %v: %v", msgSignatureFieldName, last.instance, err))
+	}
+
+	// Check all ARC-Seal fields, starting from the most recent one
+	for i := len(sets); i > 0; i-- {
+		if err := verifySignature(sets[i-1].asTags, hashSeal(sets[:i]), opts); err != nil {
+			return fail(v, fmt.Errorf("arc: %v of instance %v: %v", sealFieldName, i, err))
+		}
+	}
+
+	v.Result = ChainValidationPass
+	return v, nil
+}
+
+// ValidateMessage reads a message from r and checks its ARC chain. See
+// Validate.
+func ValidateMessage(r io.Reader, opts *ValidateOptions) (*Validation, error) {
+	br := bufio.NewReader(r)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return Validate(&h, br, opts)
+}
@@ -1,11 +1,15 @@
 package message
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message/internal/rfc2047"
 )
 
 type UnknownCharsetError struct {
@@ -50,6 +54,95 @@ func charsetReader(charset string, input io.Reader) (io.Reader, error) {
 	return input, UnknownCharsetError{fmt.Errorf("message: unhandled charset %q", charset)}
 }
 
+// CharsetDetector, if non-nil, defines a function to guess the charset of
+// text. It returns the charset name and a confidence between 0 and 1, or an
+// empty string if the charset cannot be detected. The text may be a prefix of
+// a longer text.
+//
+// New consults CharsetDetector for text entities without a charset parameter,
+// and for text entities whose body isn't valid in the declared us-ascii or
+// utf-8 charset. The detected charset is used if its confidence is at least
+// CharsetDetectionThreshold, and is reported by Entity.DetectedCharset.
+//
+// Importing github.com/emersion/go-message/charset doesn't set
+// CharsetDetector, but charset.Detect can be used.
+var CharsetDetector func(text []byte) (charset string, confidence float64)
+
+// CharsetDetectionThreshold is the minimum confidence of a charset returned
+// by CharsetDetector. Below this threshold, the declared charset is kept.
+var CharsetDetectionThreshold = 0.5
+
+// charsetDetectionBytes is the number of bytes passed to CharsetDetector.
+const charsetDetectionBytes = 16 * 1024
+
+// detectCharset peeks at the beginning of body and calls CharsetDetector if
+// the body isn't valid in the declared charset. An empty declared charset
+// means that the entity doesn't have a charset parameter.
+func detectCharset(declared string, body *bufio.Reader) (charset string, confidence float64) {
+	b, err := body.Peek(charsetDetectionBytes)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", 0
+	}
+
+	switch strings.ToLower(declared) {
+	case "", "us-ascii":
+		if isASCII(string(b)) {
+			return "", 0
+		}
+	case "utf-8":
+		// The sample may end in the middle of a sequence if it's truncated
+		if utf8.Valid(b) || (len(b) == charsetDetectionBytes && validUTF8Prefix(b)) {
+			return "", 0
+		}
+	default:
+		return "", 0
+	}
+
+	charset, confidence = CharsetDetector(b)
+	if confidence < CharsetDetectionThreshold {
+		return "", 0
+	}
+	return charset, confidence
+}
+
+// validUTF8Prefix reports whether b is valid UTF-8, ignoring an incomplete
+// sequence at the end.
+func validUTF8Prefix(b []byte) bool {
+	for i := 0; i < utf8.UTFMax && i < len(b); i++ {
+		if utf8.Valid(b[:len(b)-i]) {
+			return i == 0 || !utf8.FullRune(b[len(b)-i:])
+		}
+	}
+	return false
+}
+
+// CharsetWriter, if non-nil, defines a function to generate charset-conversion
+// writers, converting from UTF-8 into the provided charset. Charsets are always
+// lower-case. utf-8 and us-ascii charsets are handled by default. The returned
+// io.WriteCloser must be closed to flush any pending output, and its Write
+// method must return an error if the input contains a rune that cannot be
+// represented in the charset.
+//
+// Importing github.com/emersion/go-message/charset will set CharsetWriter to
+// a function that handles most common charsets.
+var CharsetWriter func(charset string, output io.Writer) (io.WriteCloser, error)
+
+// charsetWriter calls CharsetWriter if non-nil.
+func charsetWriter(charset string, output io.Writer) (io.WriteCloser, error) {
+	charset = strings.ToLower(charset)
+	if charset == "utf-8" || charset == "us-ascii" {
+		return nopCloser{output}, nil
+	}
+	if CharsetWriter != nil {
+		wc, err := CharsetWriter(charset, output)
+		if err != nil {
+			return nil, UnknownCharsetError{err}
+		}
+		return wc, nil
+	}
+	return nil, UnknownCharsetError{fmt.Errorf("message: unhandled charset %q", charset)}
+}
+
 // decodeHeader decodes an internationalized header field. If it fails, it
 // returns the input string and the error.
 func decodeHeader(s string) (string, error) {
@@ -61,6 +154,9 @@ func decodeHeader(s string) (string, error) {
 	return dec, nil
 }
 
-func encodeHeader(s string) string {
-	return mime.QEncoding.Encode("utf-8", s)
+// encodeHeader encodes an internationalized header field value with RFC 2047
+// encoded-words. k is the header field name, used to keep folded lines within
+// the preferred length.
+func encodeHeader(k, v string) string {
+	return rfc2047.EncodeText(v, len(k)+len(": "))
 }
@@ -1,19 +1,23 @@
 // Package charset provides functions to decode and encode charsets.
 //
 // It imports all supported charsets, which adds about 1MiB to binaries size.
-// Importing the package automatically sets message.CharsetReader.
+// Importing the package automatically sets message.CharsetReader and
+// message.CharsetWriter.
 package charset
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/emersion/go-message"
 	"golang.org/x/text/encoding"
 	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/encoding/htmlindex"
 	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/transform"
 )
 
 // Quirks table for charsets not handled by ianaindex
@@ -28,10 +32,11 @@ var charsets = map[string]encoding.Encoding{
 
 func init() {
 	message.CharsetReader = Reader
+	message.CharsetWriter = Writer
 }
 
-// Reader returns an io.Reader that converts the provided charset to UTF-8.
-func Reader(charset string, input io.Reader) (io.Reader, error) {
+// lookup returns the encoding for the provided charset.
+func lookup(charset string) (encoding.Encoding, error) {
 	var err error
 	enc, ok := charsets[strings.ToLower(charset)]
 	if ok && enc == nil {
@@ -52,9 +57,105 @@ func Reader(charset string, input io.Reader) (io.Reader, error) {
 	if enc == nil {
 		return nil, fmt.Errorf("charset %q: unsupported charset", charset)
 	}
+	return enc, nil
+}
+
+// Reader returns an io.Reader that converts the provided charset to UTF-8.
+func Reader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := lookup(charset)
+	if err != nil {
+		return nil, err
+	}
 	return enc.NewDecoder().Reader(input), nil
 }
 
+// Writer returns an io.WriteCloser that converts UTF-8 to the provided
+// charset. Close must be called to flush any pending output, e.g. the final
+// escape sequence of stateful charsets such as ISO-2022-JP.
+//
+// Write returns an error if the input contains a rune that cannot be
+// represented in the charset.
+func Writer(charset string, output io.Writer) (io.WriteCloser, error) {
+	enc, err := lookup(charset)
+	if err != nil {
+		return nil, err
+	}
+	return &encodeWriter{
+		charset: charset,
+		t:       enc.NewEncoder(),
+		w:       output,
+		dst:     make([]byte, 4096),
+	}, nil
+}
+
+// encodeWriter is similar to transform.Writer, but reports which rune could
+// not be encoded.
+type encodeWriter struct {
+	charset string
+	t       transform.Transformer
+	w       io.Writer
+
+	src []byte // pending input, not yet transformed
+	dst []byte
+	err error
+}
+
+func (w *encodeWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.src = append(w.src, b...)
+	if err := w.flush(false); err != nil {
+		w.err = err
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *encodeWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = w.flush(true)
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errors.New("charset: write to closed writer")
+	return nil
+}
+
+func (w *encodeWriter) flush(atEOF bool) error {
+	for {
+		nDst, nSrc, err := w.t.Transform(w.dst, w.src, atEOF)
+		if _, writeErr := w.w.Write(w.dst[:nDst]); writeErr != nil {
+			return writeErr
+		}
+		w.src = w.src[nSrc:]
+
+		switch err {
+		case nil:
+			return nil
+		case transform.ErrShortDst:
+			if nDst == 0 && nSrc == 0 {
+				w.dst = make([]byte, 2*len(w.dst))
+			}
+		case transform.ErrShortSrc:
+			if nSrc == 0 && atEOF {
+				return fmt.Errorf("charset %q: truncated UTF-8 input", w.charset)
+			} else if nSrc == 0 {
+				// Wait for the rest of the incomplete UTF-8 sequence
+				return nil
+			}
+		default:
+			if _, ok := err.(interface{ Replacement() byte }); ok {
+				r, _ := utf8.DecodeRune(w.src)
+				return fmt.Errorf("charset %q: cannot encode rune %q", w.charset, r)
+			}
+			return fmt.Errorf("charset %q: %v", w.charset, err)
+		}
+	}
+}
+
 // RegisterEncoding registers an encoding. This is intended to be called from
 // the init function in packages that want to support additional charsets.
 func RegisterEncoding(name string, enc encoding.Encoding) {
@@ -2,9 +2,12 @@ package charset
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"strings"
 	"testing"
+
+	"github.com/emersion/go-message"
 )
 
 var testCharsets = []struct {
@@ -86,3 +89,87 @@ func TestDisabledCharsetReader(t *testing.T) {
 		t.Errorf("Reader(): expected disabled charset to return an error")
 	}
 }
+
+var testCharsetWriters = []struct {
+	charset string
+	decoded string
+	encoded []byte
+}{
+	{
+		charset: "windows-1252",
+		decoded: "café €",
+		encoded: []byte{0x63, 0x61, 0x66, 0xE9, 0x20, 0x80},
+	},
+	{
+		charset: "windows-1251",
+		decoded: "Привет",
+		encoded: []byte{0xCF, 0xF0, 0xE8, 0xE2, 0xE5, 0xF2},
+	},
+	{
+		charset: "iso-2022-jp",
+		decoded: "テスト",
+		encoded: []byte("\x1b$B%F%9%H\x1b(B"),
+	},
+}
+
+func TestCharsetWriter(t *testing.T) {
+	for _, test := range testCharsetWriters {
+		var b bytes.Buffer
+		wc, err := Writer(test.charset, &b)
+		if err != nil {
+			t.Errorf("Expected no error when creating writer for charset %q, but got: %v", test.charset, err)
+			continue
+		}
+		// Write byte per byte to check that incomplete runes are buffered
+		for i := 0; i < len(test.decoded); i++ {
+			if _, err := wc.Write([]byte{test.decoded[i]}); err != nil {
+				t.Fatalf("Expected no error when writing charset %q, but got: %v", test.charset, err)
+			}
+		}
+		if err := wc.Close(); err != nil {
+			t.Errorf("Expected no error when closing writer for charset %q, but got: %v", test.charset, err)
+		} else if !bytes.Equal(b.Bytes(), test.encoded) {
+			t.Errorf("Expected encoded text to be %q but got %q", test.encoded, b.Bytes())
+		}
+	}
+}
+
+func TestCharsetWriter_unrepresentable(t *testing.T) {
+	var b bytes.Buffer
+	wc, err := Writer("iso-8859-1", &b)
+	if err != nil {
+		t.Fatalf("Expected no error when creating writer, but got: %v", err)
+	}
+	_, err = wc.Write([]byte("café €"))
+	if err == nil {
+		t.Fatal("Expected an error when writing an unrepresentable rune")
+	} else if !strings.Contains(err.Error(), "'€'") {
+		t.Errorf("Expected error to mention the unrepresentable rune, but got: %v", err)
+	}
+}
+
+func TestCreateWriter_charset(t *testing.T) {
+	var h message.Header
+	h.Set("Content-Type", "text/plain; charset=windows-1251")
+	h.Set("Content-Transfer-Encoding", "8bit")
+
+	var b bytes.Buffer
+	w, err := message.CreateWriter(&b, h)
+	if err != nil {
+		t.Fatalf("Expected no error when creating writer, but got: %v", err)
+	}
+	io.WriteString(w, "Привет")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Expected no error when closing writer, but got: %v", err)
+	}
+
+	e, err := message.Read(&b)
+	if err != nil {
+		t.Fatalf("Expected no error when reading message, but got: %v", err)
+	}
+	if body, err := ioutil.ReadAll(e.Body); err != nil {
+		t.Errorf("Expected no error when reading body, but got: %v", err)
+	} else if s := string(body); s != "Привет" {
+		t.Errorf("Expected body to be %q but got %q", "Привет", s)
+	}
+}
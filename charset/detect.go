@@ -0,0 +1,232 @@
+package charset
+
+import (
+	"bytes"
+	"math"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Candidates for legacy charset detection, in order of preference when
+// several charsets get the same score.
+var detectCandidates = []string{
+	"windows-1252",
+	"windows-1250",
+	"windows-1251",
+	"koi8-r",
+	"iso-8859-7",
+	"gbk",
+	"big5",
+	"euc-kr",
+	"euc-jp",
+	"shift_jis",
+}
+
+// Frequent non-ASCII runes in text written in the languages covered by
+// detectCandidates. Decoding text with the wrong charset rarely produces
+// them.
+var commonRunes = make(map[rune]bool)
+
+func init() {
+	for _, s := range []string{
+		// Latin
+		"àâäáãåæçèéêëíìîïñòóôöõøœùúûüýÿß",
+		"ąćęłńśźżčďěňřšťůžőű",
+		"ÀÂÄÁÇÈÉÊÍÎÓÔÖÚÜÑŁŚŻČŠŽ",
+		// Punctuation
+		" «»“”‘’–—…€°·",
+		// Cyrillic
+		"абвгдеёжзийклмнопрстуфхцчшщъыьэюяієїґ",
+		// Greek
+		"αβγδεζηθικλμνξοπρστυφχψωςάέήίόύώ",
+		// Chinese
+		"的一是不了在人有我他这這个個们們中来來上大为為和国國地到以说說时時要就出" +
+			"会會可也你对對生能而子那得于於着著下自之年过過发發后後作里裡用道行所然" +
+			"家种種事成方多经經么麼去法学學如都同现現当當没沒动動面起看定天分还還进" +
+			"進好小部其些主样樣理心她本前开開但因只从從想实實日者意无無力它与與长長" +
+			"把机機十民第公此已工使情明性知全三又关關点點正业業外将將两兩高间間由问" +
+			"問很最重并並物手应應向头頭文体體新己制身果加月话話合回特代内內信表化给" +
+			"給位次度门門任常先通教原东東名真论論处處走各入口认認条條平系气氣题題活",
+		// Japanese
+		"日本人年大中国出会時上見行分生子後前間手事自社発者地業方新場員立開同金定" +
+			"学長家明動体物今気化関合思言私何円度主要性回",
+		// Korean
+		"이다의는에하고을가한지로서기사대리자들도를수어있니습것요나해그인게정아시" +
+			"라으우일전부상과만거제보여내무주되면안소적치마화공장성동관문원신국비방" +
+			"계구년경말세연중물선께님했었할합니까",
+	} {
+		for _, r := range s {
+			commonRunes[r] = true
+		}
+	}
+	// Japanese kana are frequent, and only produced by charsets designed for
+	// Japanese text (or for Chinese text, which also include them)
+	for r := rune(0x3041); r <= 0x3093; r++ {
+		commonRunes[r] = true
+	}
+	for r := rune(0x30a1); r <= 0x30f6; r++ {
+		commonRunes[r] = true
+	}
+	commonRunes['ー'] = true
+}
+
+// Detect guesses the charset of text, which may be a prefix of a longer text.
+// It returns the charset name and a confidence between 0 and 1, or an empty
+// string if the charset cannot be detected.
+//
+// Detect recognizes byte order marks, ISO-2022-JP escape sequences, ASCII and
+// UTF-8. For other 8-bit text, it decodes the text with common legacy
+// charsets and picks the one producing the most plausible text. This
+// heuristic is only reliable for text with enough non-ASCII characters.
+//
+// Detect can be used as message.CharsetDetector.
+func Detect(text []byte) (charset string, confidence float64) {
+	switch {
+	case bytes.HasPrefix(text, []byte("\xef\xbb\xbf")):
+		return "utf-8", 1
+	case bytes.HasPrefix(text, []byte("\xfe\xff")), bytes.HasPrefix(text, []byte("\xff\xfe")):
+		return "utf-16", 1
+	}
+
+	ascii, multibyte, valid := scanUTF8(text)
+	switch {
+	case ascii && isISO2022JP(text):
+		return "iso-2022-jp", 0.95
+	case ascii:
+		return "us-ascii", 1
+	case valid && multibyte > 0:
+		return "utf-8", math.Min(0.99, 1-math.Pow(0.25, float64(multibyte)))
+	}
+
+	var best, second float64
+	var n int
+	for _, name := range detectCandidates {
+		enc, err := lookup(name)
+		if err != nil {
+			continue
+		}
+		decoded, err := enc.NewDecoder().Bytes(text)
+		if err != nil {
+			continue
+		}
+		score, runes := scoreText(decoded)
+		if charset == "" || score > best {
+			if charset != "" {
+				second = best
+			}
+			charset, best, n = name, score, runes
+		} else if score > second {
+			second = score
+		}
+	}
+	if charset == "" || best <= 0 {
+		return "", 0
+	}
+
+	// Lower the confidence if the best candidate isn't far ahead, or if there
+	// aren't enough non-ASCII characters to make a decision
+	confidence = math.Min(best, 1) * 0.9
+	if second > 0 {
+		confidence *= math.Min(1, 0.5+(best-second)/best)
+	}
+	confidence *= float64(n) / float64(n+2)
+	return charset, confidence
+}
+
+// scanUTF8 reports whether text is ASCII, the number of multibyte sequences
+// and whether text is valid UTF-8. An incomplete sequence at the end of text
+// is considered valid.
+func scanUTF8(text []byte) (ascii bool, multibyte int, valid bool) {
+	ascii = true
+	for len(text) > 0 {
+		if text[0] < utf8.RuneSelf {
+			text = text[1:]
+			continue
+		}
+		ascii = false
+		r, size := utf8.DecodeRune(text)
+		if r == utf8.RuneError && size <= 1 {
+			return false, multibyte, !utf8.FullRune(text)
+		}
+		multibyte++
+		text = text[size:]
+	}
+	return ascii, multibyte, true
+}
+
+// isISO2022JP reports whether text contains one of the escape sequences
+// switching to a JIS X 0208 character set, as defined in RFC 1468.
+func isISO2022JP(text []byte) bool {
+	return bytes.Contains(text, []byte("\x1b$B")) || bytes.Contains(text, []byte("\x1b$@"))
+}
+
+type script int
+
+const (
+	scriptOther script = iota
+	scriptLatin
+	scriptGreek
+	scriptCyrillic
+	scriptCJK
+	scriptHangul
+)
+
+func scriptOf(r rune) script {
+	switch {
+	case unicode.Is(unicode.Latin, r):
+		return scriptLatin
+	case unicode.Is(unicode.Greek, r):
+		return scriptGreek
+	case unicode.Is(unicode.Cyrillic, r):
+		return scriptCyrillic
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana):
+		return scriptCJK
+	case unicode.Is(unicode.Hangul, r):
+		return scriptHangul
+	default:
+		return scriptOther
+	}
+}
+
+// scoreText rates how plausible decoded text is. It returns the average score
+// of non-ASCII runes, at most 1, and the number of non-ASCII runes.
+func scoreText(b []byte) (float64, int) {
+	var score float64
+	var n int
+	var prev rune
+	for _, r := range string(b) {
+		if r >= utf8.RuneSelf {
+			n++
+			switch {
+			case r == utf8.RuneError || unicode.IsControl(r) || unicode.Is(unicode.Co, r):
+				score -= 4
+			case commonRunes[r]:
+				score++
+			case r >= 0xff61 && r <= 0xff9f:
+				// Half-width katakana are seldom used
+				score -= 0.5
+			case unicode.IsLetter(r):
+				score += 0.25
+			case unicode.IsPunct(r):
+				// Neutral
+			default:
+				score--
+			}
+
+			// Capitals and script changes are unusual in the middle of a word
+			if unicode.IsLetter(prev) && unicode.IsLetter(r) {
+				if unicode.IsUpper(r) {
+					score--
+				}
+				if scriptOf(prev) != scriptOf(r) {
+					score--
+				}
+			}
+		}
+		prev = r
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return score / float64(n), n
+}
@@ -0,0 +1,76 @@
+package charset
+
+import (
+	"testing"
+)
+
+var detectTests = []struct {
+	charset string
+	text    string
+}{
+	{"windows-1252", "Le café est très bon, à bientôt. Où est la forêt ?"},
+	{"windows-1250", "Zażółć gęślą jaźń. Przykład tekstu w języku polskim."},
+	{"windows-1251", "Привет, как дела? Это пример текста на русском языке."},
+	{"koi8-r", "Привет, как дела? Это пример текста на русском языке."},
+	{"iso-8859-7", "Καλημέρα, τι κάνεις; Αυτό είναι ένα ελληνικό κείμενο."},
+	{"gbk", "这是一个中文的例子，我们在这里测试字符集检测。"},
+	{"big5", "這是一個中文的例子，我們在這裡測試字元集偵測。"},
+	{"euc-kr", "안녕하세요. 이것은 한국어 문장입니다. 문자 집합을 감지합니다."},
+	{"euc-jp", "これは日本語の文章です。文字コードを判定します。"},
+	{"shift_jis", "これは日本語の文章です。文字コードを判定します。"},
+	{"iso-2022-jp", "これは日本語の文章です。"},
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range detectTests {
+		enc, err := lookup(tc.charset)
+		if err != nil {
+			t.Fatalf("lookup(%q) = %v", tc.charset, err)
+		}
+		b, err := enc.NewEncoder().Bytes([]byte(tc.text))
+		if err != nil {
+			t.Fatalf("Failed to encode %q as %v: %v", tc.text, tc.charset, err)
+		}
+
+		charset, confidence := Detect(b)
+		if charset != tc.charset {
+			t.Errorf("Detect(%q) = %q, want %q", tc.text, charset, tc.charset)
+		}
+		if confidence <= 0 || confidence > 1 {
+			t.Errorf("Detect(%q) returned confidence %v, want a value in (0, 1]", tc.text, confidence)
+		}
+	}
+}
+
+var detectUnicodeTests = []struct {
+	text       string
+	charset    string
+	confidence float64
+}{
+	{"hello", "us-ascii", 1},
+	{"\xef\xbb\xbfhello", "utf-8", 1},
+	{"\xff\xfeh\x00i\x00", "utf-16", 1},
+	{"\xfe\xff\x00h\x00i", "utf-16", 1},
+	{"日本語", "utf-8", 0.984375},
+	// Truncated multibyte sequence
+	{"日本\xe8\xaa", "utf-8", 0.9375},
+}
+
+func TestDetect_unicode(t *testing.T) {
+	for _, tc := range detectUnicodeTests {
+		charset, confidence := Detect([]byte(tc.text))
+		if charset != tc.charset || confidence != tc.confidence {
+			t.Errorf("Detect(%q) = %q, %v, want %q, %v", tc.text, charset, confidence, tc.charset, tc.confidence)
+		}
+	}
+}
+
+func TestDetect_lowConfidence(t *testing.T) {
+	charset, confidence := Detect([]byte("caf\xe9"))
+	if charset != "windows-1252" {
+		t.Errorf("Detect() = %q, want %q", charset, "windows-1252")
+	}
+	if confidence >= 0.5 {
+		t.Errorf("Detect() returned confidence %v for a short text, want less than 0.5", confidence)
+	}
+}
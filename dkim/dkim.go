@@ -0,0 +1,55 @@
+// Package dkim implements DomainKeys Identified Mail signing and verification.
+//
+// DKIM is defined in RFC 6376. Ed25519 signatures are defined in RFC 8463.
+//
+// Signing and verification operate on a textproto.Header: header fields are
+// hashed using their raw representation, so the header must not be
+// re-formatted between signing and sending, or between receiving and
+// verifying.
+package dkim
+
+import (
+	"errors"
+)
+
+// Canonicalization is a canonicalization algorithm.
+type Canonicalization string
+
+const (
+	CanonicalizationSimple  Canonicalization = "simple"
+	CanonicalizationRelaxed Canonicalization = "relaxed"
+)
+
+const headerFieldName = "DKIM-Signature"
+
+type permFailError string
+
+func (err permFailError) Error() string {
+	return "dkim: " + string(err)
+}
+
+// IsPermFail returns true if the error returned by Verify is a permanent
+// failure. A permanent failure is for instance a missing required field or a
+// malformed header.
+func IsPermFail(err error) bool {
+	return errors.As(err, new(permFailError))
+}
+
+type tempFailError string
+
+func (err tempFailError) Error() string {
+	return "dkim: " + string(err)
+}
+
+// IsTempFail returns true if the error returned by Verify is a temporary
+// failure, e.g. a DNS lookup failure.
+func IsTempFail(err error) bool {
+	return errors.As(err, new(tempFailError))
+}
+
+// failError is returned when the signature doesn't match the message.
+type failError string
+
+func (err failError) Error() string {
+	return "dkim: " + string(err)
+}
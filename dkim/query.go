@@ -0,0 +1,156 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+
+	"github.com/emersion/go-message/internal/dkimutil"
+)
+
+// A Resolver looks up DNS TXT records. It can be replaced to use a custom DNS
+// client, or an in-memory list of records in tests.
+type Resolver interface {
+	// LookupTXT returns the DNS TXT records for the given domain name.
+	LookupTXT(domain string) ([]string, error)
+}
+
+type netResolver struct{}
+
+func (netResolver) LookupTXT(domain string) ([]string, error) {
+	return net.LookupTXT(domain)
+}
+
+// DefaultResolver is the Resolver used if none is specified. It uses the
+// net package.
+var DefaultResolver Resolver = netResolver{}
+
+// A PublicKey is a public key fetched from a DNS TXT record, as defined in
+// RFC 6376 section 3.6.1.
+type PublicKey struct {
+	// Key is either an *rsa.PublicKey or an ed25519.PublicKey.
+	Key crypto.PublicKey
+	// HashAlgos is the list of acceptable hash algorithms. If empty, all
+	// algorithms are acceptable.
+	HashAlgos []string
+	// Notes contains human-readable notes.
+	Notes string
+	// Services is the list of service types. If empty, all service types are
+	// acceptable.
+	Services []string
+	// Flags is a list of flags, e.g. "y" (testing mode) and "s" (the
+	// identifier domain must be the signing domain).
+	Flags []string
+}
+
+func (pk *PublicKey) hasFlag(flag string) bool {
+	for _, f := range pk.Flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePublicKey parses a DKIM public key record.
+func ParsePublicKey(s string) (*PublicKey, error) {
+	tags, err := dkimutil.ParseTagList(s)
+	if err != nil {
+		return nil, permFailError("key syntax error: " + err.Error())
+	}
+
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, permFailError("incompatible public key version")
+	}
+
+	p, ok := tags["p"]
+	if !ok {
+		return nil, permFailError("key syntax error: missing public key data")
+	}
+	p = dkimutil.StripWhitespace(p)
+	if p == "" {
+		return nil, permFailError("key revoked")
+	}
+	b, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		return nil, permFailError("key syntax error: " + err.Error())
+	}
+
+	pk := &PublicKey{
+		Notes: tags["n"],
+	}
+
+	switch k := tags["k"]; k {
+	case "rsa", "":
+		// RFC 6376 says the key is a SubjectPublicKeyInfo, but some records
+		// contain an RSAPublicKey
+		pub, err := x509.ParsePKIXPublicKey(b)
+		if err != nil {
+			pub, err = x509.ParsePKCS1PublicKey(b)
+		}
+		if err != nil {
+			return nil, permFailError("key syntax error: " + err.Error())
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, permFailError("key syntax error: not an RSA public key")
+		}
+		// RFC 8301 section 3.2: verifiers must not consider signatures using
+		// RSA keys of less than 1024 bits as valid signatures
+		if rsaPub.Size()*8 < 1024 {
+			return nil, permFailError(fmt.Sprintf("key is too short: want 1024 bits, has %v bits", rsaPub.Size()*8))
+		}
+		pk.Key = rsaPub
+	case "ed25519":
+		if len(b) != ed25519.PublicKeySize {
+			return nil, permFailError(fmt.Sprintf("invalid Ed25519 public key size: %v bytes", len(b)))
+		}
+		pk.Key = ed25519.PublicKey(b)
+	default:
+		return nil, permFailError("unsupported key algorithm")
+	}
+
+	if h, ok := tags["h"]; ok {
+		pk.HashAlgos = dkimutil.SplitColonList(h)
+	}
+	if s, ok := tags["s"]; ok {
+		pk.Services = dkimutil.SplitColonList(s)
+	}
+	if t, ok := tags["t"]; ok {
+		pk.Flags = dkimutil.SplitColonList(t)
+	}
+
+	return pk, nil
+}
+
+// LookupPublicKey fetches the public key of a domain for the provided
+// selector, using the DNS TXT record at "<selector>._domainkey.<domain>".
+func LookupPublicKey(r Resolver, domain, selector string) (*PublicKey, error) {
+	txts, err := r.LookupTXT(selector + "._domainkey." + domain)
+	if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+		return nil, tempFailError("key unavailable: " + err.Error())
+	} else if err != nil {
+		return nil, permFailError("no key for signature: " + err.Error())
+	}
+
+	// RFC 6376 section 3.6.2.2: if there are multiple records, the result is
+	// undefined. Use the first valid one.
+	var firstErr error
+	for _, txt := range txts {
+		pk, err := ParsePublicKey(txt)
+		if err == nil {
+			return pk, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = permFailError("no key for signature")
+	}
+	return nil, firstErr
+}
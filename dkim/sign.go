@@ -0,0 +1,215 @@
+package dkim
+
+import (
+	"crypto"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/internal/dkimutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+// DefaultHeaderKeys is the list of header fields signed by default, as
+// recommended in RFC 6376 section 5.4.1. Only header fields present in the
+// message are signed.
+var DefaultHeaderKeys = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc",
+	"Resent-Date", "Resent-From", "Resent-To", "Resent-Cc",
+	"In-Reply-To", "References",
+	"List-Id", "List-Help", "List-Unsubscribe", "List-Subscribe", "List-Post",
+	"List-Owner", "List-Archive",
+	"Message-Id", "Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// SignOptions contains options for Sign.
+type SignOptions struct {
+	// The SDID claiming responsibility for the message (d= tag). Required.
+	Domain string
+	// The selector subdividing the namespace for the domain (s= tag).
+	// Required.
+	Selector string
+	// The Agent or User Identifier (i= tag). Optional.
+	Identifier string
+
+	// The key used to sign the message. Either an *rsa.PrivateKey or an
+	// ed25519.PrivateKey. Required.
+	Signer crypto.Signer
+
+	// Header and body canonicalization algorithms. If empty, the simple
+	// algorithm is used.
+	HeaderCanonicalization Canonicalization
+	BodyCanonicalization   Canonicalization
+
+	// A list of header fields to sign. If nil, the fields of DefaultHeaderKeys
+	// present in the header are signed. The list must contain From.
+	HeaderKeys []string
+	// Oversign adds each signed header key one more time than the number of
+	// fields with this key in the header. This prevents additional fields
+	// from being inserted after signing.
+	Oversign bool
+
+	// BodyLength adds a body length count (l= tag), set to the length of the
+	// canonicalized body.
+	BodyLength bool
+
+	// The signature timestamp (t= tag). If zero, the current time is used.
+	Time time.Time
+	// The signature expiration time (x= tag). If zero, the signature doesn't
+	// expire.
+	Expiration time.Time
+}
+
+// headerKeysToSign returns the list of header keys to sign.
+func headerKeysToSign(h *textproto.Header, opts *SignOptions) ([]string, error) {
+	keys := opts.HeaderKeys
+	if keys == nil {
+		for _, k := range DefaultHeaderKeys {
+			if h.Has(k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	hasFrom := false
+	for _, k := range keys {
+		if strings.EqualFold(k, "From") {
+			hasFrom = true
+			break
+		}
+	}
+	if !hasFrom {
+		return nil, errors.New("dkim: the From header field must be signed")
+	}
+
+	if !opts.Oversign {
+		return keys, nil
+	}
+
+	var l []string
+	seen := make(map[string]bool)
+	for _, k := range keys {
+		lk := strings.ToLower(k)
+		if seen[lk] {
+			continue
+		}
+		seen[lk] = true
+
+		n := h.FieldsByKey(k).Len() + 1
+		for i := 0; i < n; i++ {
+			l = append(l, k)
+		}
+	}
+	return l, nil
+}
+
+// Sign signs a message with the provided header and body. The DKIM-Signature
+// header field is added at the top of the header.
+//
+// The raw representation of the signed header fields is used: the header
+// must not be modified or re-formatted after signing.
+func Sign(h *textproto.Header, body io.Reader, opts *SignOptions) error {
+	if opts == nil {
+		return errors.New("dkim: no options specified")
+	}
+	if opts.Domain == "" {
+		return errors.New("dkim: no domain specified")
+	}
+	if opts.Selector == "" {
+		return errors.New("dkim: no selector specified")
+	}
+	if opts.Signer == nil {
+		return errors.New("dkim: no signer specified")
+	}
+	if opts.Identifier != "" && !strings.Contains(opts.Identifier, "@") {
+		return errors.New("dkim: invalid identifier: missing '@'")
+	}
+
+	algo := dkimutil.KeyAlgo(opts.Signer.Public())
+	if algo == "" {
+		return fmt.Errorf("dkim: unsupported key algorithm %T", opts.Signer.Public())
+	}
+
+	headerCan := opts.HeaderCanonicalization
+	if headerCan == "" {
+		headerCan = CanonicalizationSimple
+	}
+	bodyCan := opts.BodyCanonicalization
+	if bodyCan == "" {
+		bodyCan = CanonicalizationSimple
+	}
+	for _, c := range []Canonicalization{headerCan, bodyCan} {
+		if c != CanonicalizationSimple && c != CanonicalizationRelaxed {
+			return fmt.Errorf("dkim: unknown canonicalization %q", c)
+		}
+	}
+
+	keys, err := headerKeysToSign(h, opts)
+	if err != nil {
+		return err
+	}
+
+	// Compute the body hash
+	hasher := dkimutil.HashAlgo.New()
+	cw := &dkimutil.CountWriter{W: hasher}
+	bc := dkimutil.NewBodyCanonicalizer(cw, bodyCan == CanonicalizationRelaxed)
+	if _, err := io.Copy(bc, body); err != nil {
+		return err
+	}
+	if err := bc.Close(); err != nil {
+		return err
+	}
+	bodyHash := base64.StdEncoding.EncodeToString(hasher.Sum(nil))
+
+	t := opts.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	tags := [][2]string{
+		{"v", "1"},
+		{"a", algo + "-sha256"},
+		{"c", string(headerCan) + "/" + string(bodyCan)},
+		{"d", opts.Domain},
+		{"s", opts.Selector},
+	}
+	if opts.Identifier != "" {
+		tags = append(tags, [2]string{"i", opts.Identifier})
+	}
+	tags = append(tags, [2]string{"t", strconv.FormatInt(t.Unix(), 10)})
+	if !opts.Expiration.IsZero() {
+		tags = append(tags, [2]string{"x", strconv.FormatInt(opts.Expiration.Unix(), 10)})
+	}
+	if opts.BodyLength {
+		tags = append(tags, [2]string{"l", strconv.FormatInt(cw.N, 10)})
+	}
+	tags = append(tags,
+		[2]string{"h", strings.Join(keys, ":")},
+		[2]string{"bh", bodyHash},
+		[2]string{"b", ""},
+	)
+
+	// Compute the header hash
+	hasher.Reset()
+	relaxed := headerCan == CanonicalizationRelaxed
+	for _, raw := range dkimutil.SignedHeaderFields(h, keys) {
+		hasher.Write(dkimutil.CanonicalizeHeader(raw, relaxed))
+	}
+	sigField := dkimutil.CanonicalizeHeader(dkimutil.FormatTagList(headerFieldName, tags), relaxed)
+	sigField = sigField[:len(sigField)-2] // strip trailing CRLF
+	hasher.Write(sigField)
+	hashed := hasher.Sum(nil)
+
+	sig, err := dkimutil.Sign(opts.Signer, hashed)
+	if err != nil {
+		return fmt.Errorf("dkim: failed to sign: %v", err)
+	}
+
+	tags[len(tags)-1][1] = base64.StdEncoding.EncodeToString(sig)
+	h.AddRaw(dkimutil.FormatTagList(headerFieldName, tags))
+	return nil
+}
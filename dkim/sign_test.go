@@ -0,0 +1,170 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+const testMessage = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+const testBody = "Hi.\r\n\r\nWe lost the game.  Are you hungry yet?\r\n\r\nJoe.\r\n"
+
+func signTestMessage(t *testing.T, opts *SignOptions) string {
+	br := bufio.NewReader(strings.NewReader(testMessage))
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		t.Fatalf("ReadHeader() = %v", err)
+	}
+	if err := Sign(&h, br, opts); err != nil {
+		t.Fatalf("Sign() = %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := textproto.WriteHeader(&buf, h); err != nil {
+		t.Fatalf("WriteHeader() = %v", err)
+	}
+	buf.WriteString(testBody)
+	return buf.String()
+}
+
+func verifyTestMessage(t *testing.T, msg string, r Resolver) *Verification {
+	verifs, err := VerifyMessage(strings.NewReader(msg), &VerifyOptions{Resolver: r})
+	if err != nil {
+		t.Fatalf("VerifyMessage() = %v", err)
+	}
+	if len(verifs) != 1 {
+		t.Fatalf("Expected exactly one verification, got %v", len(verifs))
+	}
+	return verifs[0]
+}
+
+func TestSign_ed25519(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	key := ed25519.NewKeyFromSeed(seed)
+	pub := key.Public().(ed25519.PublicKey)
+	r := testResolver{
+		"test._domainkey.example.org": "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub),
+	}
+
+	for _, c := range []Canonicalization{CanonicalizationSimple, CanonicalizationRelaxed} {
+		msg := signTestMessage(t, &SignOptions{
+			Domain:                 "example.org",
+			Selector:               "test",
+			Signer:                 key,
+			HeaderCanonicalization: c,
+			BodyCanonicalization:   c,
+			Time:                   time.Unix(424242, 0),
+		})
+
+		v := verifyTestMessage(t, msg, r)
+		if v.Err != nil {
+			t.Fatalf("%v: expected signature to be valid, got: %v", c, v.Err)
+		}
+		if v.Domain != "example.org" {
+			t.Errorf("%v: expected domain %q, got %q", c, "example.org", v.Domain)
+		}
+		if !v.Time.Equal(time.Unix(424242, 0)) {
+			t.Errorf("%v: expected time %v, got %v", c, time.Unix(424242, 0), v.Time)
+		}
+	}
+}
+
+func TestSign_rsa(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("rsa.GenerateKey() = %v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("x509.MarshalPKIXPublicKey() = %v", err)
+	}
+	r := testResolver{
+		"test._domainkey.example.org": "v=DKIM1; p=" + base64.StdEncoding.EncodeToString(pub),
+	}
+
+	msg := signTestMessage(t, &SignOptions{
+		Domain:                 "example.org",
+		Selector:               "test",
+		Signer:                 key,
+		HeaderCanonicalization: CanonicalizationRelaxed,
+		BodyLength:             true,
+	})
+
+	v := verifyTestMessage(t, msg, r)
+	if v.Err != nil {
+		t.Fatalf("Expected signature to be valid, got: %v", v.Err)
+	}
+	if v.BodyLength != int64(len(testBody)) {
+		t.Errorf("Expected body length %v, got %v", len(testBody), v.BodyLength)
+	}
+
+	// Content appended after the signed body length is allowed
+	v = verifyTestMessage(t, msg+"Appended.\r\n", r)
+	if v.Err != nil {
+		t.Errorf("Expected signature with appended body to be valid, got: %v", v.Err)
+	}
+}
+
+func TestSign_oversign(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	r := testResolver{
+		"test._domainkey.example.org": "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+	}
+
+	msg := signTestMessage(t, &SignOptions{
+		Domain:     "example.org",
+		Selector:   "test",
+		Signer:     crypto.Signer(key),
+		HeaderKeys: []string{"From", "Subject"},
+		Oversign:   true,
+	})
+
+	v := verifyTestMessage(t, msg, r)
+	if v.Err != nil {
+		t.Fatalf("Expected signature to be valid, got: %v", v.Err)
+	}
+	want := []string{"From", "From", "Subject", "Subject"}
+	if strings.Join(v.HeaderKeys, ":") != strings.Join(want, ":") {
+		t.Errorf("Expected header keys %v, got %v", want, v.HeaderKeys)
+	}
+
+	// Adding a Subject field must break an oversigned signature
+	tampered := strings.Replace(msg, "Subject: Is dinner ready?\r\n", "Subject: Is dinner ready?\r\nSubject: Free money\r\n", 1)
+	if v := verifyTestMessage(t, tampered, r); v.Err == nil {
+		t.Errorf("Expected signature with added field to be invalid")
+	}
+}
+
+func TestSign_missingFrom(t *testing.T) {
+	var h textproto.Header
+	h.Set("Subject", "Hi")
+	err := Sign(&h, strings.NewReader(""), &SignOptions{
+		Domain:   "example.org",
+		Selector: "test",
+		Signer:   ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)),
+	})
+	if err == nil {
+		t.Errorf("Expected an error when signing a message without From")
+	}
+}
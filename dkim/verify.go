@@ -0,0 +1,339 @@
+package dkim
+
+import (
+	"bufio"
+	"encoding/base64"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/internal/dkimutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+// A Verification is produced by Verify when it checks if one signature is
+// valid. If the signature is valid, Err is nil.
+type Verification struct {
+	// The SDID claiming responsibility for the message.
+	Domain string
+	// The Agent or User Identifier (AUID) on behalf of which the SDID is
+	// taking responsibility.
+	Identifier string
+
+	// The list of signed header keys.
+	HeaderKeys []string
+
+	// The time when the signature was generated. If zero, the time is unknown.
+	Time time.Time
+	// The time after which the signature expires. If zero, the signature
+	// doesn't expire.
+	Expiration time.Time
+	// The number of body bytes covered by the signature. If negative, the
+	// whole body is signed.
+	BodyLength int64
+
+	// Err is nil if the signature is valid. It verifies IsPermFail or
+	// IsTempFail if the signature couldn't be checked.
+	Err error
+}
+
+// VerifyOptions contains options for Verify.
+type VerifyOptions struct {
+	// Resolver is used to fetch public keys. If nil, DefaultResolver is used.
+	Resolver Resolver
+	// MaxVerifications is the maximum number of signatures to verify. Zero
+	// means no limit.
+	MaxVerifications int
+	// Now returns the current time, used to check signature expiration. If
+	// nil, time.Now is used.
+	Now func() time.Time
+}
+
+// signature is a parsed DKIM-Signature header field.
+type signature struct {
+	v *Verification
+
+	raw      []byte
+	tags     map[string]string
+	algo     string // "rsa" or "ed25519"
+	headerC  Canonicalization
+	bodyC    Canonicalization
+	sig      []byte
+	bodyHash []byte
+
+	hasher hash.Hash
+	bc     *dkimutil.BodyCanonicalizer
+}
+
+func parseCanonicalization(s string) (header, body Canonicalization, err error) {
+	header, body = CanonicalizationSimple, CanonicalizationSimple
+	if s == "" {
+		return header, body, nil
+	}
+	parts := strings.SplitN(s, "/", 2)
+	header = Canonicalization(strings.TrimSpace(parts[0]))
+	if len(parts) == 2 {
+		body = Canonicalization(strings.TrimSpace(parts[1]))
+	}
+	for _, c := range []Canonicalization{header, body} {
+		if c != CanonicalizationSimple && c != CanonicalizationRelaxed {
+			return "", "", permFailError("unsupported canonicalization algorithm")
+		}
+	}
+	return header, body, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(dkimutil.StripWhitespace(s), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// isSubdomain reports whether d is sub or a subdomain of sub.
+func isSubdomain(d, sub string) bool {
+	d, sub = strings.ToLower(d), strings.ToLower(sub)
+	return d == sub || strings.HasSuffix(d, "."+sub)
+}
+
+// parseSignature parses a DKIM-Signature header field, as defined in RFC 6376
+// section 6.1.1.
+func parseSignature(raw []byte, now time.Time) (*signature, error) {
+	s := &signature{raw: raw, v: &Verification{BodyLength: -1}}
+
+	colon := strings.IndexByte(string(raw), ':')
+	tags, err := dkimutil.ParseTagList(string(raw[colon+1:]))
+	if err != nil {
+		return s, permFailError("malformed signature tags: " + err.Error())
+	}
+	s.tags = tags
+
+	if tags["v"] != "1" {
+		return s, permFailError("incompatible signature version")
+	}
+	for _, k := range []string{"a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[k]; !ok {
+			return s, permFailError("signature missing required tag " + k)
+		}
+	}
+
+	s.v.Domain = strings.TrimSpace(tags["d"])
+	s.v.HeaderKeys = dkimutil.SplitColonList(tags["h"])
+	hasFrom := false
+	for _, k := range s.v.HeaderKeys {
+		if strings.EqualFold(k, "From") {
+			hasFrom = true
+			break
+		}
+	}
+	if !hasFrom {
+		return s, permFailError("From field not signed")
+	}
+
+	if i, ok := tags["i"]; ok {
+		s.v.Identifier = strings.TrimSpace(i)
+		at := strings.LastIndexByte(s.v.Identifier, '@')
+		if at < 0 {
+			return s, permFailError("malformed identifier")
+		}
+		if !isSubdomain(s.v.Identifier[at+1:], s.v.Domain) {
+			return s, permFailError("domain mismatch")
+		}
+	} else {
+		s.v.Identifier = "@" + s.v.Domain
+	}
+
+	if q, ok := tags["q"]; ok {
+		found := false
+		for _, m := range dkimutil.SplitColonList(q) {
+			if m == "dns/txt" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return s, permFailError("unsupported public key query method")
+		}
+	}
+
+	if t, ok := tags["t"]; ok {
+		if s.v.Time, err = parseTime(t); err != nil {
+			return s, permFailError("malformed time: " + err.Error())
+		}
+	}
+	if x, ok := tags["x"]; ok {
+		if s.v.Expiration, err = parseTime(x); err != nil {
+			return s, permFailError("malformed expiration time: " + err.Error())
+		}
+		if now.After(s.v.Expiration) {
+			return s, permFailError("signature has expired")
+		}
+	}
+	if l, ok := tags["l"]; ok {
+		if s.v.BodyLength, err = strconv.ParseInt(dkimutil.StripWhitespace(l), 10, 64); err != nil || s.v.BodyLength < 0 {
+			return s, permFailError("malformed body length")
+		}
+	}
+
+	algo := strings.SplitN(strings.TrimSpace(tags["a"]), "-", 2)
+	if len(algo) != 2 || algo[1] != "sha256" {
+		return s, permFailError("unsupported hash algorithm")
+	}
+	s.algo = algo[0]
+
+	if s.headerC, s.bodyC, err = parseCanonicalization(strings.TrimSpace(tags["c"])); err != nil {
+		return s, err
+	}
+
+	if s.sig, err = base64.StdEncoding.DecodeString(dkimutil.StripWhitespace(tags["b"])); err != nil {
+		return s, permFailError("malformed signature: " + err.Error())
+	}
+	if s.bodyHash, err = base64.StdEncoding.DecodeString(dkimutil.StripWhitespace(tags["bh"])); err != nil {
+		return s, permFailError("malformed body hash: " + err.Error())
+	}
+
+	return s, nil
+}
+
+func (s *signature) verify(h *textproto.Header, r Resolver) error {
+	if bodyHash := s.hasher.Sum(nil); string(bodyHash) != string(s.bodyHash) {
+		return failError("body hash did not verify")
+	}
+
+	pk, err := LookupPublicKey(r, s.v.Domain, strings.TrimSpace(s.tags["s"]))
+	if err != nil {
+		return err
+	}
+
+	if dkimutil.KeyAlgo(pk.Key) != s.algo {
+		return permFailError("inappropriate key algorithm")
+	}
+	if len(pk.HashAlgos) > 0 {
+		found := false
+		for _, algo := range pk.HashAlgos {
+			if algo == "sha256" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return permFailError("inappropriate hash algorithm")
+		}
+	}
+	if len(pk.Services) > 0 {
+		found := false
+		for _, service := range pk.Services {
+			if service == "*" || service == "email" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return permFailError("inappropriate service")
+		}
+	}
+	if pk.hasFlag("s") {
+		at := strings.LastIndexByte(s.v.Identifier, '@')
+		if !strings.EqualFold(s.v.Identifier[at+1:], s.v.Domain) {
+			return permFailError("domain mismatch")
+		}
+	}
+
+	relaxed := s.headerC == CanonicalizationRelaxed
+	hasher := dkimutil.HashAlgo.New()
+	for _, raw := range dkimutil.SignedHeaderFields(h, s.v.HeaderKeys) {
+		hasher.Write(dkimutil.CanonicalizeHeader(raw, relaxed))
+	}
+	sigField := dkimutil.CanonicalizeHeader(dkimutil.RemoveSignature(s.raw), relaxed)
+	sigField = sigField[:len(sigField)-2] // strip trailing CRLF
+	hasher.Write(sigField)
+	hashed := hasher.Sum(nil)
+
+	if err := dkimutil.Verify(pk.Key, hashed, s.sig); err != nil {
+		return failError("signature did not verify: " + err.Error())
+	}
+	return nil
+}
+
+// Verify checks the DKIM signatures of a message with the provided header and
+// body. It returns one Verification per signature, in the order they appear
+// in the header. An error is returned only if the body cannot be read.
+func Verify(h *textproto.Header, body io.Reader, opts *VerifyOptions) ([]*Verification, error) {
+	if opts == nil {
+		opts = new(VerifyOptions)
+	}
+	r := opts.Resolver
+	if r == nil {
+		r = DefaultResolver
+	}
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
+	}
+
+	var sigs []*signature
+	var writers []io.Writer
+	fields := h.FieldsByKey(headerFieldName)
+	for fields.Next() {
+		if opts.MaxVerifications > 0 && len(sigs) >= opts.MaxVerifications {
+			sigs = append(sigs, &signature{v: &Verification{
+				BodyLength: -1,
+				Err:        tempFailError("too many signatures"),
+			}})
+			continue
+		}
+
+		raw, err := fields.Raw()
+		if err != nil {
+			sigs = append(sigs, &signature{v: &Verification{
+				BodyLength: -1,
+				Err:        permFailError("malformed signature: " + err.Error()),
+			}})
+			continue
+		}
+
+		s, err := parseSignature(raw, now)
+		sigs = append(sigs, s)
+		if err != nil {
+			s.v.Err = err
+			continue
+		}
+
+		s.hasher = dkimutil.HashAlgo.New()
+		s.bc = dkimutil.NewBodyCanonicalizer(&dkimutil.LimitWriter{W: s.hasher, N: s.v.BodyLength}, s.bodyC == CanonicalizationRelaxed)
+		writers = append(writers, s.bc)
+	}
+
+	// Read the body once to compute all body hashes
+	if _, err := io.Copy(io.MultiWriter(writers...), body); err != nil {
+		return nil, err
+	}
+
+	verifs := make([]*Verification, len(sigs))
+	for i, s := range sigs {
+		verifs[i] = s.v
+		if s.v.Err != nil {
+			continue
+		}
+		if err := s.bc.Close(); err != nil {
+			s.v.Err = err
+			continue
+		}
+		s.v.Err = s.verify(h, r)
+	}
+	return verifs, nil
+}
+
+// VerifyMessage reads a message from r and checks its DKIM signatures. See
+// Verify.
+func VerifyMessage(r io.Reader, opts *VerifyOptions) ([]*Verification, error) {
+	br := bufio.NewReader(r)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	return Verify(&h, br, opts)
+}
@@ -0,0 +1,117 @@
+package dkim
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testResolver map[string]string
+
+func (r testResolver) LookupTXT(domain string) ([]string, error) {
+	txt, ok := r[domain]
+	if !ok {
+		return nil, fmt.Errorf("no TXT record for %q", domain)
+	}
+	return []string{txt}, nil
+}
+
+// Example from RFC 8463 appendix A
+var testEd25519Resolver = testResolver{
+	"brisbane._domainkey.football.example.com": "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=",
+}
+
+const testEd25519SignedMessage = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+func TestVerify_ed25519(t *testing.T) {
+	verifs, err := VerifyMessage(strings.NewReader(testEd25519SignedMessage), &VerifyOptions{
+		Resolver: testEd25519Resolver,
+	})
+	if err != nil {
+		t.Fatalf("VerifyMessage() = %v", err)
+	}
+	if len(verifs) != 1 {
+		t.Fatalf("Expected exactly one verification, got %v", len(verifs))
+	}
+
+	v := verifs[0]
+	if v.Err != nil {
+		t.Fatalf("Expected signature to be valid, got: %v", v.Err)
+	}
+	if v.Domain != "football.example.com" {
+		t.Errorf("Expected domain %q, got %q", "football.example.com", v.Domain)
+	}
+	if v.Identifier != "@football.example.com" {
+		t.Errorf("Expected identifier %q, got %q", "@football.example.com", v.Identifier)
+	}
+	if want := time.Unix(1528637909, 0); !v.Time.Equal(want) {
+		t.Errorf("Expected time %v, got %v", want, v.Time)
+	}
+	if v.BodyLength != -1 {
+		t.Errorf("Expected no body length, got %v", v.BodyLength)
+	}
+}
+
+func TestVerify_tampered(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{"body", strings.Replace(testEd25519SignedMessage, "hungry", "angry", 1)},
+		{"header", strings.Replace(testEd25519SignedMessage, "dinner", "lunch", 1)},
+	}
+	for _, test := range tests {
+		verifs, err := VerifyMessage(strings.NewReader(test.msg), &VerifyOptions{
+			Resolver: testEd25519Resolver,
+		})
+		if err != nil {
+			t.Fatalf("%v: VerifyMessage() = %v", test.name, err)
+		}
+		if len(verifs) != 1 || verifs[0].Err == nil {
+			t.Errorf("%v: expected signature to be invalid", test.name)
+		}
+	}
+}
+
+func TestVerify_noKey(t *testing.T) {
+	verifs, err := VerifyMessage(strings.NewReader(testEd25519SignedMessage), &VerifyOptions{
+		Resolver: testResolver{},
+	})
+	if err != nil {
+		t.Fatalf("VerifyMessage() = %v", err)
+	}
+	if len(verifs) != 1 || !IsPermFail(verifs[0].Err) {
+		t.Errorf("Expected a permanent failure, got: %v", verifs[0].Err)
+	}
+}
+
+func TestVerify_expired(t *testing.T) {
+	msg := strings.Replace(testEd25519SignedMessage, "t=1528637909;", "t=1528637909; x=1528637910;", 1)
+	verifs, err := VerifyMessage(strings.NewReader(msg), &VerifyOptions{
+		Resolver: testEd25519Resolver,
+	})
+	if err != nil {
+		t.Fatalf("VerifyMessage() = %v", err)
+	}
+	if len(verifs) != 1 || !IsPermFail(verifs[0].Err) {
+		t.Errorf("Expected a permanent failure, got: %v", verifs[0].Err)
+	}
+}
@@ -1,11 +1,14 @@
 package message
 
 import (
+	"bytes"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime/quotedprintable"
+	"os"
 	"strings"
 
 	"github.com/emersion/go-textwrapper"
@@ -50,6 +53,20 @@ func (nopCloser) Close() error {
 	return nil
 }
 
+// multiCloser closes all of its io.Closers in order and returns the first
+// error.
+type multiCloser []io.Closer
+
+func (mc multiCloser) Close() error {
+	var err error
+	for _, c := range mc {
+		if closeErr := c.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
 func encodingWriter(enc string, w io.Writer) (io.WriteCloser, error) {
 	var wc io.WriteCloser
 	switch strings.ToLower(enc) {
@@ -66,3 +83,278 @@ func encodingWriter(enc string, w io.Writer) (io.WriteCloser, error) {
 	}
 	return wc, nil
 }
+
+// maxAutoEncodingMemory is the size above which autoEncoder spools the body to
+// a temporary file.
+const maxAutoEncodingMemory = 1 << 20 // 1 MB
+
+// bodyStats records properties of a body used to select a
+// Content-Transfer-Encoding.
+type bodyStats struct {
+	n          int64 // number of bytes
+	qpLen      int64 // size of the quoted-printable encoded body
+	qpLineLen  int   // length of the current quoted-printable encoded line
+	qpWSP      bool  // the current quoted-printable encoded line ends with whitespace
+	lineLen    int   // length of the current line
+	maxLineLen int   // length of the longest line
+
+	has8bit   bool
+	hasNUL    bool
+	hasBareCR bool
+	hasBareLF bool
+
+	cr bool // the last byte was a CR
+}
+
+func (s *bodyStats) addQP(n int) {
+	// Soft line breaks are inserted before the 76th character
+	if s.qpLineLen+n > 75 {
+		s.qpLen += 3 // "=\r\n"
+		s.qpLineLen = 0
+	}
+	s.qpLen += int64(n)
+	s.qpLineLen += n
+}
+
+func (s *bodyStats) endLine() {
+	if s.lineLen > s.maxLineLen {
+		s.maxLineLen = s.lineLen
+	}
+	s.lineLen = 0
+	if s.qpWSP {
+		// Whitespace at the end of a line is encoded, e.g. as "=20"
+		s.qpLen += 2
+		s.qpWSP = false
+	}
+	s.qpLen += 2 // "\r\n"
+	s.qpLineLen = 0
+}
+
+func (s *bodyStats) Write(b []byte) (int, error) {
+	for _, c := range b {
+		if s.cr && c != '\n' {
+			s.hasBareCR = true
+			s.addQP(3)
+			s.qpWSP = false
+			s.lineLen++
+		}
+
+		switch {
+		case c == '\r':
+			s.cr = true
+			s.n++
+			continue
+		case c == '\n':
+			if !s.cr {
+				s.hasBareLF = true
+			}
+			s.endLine()
+		case c == 0:
+			s.hasNUL = true
+			s.addQP(3)
+			s.lineLen++
+		case c >= 0x80:
+			s.has8bit = true
+			s.addQP(3)
+			s.lineLen++
+		case c == '=' || (c < ' ' && c != '\t') || c == 0x7f:
+			s.addQP(3)
+			s.lineLen++
+		default:
+			s.addQP(1)
+			s.qpWSP = c == ' ' || c == '\t'
+			s.lineLen++
+			s.cr = false
+			s.n++
+			continue
+		}
+		s.qpWSP = false
+		s.cr = false
+		s.n++
+	}
+	return len(b), nil
+}
+
+func base64Len(n int64) int64 {
+	l := (n + 2) / 3 * 4
+	return l + (l+75)/76*2 // "\r\n" every 76 characters
+}
+
+// qpEncodedLen returns the size of the quoted-printable encoded body.
+func (s *bodyStats) qpEncodedLen() int64 {
+	if s.qpWSP {
+		// Trailing whitespace is encoded
+		return s.qpLen + 2
+	}
+	return s.qpLen
+}
+
+// encoding returns the Content-Transfer-Encoding producing the smallest valid
+// result. If text is true, line breaks can be converted to CRLF.
+func (s *bodyStats) encoding(text bool) string {
+	hasBareCR := s.hasBareCR || s.cr
+	maxLineLen := s.maxLineLen
+	if s.lineLen > maxLineLen {
+		maxLineLen = s.lineLen
+	}
+
+	if !s.has8bit && !s.hasNUL && !hasBareCR && (text || !s.hasBareLF) && maxLineLen <= 998 {
+		return "7bit"
+	}
+	// quoted-printable can't represent binary data with arbitrary line breaks
+	if !text || s.hasNUL || hasBareCR {
+		return "base64"
+	}
+	if s.qpEncodedLen() <= base64Len(s.n) {
+		return "quoted-printable"
+	}
+	return "base64"
+}
+
+// crlfWriter converts bare LF line breaks to CRLF.
+type crlfWriter struct {
+	w  io.Writer
+	cr bool
+}
+
+func (w *crlfWriter) Write(b []byte) (int, error) {
+	n := 0
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			break
+		}
+
+		cr := w.cr
+		if i > 0 {
+			cr = b[i-1] == '\r'
+		}
+		if _, err := w.w.Write(b[:i]); err != nil {
+			return n, err
+		}
+		nl := "\n"
+		if !cr {
+			nl = "\r\n"
+		}
+		if _, err := io.WriteString(w.w, nl); err != nil {
+			return n, err
+		}
+		n += i + 1
+		b = b[i+1:]
+		w.cr = false
+	}
+	if len(b) > 0 {
+		w.cr = b[len(b)-1] == '\r'
+	}
+	m, err := w.w.Write(b)
+	return n + m, err
+}
+
+// autoEncoder buffers an entity's body to select its
+// Content-Transfer-Encoding. The header is written when the autoEncoder is
+// closed.
+type autoEncoder struct {
+	header      *Header
+	text        bool
+	writeHeader headerWriterFunc
+
+	stats  bodyStats
+	buf    bytes.Buffer
+	f      *os.File
+	err    error // sticky write error
+	closed bool
+}
+
+func newAutoEncoder(header *Header, text bool, writeHeader headerWriterFunc) *autoEncoder {
+	return &autoEncoder{header: header, text: text, writeHeader: writeHeader}
+}
+
+func (ae *autoEncoder) Write(b []byte) (int, error) {
+	if ae.closed {
+		return 0, errors.New("message: write to a closed entity")
+	}
+	if ae.err != nil {
+		return 0, ae.err
+	}
+
+	ae.stats.Write(b)
+
+	if ae.f == nil && ae.buf.Len()+len(b) > maxAutoEncodingMemory {
+		f, err := ioutil.TempFile("", "go-message-")
+		if err != nil {
+			ae.err = err
+			return 0, err
+		}
+		ae.f = f
+		if _, err := ae.buf.WriteTo(f); err != nil {
+			ae.fail(err)
+			return 0, err
+		}
+	}
+
+	if ae.f != nil {
+		n, err := ae.f.Write(b)
+		if err != nil {
+			ae.fail(err)
+		}
+		return n, err
+	}
+	return ae.buf.Write(b)
+}
+
+// fail records a write error and removes the temporary file, if any.
+func (ae *autoEncoder) fail(err error) {
+	ae.err = err
+	ae.removeFile()
+	ae.buf.Reset()
+}
+
+// removeFile closes and removes the temporary file, if any.
+func (ae *autoEncoder) removeFile() {
+	if ae.f == nil {
+		return
+	}
+	ae.f.Close()
+	os.Remove(ae.f.Name())
+	ae.f = nil
+}
+
+func (ae *autoEncoder) Close() error {
+	if ae.closed {
+		return nil
+	}
+	ae.closed = true
+	defer ae.removeFile()
+	if ae.err != nil {
+		return ae.err
+	}
+
+	var body io.Reader = &ae.buf
+	if ae.f != nil {
+		if _, err := ae.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		body = ae.f
+	}
+
+	enc := ae.stats.encoding(ae.text)
+	ae.header.Set("Content-Transfer-Encoding", enc)
+
+	w, err := ae.writeHeader(ae.header)
+	if err != nil {
+		return err
+	}
+	wc, err := encodingWriter(enc, w)
+	if err != nil {
+		return err
+	}
+
+	var dst io.Writer = wc
+	if enc == "7bit" && ae.stats.hasBareLF {
+		dst = &crlfWriter{w: wc}
+	}
+	if _, err := io.Copy(dst, body); err != nil {
+		return err
+	}
+	return wc.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"mime/quotedprintable"
 	"strings"
 	"testing"
 )
@@ -71,3 +72,115 @@ func TestEncode(t *testing.T) {
 		}
 	}
 }
+
+var testAutoEncodings = []struct {
+	name string
+	text bool
+	body string
+	enc  string
+}{
+	{
+		name: "ascii text",
+		text: true,
+		body: "Hello world!\r\nHow are you?\r\n",
+		enc:  "7bit",
+	},
+	{
+		name: "ascii text with bare LF",
+		text: true,
+		body: "Hello world!\nHow are you?\n",
+		enc:  "7bit",
+	},
+	{
+		name: "mostly ascii text",
+		text: true,
+		body: "Plan de complémentarité de l'Homme\r\n",
+		enc:  "quoted-printable",
+	},
+	{
+		name: "non-latin text",
+		text: true,
+		body: "Привет, как дела?\r\n",
+		enc:  "base64",
+	},
+	{
+		name: "long line",
+		text: true,
+		body: strings.Repeat("a", 1000),
+		enc:  "quoted-printable",
+	},
+	{
+		name: "text with NUL",
+		text: true,
+		body: "Hello\x00world",
+		enc:  "base64",
+	},
+	{
+		name: "text with bare CR",
+		text: true,
+		body: "Hello\rworld",
+		enc:  "base64",
+	},
+	{
+		name: "ascii binary",
+		text: false,
+		body: "Hello world!\r\n",
+		enc:  "7bit",
+	},
+	{
+		name: "ascii binary with bare LF",
+		text: false,
+		body: "Hello world!\n",
+		enc:  "base64",
+	},
+	{
+		name: "binary",
+		text: false,
+		body: "caf\xc3\xa9",
+		enc:  "base64",
+	},
+}
+
+func TestBodyStats_encoding(t *testing.T) {
+	for _, test := range testAutoEncodings {
+		var stats bodyStats
+		// Write byte per byte to check that state is kept between writes
+		for i := 0; i < len(test.body); i++ {
+			stats.Write([]byte{test.body[i]})
+		}
+		if enc := stats.encoding(test.text); enc != test.enc {
+			t.Errorf("%v: expected encoding %q but got %q", test.name, test.enc, enc)
+		}
+	}
+}
+
+func TestBodyStats_qpEncodedLen(t *testing.T) {
+	bodies := []string{
+		"Hello world!\r\n",
+		"Plan de complémentarité\r\n",
+		"Trailing space \r\nand tab\t\r\nat the end ",
+	}
+	for _, body := range bodies {
+		var stats bodyStats
+		stats.Write([]byte(body))
+
+		var b bytes.Buffer
+		qpw := quotedprintable.NewWriter(&b)
+		io.WriteString(qpw, body)
+		qpw.Close()
+
+		if got, want := stats.qpEncodedLen(), int64(b.Len()); got != want {
+			t.Errorf("qpEncodedLen(%q) = %v, want %v", body, got, want)
+		}
+	}
+}
+
+func TestCRLFWriter(t *testing.T) {
+	var b bytes.Buffer
+	w := &crlfWriter{w: &b}
+	io.WriteString(w, "a\nb\r")
+	io.WriteString(w, "\nc\n")
+	if s, expected := b.String(), "a\r\nb\r\nc\r\n"; s != expected {
+		t.Errorf("Expected %q but got %q", expected, s)
+	}
+}
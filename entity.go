@@ -2,6 +2,7 @@ package message
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"io"
 	"math"
@@ -18,10 +19,76 @@ type Entity struct {
 
 	mediaType   string
 	mediaParams map[string]string
+
+	// rs is non-nil if the entity has been read with ReadWithOptions
+	rs    *readState
+	depth int // multipart nesting level
+
+	embedded    *Entity // parsed embedded message, if any
+	embeddedErr error
+	embeddedOK  bool // the embedded message has been parsed
+
+	detectedCharset   string // set if CharsetDetector has been used
+	charsetConfidence float64
+
+	raw *rawBody // nil if the entity has been created by NewMultipart
+}
+
+// recordingReader keeps a copy of the bytes read from r while record is set.
+type recordingReader struct {
+	r        io.Reader
+	n        int64 // number of bytes read from r
+	recorded []byte
+	record   bool
+}
+
+func (rr *recordingReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.n += int64(n)
+	if rr.record {
+		rr.recorded = append(rr.recorded, p[:n]...)
+	}
+	return n, err
+}
+
+// rawBody keeps track of the undecoded body of an entity. The bytes consumed
+// by New, e.g. for charset detection, are recorded.
+type rawBody struct {
+	recordingReader
+
+	header []byte // original header, nil if unknown
+
+	// The decoded body and the header fields it depends on, as created by New
+	body        io.Reader
+	contentType string
+	encoding    string
+	charset     string // charset used to decode the body, if any
+
+	bodyRead bool // Body has been read
+	consumed bool // a reader has been returned by rawReader
+}
+
+var errBodyConsumed = errors.New("message: the entity body has been consumed by BodyWithOptions")
+
+// entityBody is the Body of an entity created by New. It keeps track of
+// reads, and fails once the body has been consumed by BodyWithOptions.
+type entityBody struct {
+	r   io.Reader
+	raw *rawBody
+}
+
+func (eb *entityBody) Read(p []byte) (int, error) {
+	if eb.raw.consumed {
+		return 0, errBodyConsumed
+	}
+	eb.raw.bodyRead = true
+	return eb.r.Read(p)
 }
 
 // New makes a new message with the provided header and body. The entity's
-// transfer encoding and charset are automatically decoded to UTF-8.
+// transfer encoding and charset are automatically decoded to UTF-8. If
+// CharsetDetector is set, it's used to decode text entities with a missing
+// or mislabeled charset.
 //
 // If the message uses an unknown transfer encoding or charset, New returns an
 // error that verifies IsUnknownCharset, but also returns an Entity that can
@@ -29,6 +96,17 @@ type Entity struct {
 func New(header Header, body io.Reader) (*Entity, error) {
 	var err error
 
+	var raw *rawBody
+	if _, ok := body.(*multipartBody); !ok {
+		// Record what is consumed by the charset detection
+		raw = &rawBody{
+			recordingReader: recordingReader{r: body, record: true},
+			contentType:     header.Get("Content-Type"),
+			encoding:        header.Get("Content-Transfer-Encoding"),
+		}
+		body = raw
+	}
+
 	mediaType, mediaParams, _ := header.ContentType()
 
 	// QUIRK: RFC 2045 section 6.4 specifies that multipart messages can't have
@@ -46,24 +124,56 @@ func New(header Header, body io.Reader) (*Entity, error) {
 	}
 
 	// RFC 2046 section 4.1.2: charset only applies to text/*
+	var detected string
+	var confidence float64
 	if strings.HasPrefix(mediaType, "text/") {
-		if ch, ok := mediaParams["charset"]; ok {
+		ch, ok := mediaParams["charset"]
+		if CharsetDetector != nil {
+			br := bufio.NewReaderSize(body, charsetDetectionBytes)
+			body = br
+			if detected, confidence = detectCharset(ch, br); detected != "" {
+				ch, ok = detected, true
+			}
+		}
+		if ok {
 			if converted, charsetErr := charsetReader(ch, body); charsetErr != nil {
 				err = UnknownCharsetError{charsetErr}
 			} else {
 				body = converted
 			}
+			if raw != nil {
+				raw.charset = ch
+			}
 		}
 	}
 
+	if raw != nil {
+		raw.record = false
+		body = &entityBody{r: body, raw: raw}
+		raw.body = body
+	}
+
 	return &Entity{
-		Header:      header,
-		Body:        body,
-		mediaType:   mediaType,
-		mediaParams: mediaParams,
+		Header:            header,
+		Body:              body,
+		mediaType:         mediaType,
+		mediaParams:       mediaParams,
+		detectedCharset:   detected,
+		charsetConfidence: confidence,
+		raw:               raw,
 	}, err
 }
 
+// DetectedCharset returns the charset detected by CharsetDetector for this
+// entity's body, and the confidence of the detection between 0 and 1. If
+// CharsetDetector hasn't been used, it returns an empty string.
+//
+// When a charset has been detected, it overrides the charset parameter of the
+// Content-Type header field to decode the body.
+func (e *Entity) DetectedCharset() (charset string, confidence float64) {
+	return e.detectedCharset, e.charsetConfidence
+}
+
 // NewMultipart makes a new multipart message with the provided header and
 // parts. The Content-Type header must begin with "multipart/".
 //
@@ -81,17 +191,20 @@ func NewMultipart(header Header, parts []*Entity) (*Entity, error) {
 
 const maxHeaderBytes = 1 << 20 // 1 MB
 
-var errHeaderTooBig = errors.New("message: header exceeds maximum size")
+// LimitExceededError is returned when a message exceeds one of the limits set
+// in ReadOptions.
+type LimitExceededError = textproto.LimitExceededError
 
 // limitedReader is the same as io.LimitedReader, but returns a custom error.
 type limitedReader struct {
-	R io.Reader
-	N int64
+	R   io.Reader
+	N   int64
+	Err error
 }
 
 func (lr *limitedReader) Read(p []byte) (int, error) {
 	if lr.N <= 0 {
-		return 0, errHeaderTooBig
+		return 0, lr.Err
 	}
 	if int64(len(p)) > lr.N {
 		p = p[0:lr.N]
@@ -101,6 +214,54 @@ func (lr *limitedReader) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// ReadOptions contains options for ReadWithOptions. Limits apply to the
+// message header, and to the parts of multipart messages as they are read.
+type ReadOptions struct {
+	// MaxHeaderBytes is the maximum size of a header in bytes. Zero means
+	// 1 MB, a negative value means no limit.
+	MaxHeaderBytes int64
+	// MaxHeaderFields is the maximum number of fields in a header. Zero means
+	// no limit.
+	MaxHeaderFields int
+	// MaxHeaderLineLength is the maximum length of a header field in bytes,
+	// including continuation lines. Zero means no limit.
+	MaxHeaderLineLength int
+	// MaxMultipartDepth is the maximum nesting level of multipart entities.
+	// Embedded messages count as a nesting level. Zero means no limit.
+	MaxMultipartDepth int
+	// MaxParts is the maximum total number of parts in the message, at all
+	// nesting levels. Zero means no limit.
+	MaxParts int
+	// LenientMultipart enables recovery from malformed multipart structures,
+	// e.g. a missing final boundary. See
+	// textproto.MultipartReaderOptions.Lenient.
+	LenientMultipart bool
+}
+
+func (opts *ReadOptions) maxHeaderBytes() int64 {
+	if opts.MaxHeaderBytes == 0 {
+		return maxHeaderBytes
+	}
+	return opts.MaxHeaderBytes
+}
+
+// readState is shared by all entities of a message read with ReadWithOptions.
+type readState struct {
+	opts  ReadOptions
+	parts int // number of parts read so far
+}
+
+func (rs *readState) headerOptions() *textproto.ReadHeaderOptions {
+	opts := &textproto.ReadHeaderOptions{
+		MaxFields:     rs.opts.MaxHeaderFields,
+		MaxLineLength: rs.opts.MaxHeaderLineLength,
+	}
+	if max := rs.opts.maxHeaderBytes(); max > 0 {
+		opts.MaxBytes = max
+	}
+	return opts
+}
+
 // Read reads a message from r. The message's encoding and charset are
 // automatically decoded to raw UTF-8. Note that this function only reads the
 // message header.
@@ -108,18 +269,57 @@ func (lr *limitedReader) Read(p []byte) (int, error) {
 // If the message uses an unknown transfer encoding or charset, Read returns an
 // error that verifies IsUnknownCharset or IsUnknownEncoding, but also returns
 // an Entity that can be read.
+//
+// The header of the message and the header of each part are limited to 1 MB.
+// If a header is larger, an error of type LimitExceededError is returned. Use
+// ReadWithOptions to change this limit.
 func Read(r io.Reader) (*Entity, error) {
-	lr := &limitedReader{R: r, N: maxHeaderBytes}
-	br := bufio.NewReader(lr)
+	return ReadWithOptions(r, nil)
+}
+
+// ReadWithOptions is like Read, but with options. If opts is nil, the defaults
+// are used.
+//
+// If the message exceeds one of the limits set in opts, an error of type
+// LimitExceededError is returned, either by ReadWithOptions or when reading
+// parts.
+func ReadWithOptions(r io.Reader, opts *ReadOptions) (*Entity, error) {
+	if opts == nil {
+		opts = new(ReadOptions)
+	}
+	return readWithState(r, &readState{opts: *opts})
+}
 
-	h, err := textproto.ReadHeader(br)
+func readWithState(r io.Reader, rs *readState) (*Entity, error) {
+	opts := &rs.opts
+
+	var lr *limitedReader
+	if max := opts.maxHeaderBytes(); max > 0 {
+		lr = &limitedReader{R: r, N: max, Err: LimitExceededError{Limit: "header size", Max: max}}
+		r = lr
+	}
+	// Keep the original header, to be able to write it back unchanged
+	rr := &recordingReader{r: r, record: true}
+	br := bufio.NewReader(rr)
+
+	hopts := rs.headerOptions()
+	hopts.MaxBytes = 0 // already enforced by limitedReader
+	h, _, err := textproto.ReadHeaderWithOptions(br, hopts)
 	if err != nil {
 		return nil, err
 	}
+	rawHeader := rr.recorded[:len(rr.recorded)-br.Buffered()]
+	rr.record = false
+	rr.recorded = nil
 
-	lr.N = math.MaxInt64
+	if lr != nil {
+		lr.N = math.MaxInt64
+	}
 
-	return New(Header{h}, br)
+	e, err := New(Header{h}, br)
+	e.rs = rs
+	e.raw.header = rawHeader
+	return e, err
 }
 
 // MultipartReader returns a MultipartReader that reads parts from this entity's
@@ -131,7 +331,58 @@ func (e *Entity) MultipartReader() MultipartReader {
 	if mb, ok := e.Body.(*multipartBody); ok {
 		return mb
 	}
-	return &multipartReader{textproto.NewMultipartReader(e.Body, e.mediaParams["boundary"])}
+	if e.rs == nil {
+		return &multipartReader{r: textproto.NewMultipartReader(e.Body, e.mediaParams["boundary"])}
+	}
+
+	if max := e.rs.opts.MaxMultipartDepth; max > 0 && e.depth >= max {
+		return &multipartReader{err: LimitExceededError{Limit: "multipart depth", Max: int64(max)}}
+	}
+	r := textproto.NewMultipartReaderWithOptions(e.Body, e.mediaParams["boundary"], &textproto.MultipartReaderOptions{
+		Header:  e.rs.headerOptions(),
+		Lenient: e.rs.opts.LenientMultipart,
+	})
+	return &multipartReader{r: r, rs: e.rs, depth: e.depth + 1}
+}
+
+// isEmbeddedMessage reports whether the media type designates an embedded
+// message, as defined in RFC 2046 section 5.2.1 and RFC 6532 section 3.7.
+func isEmbeddedMessage(mediaType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	return mediaType == "message/rfc822" || mediaType == "message/global"
+}
+
+// EmbeddedMessage parses the message embedded in this entity's body. If this
+// entity is not a message/rfc822 or message/global entity, it returns nil.
+//
+// The embedded message is parsed on the first call, which consumes the
+// entity's body. Subsequent calls return the same Entity. If the entity has
+// been read with ReadWithOptions, the same limits apply to the embedded
+// message.
+//
+// If the embedded message uses an unknown transfer encoding or charset,
+// EmbeddedMessage returns an error that verifies IsUnknownCharset, but also
+// returns an Entity that can be read.
+func (e *Entity) EmbeddedMessage() (*Entity, error) {
+	if !isEmbeddedMessage(e.mediaType) {
+		return nil, nil
+	}
+	if !e.embeddedOK {
+		e.embeddedOK = true
+		if e.rs != nil {
+			if max := e.rs.opts.MaxMultipartDepth; max > 0 && e.depth >= max {
+				e.embeddedErr = LimitExceededError{Limit: "multipart depth", Max: int64(max)}
+				return nil, e.embeddedErr
+			}
+			e.embedded, e.embeddedErr = readWithState(e.Body, e.rs)
+			if e.embedded != nil {
+				e.embedded.depth = e.depth + 1
+			}
+		} else {
+			e.embedded, e.embeddedErr = Read(e.Body)
+		}
+	}
+	return e.embedded, e.embeddedErr
 }
 
 // writeBodyTo writes this entity's body to w (without the header).
@@ -145,8 +396,147 @@ func (e *Entity) writeBodyTo(w *Writer) error {
 	return err
 }
 
+// rawReader returns a reader for the undecoded body. It fails if the body has
+// already been read. Once it has been called, reading Body fails.
+func (e *Entity) rawReader() (io.Reader, error) {
+	raw := e.raw
+	if raw == nil {
+		return nil, errors.New("message: the undecoded body of a generated multipart entity is unavailable")
+	}
+	if raw.consumed {
+		return nil, errBodyConsumed
+	}
+	if raw.bodyRead {
+		return nil, errors.New("message: the entity body has already been read")
+	}
+	raw.consumed = true
+	return io.MultiReader(bytes.NewReader(raw.recorded), raw), nil
+}
+
+// BodyOptions contains options for Entity.BodyWithOptions.
+type BodyOptions struct {
+	// NoTransferDecoding disables Content-Transfer-Encoding decoding.
+	NoTransferDecoding bool
+	// NoCharsetDecoding disables the conversion of text entities to UTF-8.
+	// The charset is left as-is. It must be set if NoTransferDecoding is set.
+	NoCharsetDecoding bool
+}
+
+// BodyWithOptions returns a new reader for this entity's body, decoded
+// according to opts. If opts is nil, the body is fully decoded like Body.
+//
+// The returned reader and Body read from the same underlying stream: only one
+// of them can be used. BodyWithOptions fails if Body has already been read,
+// and reading Body fails once BodyWithOptions has been called.
+//
+// If the entity uses an unknown transfer encoding or charset,
+// BodyWithOptions returns an error that verifies IsUnknownEncoding or
+// IsUnknownCharset, but also returns a reader for the body without the
+// corresponding decoding step.
+func (e *Entity) BodyWithOptions(opts *BodyOptions) (io.Reader, error) {
+	if opts == nil {
+		opts = new(BodyOptions)
+	}
+	if opts.NoTransferDecoding && !opts.NoCharsetDecoding {
+		// The charset can't be decoded before the transfer encoding
+		return nil, errors.New("message: charset decoding requires transfer decoding")
+	}
+
+	r, err := e.rawReader()
+	if err != nil {
+		return nil, err
+	}
+
+	// See New
+	if !opts.NoTransferDecoding && !strings.HasPrefix(e.mediaType, "multipart/") {
+		if decoded, encErr := encodingReader(e.raw.encoding, r); encErr != nil {
+			err = UnknownEncodingError{encErr}
+		} else {
+			r = decoded
+		}
+	}
+	if !opts.NoCharsetDecoding && e.raw.charset != "" {
+		if converted, charsetErr := charsetReader(e.raw.charset, r); charsetErr != nil {
+			err = UnknownCharsetError{charsetErr}
+		} else {
+			r = converted
+		}
+	}
+
+	return r, err
+}
+
+// RawBody returns a new reader for this entity's body as it appears in the
+// message, without any transfer encoding or charset decoding. This is the
+// same as BodyWithOptions with both decoding steps disabled.
+func (e *Entity) RawBody() (io.Reader, error) {
+	return e.BodyWithOptions(&BodyOptions{NoTransferDecoding: true, NoCharsetDecoding: true})
+}
+
+// preservedBodyReader returns a reader for the undecoded body if the entity
+// has been read with ReadWithOptions, if its body hasn't been read or
+// replaced and if the header fields describing the body encoding haven't been
+// modified. Otherwise, it returns nil.
+func (e *Entity) preservedBodyReader() io.Reader {
+	raw := e.raw
+	if e.rs == nil || raw == nil || e.Body != raw.body {
+		return nil
+	}
+	if e.Header.Get("Content-Type") != raw.contentType || e.Header.Get("Content-Transfer-Encoding") != raw.encoding {
+		return nil
+	}
+	r, err := e.rawReader()
+	if err != nil {
+		return nil
+	}
+	return r
+}
+
+// writeRawHeaderTo writes the original header if it hasn't been modified, or
+// the header fields otherwise.
+func (e *Entity) writeRawHeaderTo(w io.Writer) error {
+	var cur bytes.Buffer
+	if err := textproto.WriteHeader(&cur, e.Header.Header); err != nil {
+		return err
+	}
+	if rawHeader := e.raw.header; rawHeader != nil {
+		var orig bytes.Buffer
+		h, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(rawHeader)))
+		if err == nil {
+			err = textproto.WriteHeader(&orig, h)
+		}
+		if err == nil && bytes.Equal(orig.Bytes(), cur.Bytes()) {
+			_, err := w.Write(rawHeader)
+			return err
+		}
+	}
+	_, err := w.Write(cur.Bytes())
+	return err
+}
+
 // WriteTo writes this entity's header and body to w.
+//
+// If the entity has been read with Read or ReadWithOptions and its body
+// hasn't been read or replaced, the original bytes of the body are written
+// unchanged. The original header is written too if it hasn't been modified,
+// otherwise only the unmodified header fields are kept as-is. This preserves
+// signatures, e.g. DKIM or S/MIME ones. In other cases, the body is encoded
+// again according to the header.
+//
+// WriteTo fails if the body has been consumed by BodyWithOptions or RawBody
+// and hasn't been replaced.
 func (e *Entity) WriteTo(w io.Writer) error {
+	if e.raw != nil && e.raw.consumed && e.Body == e.raw.body {
+		return errBodyConsumed
+	}
+	if raw := e.preservedBodyReader(); raw != nil {
+		if err := e.writeRawHeaderTo(w); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, raw)
+		return err
+	}
+
 	ew, err := CreateWriter(w, e.Header)
 	if err != nil {
 		return err
@@ -167,14 +557,42 @@ func (e *Entity) WriteTo(w io.Writer) error {
 // Unlike IMAP part paths, indices start from 0 (instead of 1) and a
 // non-multipart message has a nil path (instead of {1}).
 //
+// If WalkOptions.Embedded is set, the path of a message embedded in a
+// message/rfc822 or message/global entity is the path of this entity followed
+// by EmbeddedPathIndex.
+//
 // If an error is returned, processing stops.
 type WalkFunc func(path []int, entity *Entity, err error) error
 
+// EmbeddedPathIndex is the path index of an embedded message in WalkFunc.
+const EmbeddedPathIndex = -1
+
+// WalkOptions contains options for Entity.WalkWithOptions.
+type WalkOptions struct {
+	// Embedded enables descending into messages embedded in message/rfc822
+	// and message/global entities.
+	Embedded bool
+}
+
 // Walk walks the entity's multipart tree, calling walkFunc for each part in
 // the tree, including the root entity.
 //
 // Walk consumes the entity.
 func (e *Entity) Walk(walkFunc WalkFunc) error {
+	return e.WalkWithOptions(walkFunc, nil)
+}
+
+// WalkWithOptions is like Walk, but with options. If opts is nil, the defaults
+// are used.
+//
+// If the entity has been read with ReadWithOptions, walking stops with an
+// error of type LimitExceededError as soon as the message exceeds the
+// multipart depth or part limits set in ReadOptions.
+func (e *Entity) WalkWithOptions(walkFunc WalkFunc, opts *WalkOptions) error {
+	if opts == nil {
+		opts = new(WalkOptions)
+	}
+
 	var multipartReaders []MultipartReader
 	var path []int
 	part := e
@@ -187,6 +605,7 @@ func (e *Entity) Walk(walkFunc WalkFunc) error {
 
 			// Get the next part from the last multipart reader
 			mr := multipartReaders[len(multipartReaders)-1]
+			_, embedded := mr.(*embeddedReader)
 			part, err = mr.NextPart()
 			if err == io.EOF {
 				multipartReaders = multipartReaders[:len(multipartReaders)-1]
@@ -198,7 +617,9 @@ func (e *Entity) Walk(walkFunc WalkFunc) error {
 				return err
 			}
 
-			path[len(path)-1]++
+			if !embedded {
+				path[len(path)-1]++
+			}
 		}
 
 		// Copy the path since we'll mutate it on the next iteration
@@ -215,6 +636,9 @@ func (e *Entity) Walk(walkFunc WalkFunc) error {
 		if mr := part.MultipartReader(); mr != nil {
 			multipartReaders = append(multipartReaders, mr)
 			path = append(path, -1)
+		} else if opts.Embedded && isEmbeddedMessage(part.mediaType) {
+			multipartReaders = append(multipartReaders, &embeddedReader{e: part})
+			path = append(path, EmbeddedPathIndex)
 		}
 
 		part = nil
@@ -222,3 +646,34 @@ func (e *Entity) Walk(walkFunc WalkFunc) error {
 
 	return nil
 }
+
+// embeddedReader is a MultipartReader returning the message embedded in an
+// entity as its only part.
+type embeddedReader struct {
+	e    *Entity
+	done bool
+}
+
+// NextPart implements MultipartReader.
+func (r *embeddedReader) NextPart() (*Entity, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+	r.done = true
+	return r.e.EmbeddedMessage()
+}
+
+// Preamble implements MultipartPreambleEpilogueReader.
+func (r *embeddedReader) Preamble() []byte {
+	return nil
+}
+
+// Epilogue implements MultipartPreambleEpilogueReader.
+func (r *embeddedReader) Epilogue() ([]byte, error) {
+	return nil, nil
+}
+
+// Close implements io.Closer.
+func (r *embeddedReader) Close() error {
+	return nil
+}
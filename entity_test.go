@@ -3,6 +3,7 @@ package message
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"reflect"
@@ -157,8 +158,9 @@ func TestRead_tooBig(t *testing.T) {
 		"\r\n" +
 		"This header is too big.\r\n"
 	_, err := Read(strings.NewReader(raw))
-	if err != errHeaderTooBig {
-		t.Fatalf("Read() = %q, want %q", err, errHeaderTooBig)
+	want := LimitExceededError{Limit: "header size", Max: 1 << 20}
+	if err != want {
+		t.Fatalf("Read() = %q, want %q", err, want)
 	}
 }
 
@@ -221,6 +223,233 @@ func TestEntity_WriteTo_multipart(t *testing.T) {
 	}
 }
 
+const testRawMessage = "From: Mitsuha Miyamizu <mitsuha.miyamizu@example.org>\n" +
+	"Subject:   Your Name.\n" +
+	"\tfolded with a tab\n" +
+	"Content-Type: multipart/mixed;\n" +
+	"  boundary=\"IMTHEBOUNDARY\"\n" +
+	"\n" +
+	"Preamble\n" +
+	"--IMTHEBOUNDARY\n" +
+	"Content-Type: text/plain; charset=utf-8\n" +
+	"Content-Transfer-Encoding: quoted-printable\n" +
+	"\n" +
+	"Caf=C3=A9 with a soft=\n" +
+	" line break\n" +
+	"--IMTHEBOUNDARY\n" +
+	"Content-Type: application/octet-stream\n" +
+	"Content-Transfer-Encoding: base64\n" +
+	"\n" +
+	"SGVsbG8g\n" +
+	"d29ybGQh\n" +
+	"--IMTHEBOUNDARY--\n" +
+	"Epilogue\n"
+
+func TestEntity_WriteTo_raw(t *testing.T) {
+	e, err := Read(strings.NewReader(testRawMessage))
+	if err != nil {
+		t.Fatal("Expected no error while reading entity, got", err)
+	}
+
+	var b bytes.Buffer
+	if err := e.WriteTo(&b); err != nil {
+		t.Fatal("Expected no error while writing entity, got", err)
+	}
+	if s := b.String(); s != testRawMessage {
+		t.Errorf("Expected written entity to be:\n%q\nbut got:\n%q", testRawMessage, s)
+	}
+}
+
+func TestEntity_WriteTo_rawModifiedHeader(t *testing.T) {
+	e, err := Read(strings.NewReader(testRawMessage))
+	if err != nil {
+		t.Fatal("Expected no error while reading entity, got", err)
+	}
+	e.Header.Set("X-Spam", "no")
+
+	var b bytes.Buffer
+	if err := e.WriteTo(&b); err != nil {
+		t.Fatal("Expected no error while writing entity, got", err)
+	}
+	// Unmodified fields are kept, but line endings are normalized
+	i := strings.Index(testRawMessage, "\n\n") + 2
+	want := "X-Spam: no\r\n" + strings.Replace(testRawMessage[:i], "\n", "\r\n", -1) + testRawMessage[i:]
+	if b.String() != want {
+		t.Errorf("Expected written entity to be:\n%q\nbut got:\n%q", want, b.String())
+	}
+}
+
+func TestEntity_WriteTo_rawDetectCharset(t *testing.T) {
+	defer func(cd func([]byte) (string, float64)) {
+		CharsetDetector = cd
+	}(CharsetDetector)
+	CharsetDetector = func(b []byte) (string, float64) {
+		return "utf-8", 1
+	}
+
+	raw := "Content-Type: text/plain\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Caf=C3=A9 =\r\n" +
+		"au lait\r\n"
+	e, err := Read(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Expected no error while reading entity, got", err)
+	}
+	if charset, _ := e.DetectedCharset(); charset != "utf-8" {
+		t.Fatalf("Expected detected charset to be utf-8, got %q", charset)
+	}
+
+	var b bytes.Buffer
+	if err := e.WriteTo(&b); err != nil {
+		t.Fatal("Expected no error while writing entity, got", err)
+	}
+	if s := b.String(); s != raw {
+		t.Errorf("Expected written entity to be:\n%q\nbut got:\n%q", raw, s)
+	}
+}
+
+func TestEntity_WriteTo_rawModifiedEncoding(t *testing.T) {
+	raw := "Content-Type: text/plain\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"Caf=C3=A9\r\n"
+	e, err := Read(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Expected no error while reading entity, got", err)
+	}
+	e.Header.Set("Content-Transfer-Encoding", "base64")
+
+	var b bytes.Buffer
+	if err := e.WriteTo(&b); err != nil {
+		t.Fatal("Expected no error while writing entity, got", err)
+	}
+	want := "Mime-Version: 1.0\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Q2Fmw6kNCg=="
+	if s := b.String(); s != want {
+		t.Errorf("Expected written entity to be:\n%q\nbut got:\n%q", want, s)
+	}
+}
+
+func TestEntity_BodyWithOptions(t *testing.T) {
+	defer func(cr func(string, io.Reader) (io.Reader, error)) {
+		CharsetReader = cr
+	}(CharsetReader)
+	CharsetReader = latin1Reader
+
+	const raw = "Content-Type: text/plain; charset=iso-8859-1\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"Q2Fm6SBh\r\n" +
+		"dSBsYWl0\r\n"
+
+	tests := []struct {
+		name string
+		opts *BodyOptions
+		want string
+	}{
+		{"decoded", nil, "Café au lait"},
+		{"transfer", &BodyOptions{NoCharsetDecoding: true}, "Caf\xe9 au lait"},
+		{"raw", &BodyOptions{NoTransferDecoding: true, NoCharsetDecoding: true}, "Q2Fm6SBh\r\ndSBsYWl0\r\n"},
+	}
+	for _, test := range tests {
+		e, err := Read(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("%v: Read() = %v", test.name, err)
+		}
+
+		r, err := e.BodyWithOptions(test.opts)
+		if err != nil {
+			t.Fatalf("%v: BodyWithOptions() = %v", test.name, err)
+		}
+		if b, err := ioutil.ReadAll(r); err != nil {
+			t.Errorf("%v: Expected no error while reading body, got %v", test.name, err)
+		} else if string(b) != test.want {
+			t.Errorf("%v: Expected body to be %q, but got %q", test.name, test.want, string(b))
+		}
+
+		if _, err := e.RawBody(); err == nil {
+			t.Errorf("%v: Expected an error when reading the body twice", test.name)
+		}
+		if _, err := e.Body.Read(make([]byte, 1)); err == nil {
+			t.Errorf("%v: Expected an error when reading Body after BodyWithOptions", test.name)
+		}
+		if err := e.WriteTo(ioutil.Discard); err == nil {
+			t.Errorf("%v: Expected an error when writing the entity after BodyWithOptions", test.name)
+		}
+	}
+
+	e, err := Read(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if _, err := e.BodyWithOptions(&BodyOptions{NoTransferDecoding: true}); err == nil {
+		t.Errorf("Expected an error when decoding the charset without the transfer encoding")
+	}
+}
+
+func TestEntity_RawBody_afterBody(t *testing.T) {
+	defer func(detector func([]byte) (string, float64)) {
+		CharsetDetector = detector
+	}(CharsetDetector)
+	CharsetDetector = func([]byte) (string, float64) { return "", 0 }
+
+	e, err := Read(strings.NewReader("Content-Type: text/plain\r\n\r\nHello world"))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	// The first bytes are served from the charset detection buffer
+	if _, err := e.Body.Read(make([]byte, 5)); err != nil {
+		t.Fatalf("Body.Read() = %v", err)
+	}
+	if _, err := e.RawBody(); err == nil {
+		t.Errorf("Expected an error when getting the raw body after reading Body")
+	}
+}
+
+func TestEntity_RawBody_multipart(t *testing.T) {
+	e, err := Read(strings.NewReader(testRawMessage))
+	if err != nil {
+		t.Fatal("Expected no error while reading entity, got", err)
+	}
+
+	var bodies []string
+	mr := e.MultipartReader()
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("Expected no error while reading part, got", err)
+		}
+		r, err := p.RawBody()
+		if err != nil {
+			t.Fatal("Expected no error while getting raw body, got", err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal("Expected no error while reading raw body, got", err)
+		}
+		bodies = append(bodies, string(b))
+	}
+
+	want := []string{"Caf=C3=A9 with a soft=\n line break", "SGVsbG8g\nd29ybGQh"}
+	if !reflect.DeepEqual(bodies, want) {
+		t.Errorf("Expected raw bodies to be %q, but got %q", want, bodies)
+	}
+}
+
+func TestEntity_RawBody_generatedMultipart(t *testing.T) {
+	e := testMakeMultipart()
+	if _, err := e.RawBody(); err == nil {
+		t.Error("Expected an error when getting the raw body of a generated multipart entity")
+	}
+}
+
 func TestNew_unknownTransferEncoding(t *testing.T) {
 	var h Header
 	h.Set("Content-Transfer-Encoding", "i-dont-exist")
@@ -268,6 +497,123 @@ func TestNew_unknownCharset(t *testing.T) {
 	}
 }
 
+var newDetectCharsetTests = []struct {
+	name        string
+	contentType string
+	body        string
+	detected    string
+	decoded     string
+}{
+	{
+		name:        "missing",
+		contentType: "text/plain",
+		body:        "caf\xe9",
+		detected:    "iso-8859-1",
+		decoded:     "café",
+	},
+	{
+		name:        "mislabeledASCII",
+		contentType: "text/plain; charset=us-ascii",
+		body:        "caf\xe9",
+		detected:    "iso-8859-1",
+		decoded:     "café",
+	},
+	{
+		name:        "mislabeledUTF8",
+		contentType: "text/plain; charset=utf-8",
+		body:        "caf\xe9",
+		detected:    "iso-8859-1",
+		decoded:     "café",
+	},
+	{
+		name:        "validUTF8",
+		contentType: "text/plain; charset=utf-8",
+		body:        "café",
+		decoded:     "café",
+	},
+	{
+		name:        "ascii",
+		contentType: "text/plain",
+		body:        "cafe",
+		decoded:     "cafe",
+	},
+	{
+		name:        "declared",
+		contentType: "text/plain; charset=iso-8859-1",
+		body:        "caf\xe9",
+		decoded:     "café",
+	},
+	{
+		name:        "notText",
+		contentType: "application/octet-stream",
+		body:        "caf\xe9",
+		decoded:     "caf\xe9",
+	},
+}
+
+func TestNew_detectCharset(t *testing.T) {
+	defer func(cr func(string, io.Reader) (io.Reader, error), cd func([]byte) (string, float64)) {
+		CharsetReader = cr
+		CharsetDetector = cd
+	}(CharsetReader, CharsetDetector)
+	CharsetReader = latin1Reader
+	CharsetDetector = func(b []byte) (string, float64) {
+		return "iso-8859-1", 0.5
+	}
+
+	for _, tc := range newDetectCharsetTests {
+		t.Run(tc.name, func(t *testing.T) {
+			var h Header
+			h.Set("Content-Type", tc.contentType)
+
+			e, err := New(h, strings.NewReader(tc.body))
+			if err != nil {
+				t.Fatalf("New() = %v", err)
+			}
+
+			wantConfidence := 0.5
+			if tc.detected == "" {
+				wantConfidence = 0
+			}
+			if charset, confidence := e.DetectedCharset(); charset != tc.detected || confidence != wantConfidence {
+				t.Errorf("DetectedCharset() = %q, %v, want %q, %v", charset, confidence, tc.detected, wantConfidence)
+			}
+
+			if b, err := ioutil.ReadAll(e.Body); err != nil {
+				t.Error("Expected no error while reading entity body, got", err)
+			} else if s := string(b); s != tc.decoded {
+				t.Errorf("Expected %q as entity body but got %q", tc.decoded, s)
+			}
+		})
+	}
+}
+
+func TestNew_detectCharsetThreshold(t *testing.T) {
+	defer func(cr func(string, io.Reader) (io.Reader, error), cd func([]byte) (string, float64)) {
+		CharsetReader = cr
+		CharsetDetector = cd
+	}(CharsetReader, CharsetDetector)
+	CharsetReader = latin1Reader
+	CharsetDetector = func(b []byte) (string, float64) {
+		return "iso-8859-1", 0.2
+	}
+
+	var h Header
+	h.Set("Content-Type", "text/plain; charset=utf-8")
+	e, err := New(h, strings.NewReader("Caf\xe9"))
+	if err != nil {
+		t.Fatalf("New() = %v", err)
+	}
+	if charset, _ := e.DetectedCharset(); charset != "" {
+		t.Errorf("Expected a low-confidence detection to be ignored, got %q", charset)
+	}
+	if b, err := ioutil.ReadAll(e.Body); err != nil {
+		t.Error("Expected no error while reading entity body, got", err)
+	} else if s := string(b); s != "Caf\xe9" {
+		t.Errorf("Expected %q as entity body but got %q", "Caf\xe9", s)
+	}
+}
+
 func TestNewEntity_MultipartReader_notMultipart(t *testing.T) {
 	e := testMakeEntity()
 	mr := e.MultipartReader()
@@ -357,3 +703,230 @@ func TestWalk_multipart(t *testing.T) {
 		t.Errorf("Entity.Walk() =\n%#v\nbut want:\n%#v", got, want)
 	}
 }
+
+const testEmbeddedText = "Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"See attached\r\n" +
+	"--outer\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"Subject: Forwarded\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Text part\r\n" +
+	"--inner--\r\n" +
+	"--outer--\r\n"
+
+func TestEntity_EmbeddedMessage(t *testing.T) {
+	e, err := Read(strings.NewReader(testEmbeddedText))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	if embedded, err := e.EmbeddedMessage(); embedded != nil || err != nil {
+		t.Errorf("EmbeddedMessage() on a multipart entity = %v, %v, want nil", embedded, err)
+	}
+
+	mr := e.MultipartReader()
+	if _, err := mr.NextPart(); err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+
+	embedded, err := p.EmbeddedMessage()
+	if err != nil {
+		t.Fatalf("EmbeddedMessage() = %v", err)
+	}
+	if subject := embedded.Header.Get("Subject"); subject != "Forwarded" {
+		t.Errorf("Expected subject %q, got %q", "Forwarded", subject)
+	}
+	if again, _ := p.EmbeddedMessage(); again != embedded {
+		t.Errorf("Expected EmbeddedMessage() to return the same entity twice")
+	}
+	if embedded.MultipartReader() == nil {
+		t.Errorf("Expected a multipart embedded message")
+	}
+}
+
+func TestWalkWithOptions_embedded(t *testing.T) {
+	e, err := Read(strings.NewReader(testEmbeddedText))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	type walkPart struct {
+		path      []int
+		mediaType string
+	}
+	var got []walkPart
+	err = e.WalkWithOptions(func(path []int, part *Entity, err error) error {
+		mediaType, _, _ := part.Header.ContentType()
+		got = append(got, walkPart{path, mediaType})
+		return err
+	}, &WalkOptions{Embedded: true})
+	if err != nil {
+		t.Fatalf("WalkWithOptions() = %v", err)
+	}
+
+	want := []walkPart{
+		{nil, "multipart/mixed"},
+		{[]int{0}, "text/plain"},
+		{[]int{1}, "message/rfc822"},
+		{[]int{1, EmbeddedPathIndex}, "multipart/alternative"},
+		{[]int{1, EmbeddedPathIndex, 0}, "text/plain"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkWithOptions() =\n%v\nbut want:\n%v", got, want)
+	}
+}
+
+// testNestedText returns a message with depth nested multipart entities, each
+// one containing a text part and a multipart part (except the innermost one).
+func testNestedText(depth int) string {
+	var sb strings.Builder
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&sb, "Content-Type: multipart/mixed; boundary=b%d\r\n\r\n", i)
+		fmt.Fprintf(&sb, "--b%d\r\nContent-Type: text/plain\r\n\r\nText %d\r\n", i, i)
+		fmt.Fprintf(&sb, "--b%d\r\n", i)
+	}
+	sb.WriteString("Content-Type: text/plain\r\n\r\nInnermost\r\n")
+	for i := depth - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "--b%d--\r\n", i)
+	}
+	return sb.String()
+}
+
+func TestReadWithOptions_lenientMultipart(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=IMTHEBOUNDARY\r\n" +
+		"\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Truncated message"
+
+	e, err := ReadWithOptions(strings.NewReader(raw), &ReadOptions{LenientMultipart: true})
+	if err != nil {
+		t.Fatalf("ReadWithOptions() = %v", err)
+	}
+	var bodies []string
+	err = e.Walk(func(path []int, part *Entity, err error) error {
+		if err != nil || len(path) == 0 {
+			return err
+		}
+		b, err := ioutil.ReadAll(part.Body)
+		bodies = append(bodies, string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Entity.Walk() = %v", err)
+	}
+	if want := []string{"Truncated message"}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("Expected bodies to be %q, got %q", want, bodies)
+	}
+}
+
+func TestReadWithOptions_limits(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  ReadOptions
+		limit string
+	}{
+		{"header fields", ReadOptions{MaxHeaderFields: 1}, "header fields"},
+		{"header bytes", ReadOptions{MaxHeaderBytes: 48}, "header size"},
+		{"header line length", ReadOptions{MaxHeaderLineLength: 32}, "header field length"},
+		{"multipart depth", ReadOptions{MaxMultipartDepth: 3}, "multipart depth"},
+		{"parts", ReadOptions{MaxParts: 5}, "multipart parts"},
+	}
+	for _, test := range tests {
+		raw := "Subject: Nested\r\n" + testNestedText(5)
+		e, err := ReadWithOptions(strings.NewReader(raw), &test.opts)
+		if err == nil {
+			_, err = walkCollect(e)
+		}
+
+		var limitErr LimitExceededError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%v: expected a LimitExceededError, got: %v", test.name, err)
+		} else if limitErr.Limit != test.limit {
+			t.Errorf("%v: expected limit %q to be exceeded, got %q", test.name, test.limit, limitErr.Limit)
+		}
+	}
+
+	opts := ReadOptions{
+		MaxHeaderFields:     2,
+		MaxMultipartDepth:   5,
+		MaxParts:            10,
+		MaxHeaderLineLength: 64,
+	}
+	e, err := ReadWithOptions(strings.NewReader(testNestedText(5)), &opts)
+	if err != nil {
+		t.Fatalf("ReadWithOptions() = %v", err)
+	}
+	parts, err := walkCollect(e)
+	if err != nil {
+		t.Fatalf("Entity.Walk() = %v", err)
+	}
+	if len(parts) != 11 {
+		t.Errorf("Expected 11 parts, got %v", len(parts))
+	}
+}
+
+func TestWalkWithOptions_limits(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  ReadOptions
+		limit string
+		max   int
+	}{
+		{"depth", ReadOptions{MaxMultipartDepth: 10}, "multipart depth", 10},
+		{"parts", ReadOptions{MaxParts: 100}, "multipart parts", 100},
+	}
+	for _, test := range tests {
+		e, err := ReadWithOptions(strings.NewReader(testNestedText(10000)), &test.opts)
+		if err != nil {
+			t.Fatalf("ReadWithOptions() = %v", err)
+		}
+
+		visited := 0
+		err = e.Walk(func(path []int, part *Entity, err error) error {
+			visited++
+			return nil
+		})
+
+		var limitErr LimitExceededError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%v: expected a LimitExceededError, got: %v", test.name, err)
+		} else if limitErr.Limit != test.limit || limitErr.Max != int64(test.max) {
+			t.Errorf("%v: expected limit %q (%v) to be exceeded, got %q (%v)", test.name, test.limit, test.max, limitErr.Limit, limitErr.Max)
+		}
+		if visited > 2*test.max+1 {
+			t.Errorf("%v: expected walk to stop early, but %v parts were visited", test.name, visited)
+		}
+	}
+
+	// Embedded messages count as a nesting level
+	for _, test := range []struct {
+		max     int
+		wantErr bool
+	}{{2, true}, {3, false}} {
+		e, err := ReadWithOptions(strings.NewReader(testEmbeddedText), &ReadOptions{MaxMultipartDepth: test.max})
+		if err != nil {
+			t.Fatalf("ReadWithOptions() = %v", err)
+		}
+		err = e.WalkWithOptions(func(path []int, part *Entity, err error) error {
+			return err
+		}, &WalkOptions{Embedded: true})
+		var limitErr LimitExceededError
+		if gotErr := errors.As(err, &limitErr); gotErr != test.wantErr {
+			t.Errorf("WalkWithOptions() with MaxMultipartDepth = %v: got error %v", test.max, err)
+		}
+	}
+}
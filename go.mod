@@ -4,5 +4,6 @@ go 1.13
 
 require (
 	github.com/emersion/go-textwrapper v0.0.0-20200911093747-65d896831594
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
 	golang.org/x/text v0.3.5-0.20201125200606-c27b9fd57aec
 )
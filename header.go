@@ -1,28 +1,359 @@
 package message
 
 import (
+	"fmt"
+	"io/ioutil"
 	"mime"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/emersion/go-message/internal/rfc2045"
 	"github.com/emersion/go-message/textproto"
 )
 
+// maxParamLineLen is the maximum length of a parameter's line, once the header
+// field has been folded between parameters.
+const maxParamLineLen = 76
+
+// isAttrChar reports whether c can be left unescaped in an RFC 2231 extended
+// value.
+func isAttrChar(c byte) bool {
+	return rfc2045.IsTokenChar(c) && c != '*' && c != '\'' && c != '%'
+}
+
+// consumeParam consumes a "key=value" parameter at the start of s. Values can
+// be quoted strings or tokens. ok is false if s doesn't start with a
+// parameter.
+func consumeParam(s string) (key, value, rest string, ok bool) {
+	i := strings.IndexByte(s, '=')
+	if i < 0 {
+		return "", "", s, false
+	}
+	key = strings.ToLower(strings.TrimSpace(s[:i]))
+	if !rfc2045.IsToken(key) {
+		return "", "", s, false
+	}
+	s = strings.TrimLeft(s[i+1:], " \t")
+
+	if strings.HasPrefix(s, `"`) {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; c {
+			case '"':
+				return key, b.String(), s[i+1:], true
+			case '\\':
+				if i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte(c)
+			}
+		}
+		// QUIRK: missing closing quote, accept the rest of the field
+		return key, b.String(), "", true
+	}
+
+	// QUIRK: be liberal with unquoted values, and accept anything up to the
+	// next semicolon
+	i = strings.IndexByte(s, ';')
+	if i < 0 {
+		i = len(s)
+	}
+	value = strings.TrimRight(s[:i], " \t")
+	if value == "" {
+		return "", "", s, false
+	}
+	return key, value, s[i:], true
+}
+
+// paramSection is a section of an RFC 2231 parameter value.
+type paramSection struct {
+	value   string
+	encoded bool // percent-encoded, with a charset if this is the first section
+}
+
+// decodePercent decodes RFC 2231 percent-encoded octets. Invalid escapes are
+// kept as is.
+func decodePercent(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// decodeRFC2231 reassembles RFC 2231 sections and decodes them to UTF-8.
+func decodeRFC2231(sections []paramSection) (string, error) {
+	charset := "us-ascii"
+	var b strings.Builder
+	for i, section := range sections {
+		v := section.value
+		if section.encoded {
+			if i == 0 {
+				// charset'language'value
+				if parts := strings.SplitN(v, "'", 3); len(parts) == 3 {
+					if parts[0] != "" {
+						charset = parts[0]
+					}
+					v = parts[2]
+				}
+			}
+			v = decodePercent(v)
+		}
+		b.WriteString(v)
+	}
+
+	// Sections are joined before charset conversion, since multi-byte
+	// characters can be split between sections
+	r, err := charsetReader(charset, strings.NewReader(b.String()))
+	if err != nil {
+		return b.String(), err
+	}
+	dec, err := ioutil.ReadAll(r)
+	if err != nil {
+		return b.String(), err
+	}
+	return string(dec), nil
+}
+
 func parseHeaderWithParams(s string) (f string, params map[string]string, err error) {
-	f, params, err = mime.ParseMediaType(s)
+	f = s
+	rest := ""
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		f, rest = s[:i], s[i:]
+	}
+	f, _, err = mime.ParseMediaType(f)
 	if err != nil {
 		return s, nil, err
 	}
-	for k, v := range params {
-		params[k], _ = decodeHeader(v)
+
+	params = make(map[string]string)
+	sections := make(map[string]map[int]paramSection)
+	var order []string
+	for {
+		rest = strings.TrimLeft(rest, " \t;")
+		if rest == "" {
+			break
+		}
+
+		var k, v string
+		var ok bool
+		k, v, rest, ok = consumeParam(rest)
+		if !ok {
+			return s, nil, mime.ErrInvalidMediaParameter
+		}
+
+		// RFC 2231 section 3 and 4: "name*" is an extended value,
+		// "name*0", "name*1" etc are continuations and "name*0*", "name*1*"
+		// etc are extended continuations
+		name, section, encoded := k, 0, false
+		if i := strings.IndexByte(k, '*'); i >= 0 {
+			name = k[:i]
+			idx := strings.TrimSuffix(k[i+1:], "*")
+			encoded = strings.HasSuffix(k, "*")
+			if idx != "" {
+				n, convErr := strconv.Atoi(idx)
+				if convErr != nil || n < 0 {
+					continue
+				}
+				section = n
+			}
+		} else {
+			// Regular parameter, the first one wins
+			if _, ok := params[k]; !ok {
+				params[k], _ = decodeHeader(v)
+			}
+			continue
+		}
+
+		m, ok := sections[name]
+		if !ok {
+			m = make(map[int]paramSection)
+			sections[name] = m
+			order = append(order, name)
+		}
+		if _, ok := m[section]; !ok {
+			m[section] = paramSection{value: v, encoded: encoded}
+		}
+	}
+
+	for _, name := range order {
+		m := sections[name]
+
+		indices := make([]int, 0, len(m))
+		for n := range m {
+			indices = append(indices, n)
+		}
+		sort.Ints(indices)
+
+		// QUIRK: missing sections are skipped instead of truncating the value
+		l := make([]paramSection, len(indices))
+		for i, n := range indices {
+			l[i] = m[n]
+		}
+
+		// RFC 2231 values take precedence over regular ones
+		v, decErr := decodeRFC2231(l)
+		if decErr != nil && err == nil {
+			err = decErr
+		}
+		params[name] = v
+	}
+
+	return f, params, err
+}
+
+// needsRFC2231 reports whether a parameter value cannot be represented as a
+// quoted string.
+func needsRFC2231(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if c := v[i]; (c < ' ' && c != '\t') || c >= 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteParamValue(v string) string {
+	if rfc2045.IsToken(v) {
+		return v
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		if v[i] == '"' || v[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(v[i])
 	}
-	return
+	b.WriteByte('"')
+	return b.String()
 }
 
+// encodePercent percent-encodes v. It returns the encoded runes: invalid
+// UTF-8 bytes are replaced with U+FFFD, so that the value is labelled with
+// the right charset.
+func encodePercent(v string) []string {
+	l := make([]string, 0, len(v))
+	for _, r := range strings.ToValidUTF8(v, string(utf8.RuneError)) {
+		var b strings.Builder
+		for _, c := range []byte(string(r)) {
+			if isAttrChar(c) {
+				b.WriteByte(c)
+			} else {
+				fmt.Fprintf(&b, "%%%02X", c)
+			}
+		}
+		l = append(l, b.String())
+	}
+	return l
+}
+
+// formatParam formats a parameter, using RFC 2231 extended values and
+// continuations if necessary.
+func formatParam(k, v string) []string {
+	// Each parameter is on its own line once folded: " param;"
+	const maxLen = maxParamLineLen - 2
+
+	if !needsRFC2231(v) {
+		if p := k + "=" + quoteParamValue(v); len(p) <= maxLen {
+			return []string{p}
+		}
+
+		// Split the value into quoted continuations
+		var l []string
+		for v != "" {
+			name := fmt.Sprintf("%v*%v=", k, len(l))
+			i, n := 0, len(name)+2
+			for i < len(v) {
+				c := 1
+				if v[i] == '"' || v[i] == '\\' {
+					c = 2
+				}
+				if i > 0 && n+c > maxLen {
+					break
+				}
+				n += c
+				i++
+			}
+			l = append(l, name+quoteParamValue(v[:i]))
+			v = v[i:]
+		}
+		return l
+	}
+
+	// Runes are kept together, so that each section is valid UTF-8
+	runes := encodePercent(v)
+	const prefix = "utf-8''"
+	if p := k + "*=" + prefix + strings.Join(runes, ""); len(p) <= maxLen {
+		return []string{p}
+	}
+
+	var l []string
+	var b strings.Builder
+	b.WriteString(prefix)
+	for _, r := range runes {
+		name := fmt.Sprintf("%v*%v*=", k, len(l))
+		if b.Len() > 0 && len(name)+b.Len()+len(r) > maxLen {
+			l = append(l, name+b.String())
+			b.Reset()
+		}
+		b.WriteString(r)
+	}
+	if b.Len() > 0 {
+		l = append(l, fmt.Sprintf("%v*%v*=%v", k, len(l), b.String()))
+	}
+	return l
+}
+
+// isMediaType reports whether t is a valid "type/subtype" media type, or a
+// valid disposition type.
+func isMediaType(t string) bool {
+	if i := strings.IndexByte(t, '/'); i >= 0 {
+		return rfc2045.IsToken(t[:i]) && rfc2045.IsToken(t[i+1:])
+	}
+	return rfc2045.IsToken(t)
+}
+
+// formatHeaderWithParams formats a header field value with parameters. Like
+// mime.FormatMediaType, it returns an empty string if f or a parameter name
+// is invalid.
 func formatHeaderWithParams(f string, params map[string]string) string {
-	encParams := make(map[string]string)
-	for k, v := range params {
-		encParams[k] = encodeHeader(v)
+	if !isMediaType(f) {
+		return ""
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if !rfc2045.IsToken(k) {
+			return ""
+		}
+		keys = append(keys, k)
 	}
-	return mime.FormatMediaType(f, encParams)
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(f)
+	for _, k := range keys {
+		for _, p := range formatParam(strings.ToLower(k), params[k]) {
+			b.WriteString("; ")
+			b.WriteString(p)
+		}
+	}
+	return b.String()
 }
 
 // HeaderFields iterates over header fields.
@@ -50,6 +381,10 @@ type Header struct {
 
 // ContentType parses the Content-Type header field.
 //
+// RFC 2231 parameter continuations and charsets are decoded. If a parameter's
+// charset is unknown, its raw value is returned and the error verifies
+// IsUnknownCharset.
+//
 // If no Content-Type is specified, it returns "text/plain".
 func (h *Header) ContentType() (t string, params map[string]string, err error) {
 	v := h.Get("Content-Type")
@@ -60,18 +395,21 @@ func (h *Header) ContentType() (t string, params map[string]string, err error) {
 }
 
 // SetContentType formats the Content-Type header field.
+//
+// Non-ASCII parameter values are encoded as defined in RFC 2231, and long
+// values are split into continuations.
 func (h *Header) SetContentType(t string, params map[string]string) {
 	h.Set("Content-Type", formatHeaderWithParams(t, params))
 }
 
 // ContentDisposition parses the Content-Disposition header field, as defined in
-// RFC 2183.
+// RFC 2183. Parameters are decoded as in ContentType.
 func (h *Header) ContentDisposition() (disp string, params map[string]string, err error) {
 	return parseHeaderWithParams(h.Get("Content-Disposition"))
 }
 
 // SetContentDisposition formats the Content-Disposition header field, as
-// defined in RFC 2183.
+// defined in RFC 2183. Parameters are encoded as in SetContentType.
 func (h *Header) SetContentDisposition(disp string, params map[string]string) {
 	h.Set("Content-Disposition", formatHeaderWithParams(disp, params))
 }
@@ -83,9 +421,21 @@ func (h *Header) Text(k string) (string, error) {
 	return decodeHeader(h.Get(k))
 }
 
+// TextWithOptions is like Text, but with options. If opts is nil, the
+// defaults are used.
+//
+// In lenient mode, a best-effort decoded value is returned even if an error
+// is returned.
+func (h *Header) TextWithOptions(k string, opts *TextOptions) (string, error) {
+	if opts == nil || !opts.Lenient {
+		return h.Text(k)
+	}
+	return decodeHeaderLenient(h.Get(k), opts)
+}
+
 // SetText sets a plaintext header field.
 func (h *Header) SetText(k, v string) {
-	h.Set(k, encodeHeader(v))
+	h.Set(k, encodeHeader(k, v))
 }
 
 // Copy creates a stand-alone copy of the header.
@@ -1,8 +1,14 @@
 package message
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/emersion/go-message/textproto"
 )
 
 func TestHeader(t *testing.T) {
@@ -85,3 +91,188 @@ func TestUnknownCharset(t *testing.T) {
 		t.Error("Expected error to verify IsUnknownCharset")
 	}
 }
+
+var formatHeaderParamsTests = []struct {
+	params    map[string]string
+	formatted string
+}{
+	{
+		params:    map[string]string{"filename": "note.txt"},
+		formatted: "attachment; filename=note.txt",
+	},
+	{
+		params:    map[string]string{"filename": "my note.txt"},
+		formatted: `attachment; filename="my note.txt"`,
+	},
+	{
+		params:    map[string]string{"filename": "complémentarité.txt"},
+		formatted: "attachment; filename*=utf-8''compl%C3%A9mentarit%C3%A9.txt",
+	},
+	{
+		params: map[string]string{"filename": strings.Repeat("a", 80) + ".txt"},
+		formatted: "attachment; " +
+			"filename*0=" + strings.Repeat("a", 61) + "; " +
+			"filename*1=" + strings.Repeat("a", 19) + ".txt",
+	},
+	{
+		params: map[string]string{"filename": strings.Repeat("é", 20) + ".txt"},
+		formatted: "attachment; " +
+			"filename*0*=utf-8''" + strings.Repeat("%C3%A9", 9) + "; " +
+			"filename*1*=" + strings.Repeat("%C3%A9", 10) + "; " +
+			"filename*2*=" + strings.Repeat("%C3%A9", 1) + ".txt",
+	},
+}
+
+func TestFormatHeaderWithParams(t *testing.T) {
+	for _, test := range formatHeaderParamsTests {
+		formatted := formatHeaderWithParams("attachment", test.params)
+		if formatted != test.formatted {
+			t.Errorf("Expected params %v to be formatted as \n%q\n but got \n%q", test.params, test.formatted, formatted)
+		}
+
+		_, params, err := parseHeaderWithParams(formatted)
+		if err != nil {
+			t.Errorf("Expected no error when parsing %q, but got: %v", formatted, err)
+		} else if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("Expected %q to be parsed as %v but got %v", formatted, test.params, params)
+		}
+	}
+}
+
+func TestFormatHeaderWithParams_invalid(t *testing.T) {
+	tests := []struct {
+		f      string
+		params map[string]string
+	}{
+		{"text/", nil},
+		{"text plain", nil},
+		{"attachment", map[string]string{"file name": "note.txt"}},
+		{"attachment", map[string]string{"": "note.txt"}},
+	}
+	for _, test := range tests {
+		if formatted := formatHeaderWithParams(test.f, test.params); formatted != "" {
+			t.Errorf("Expected %q with params %v to be formatted as an empty string, but got %q", test.f, test.params, formatted)
+		}
+	}
+}
+
+func TestFormatHeaderWithParams_invalidUTF8(t *testing.T) {
+	// Invalid UTF-8 bytes are replaced, the value is labelled as UTF-8
+	formatted := formatHeaderWithParams("attachment", map[string]string{"filename": "caf\xe9.txt"})
+	if want := "attachment; filename*=utf-8''caf%EF%BF%BD.txt"; formatted != want {
+		t.Errorf("Expected invalid UTF-8 to be formatted as \n%q\n but got \n%q", want, formatted)
+	}
+}
+
+func TestFormatHeaderWithParams_mediaType(t *testing.T) {
+	if formatted, want := formatHeaderWithParams("Text/Plain", nil), "Text/Plain"; formatted != want {
+		t.Errorf("Expected media type to be formatted as %q but got %q", want, formatted)
+	}
+}
+
+var parseHeaderParamsTests = []struct {
+	s      string
+	params map[string]string
+}{
+	{
+		s:      `attachment; filename*=UTF-8''%e2%82%ac%20rates.txt`,
+		params: map[string]string{"filename": "€ rates.txt"},
+	},
+	{
+		// Continuations out of order, mixing encoded and regular sections
+		s:      `attachment; filename*1=" rates"; filename*0*=utf-8'en'%e2%82%ac; filename*2*=.txt`,
+		params: map[string]string{"filename": "€ rates.txt"},
+	},
+	{
+		// Multi-byte character split between two sections
+		s:      `attachment; filename*0*=utf-8''%e2%82; filename*1*=%ac.txt`,
+		params: map[string]string{"filename": "€.txt"},
+	},
+	{
+		// Extended value takes precedence
+		s:      `attachment; filename="fallback.txt"; filename*=utf-8''%C3%A9.txt`,
+		params: map[string]string{"filename": "é.txt"},
+	},
+	{
+		s:      `attachment; filename*=iso-8859-1''caf%E9.txt`,
+		params: map[string]string{"filename": "café.txt"},
+	},
+	{
+		// Missing closing quote
+		s:      `attachment; filename="note.txt`,
+		params: map[string]string{"filename": "note.txt"},
+	},
+	{
+		// Unquoted value with spaces
+		s:      `attachment; filename=my note.txt; size=42`,
+		params: map[string]string{"filename": "my note.txt", "size": "42"},
+	},
+}
+
+// latin1Reader is a minimal CharsetReader handling ISO-8859-1 only.
+func latin1Reader(charset string, input io.Reader) (io.Reader, error) {
+	if charset != "iso-8859-1" {
+		return nil, fmt.Errorf("unhandled charset %q", charset)
+	}
+	b, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return strings.NewReader(string(runes)), nil
+}
+
+func TestParseHeaderWithParams(t *testing.T) {
+	// Minimal ISO-8859-1 support
+	defer func(f func(string, io.Reader) (io.Reader, error)) {
+		CharsetReader = f
+	}(CharsetReader)
+	CharsetReader = latin1Reader
+
+	for _, test := range parseHeaderParamsTests {
+		_, params, err := parseHeaderWithParams(test.s)
+		if err != nil {
+			t.Errorf("Expected no error when parsing %q, but got: %v", test.s, err)
+		} else if !reflect.DeepEqual(params, test.params) {
+			t.Errorf("Expected %q to be parsed as %v but got %v", test.s, test.params, params)
+		}
+	}
+}
+
+func TestParseHeaderWithParams_unknownCharset(t *testing.T) {
+	s := `attachment; filename*=idontexist''caf%E9.txt`
+	disp, params, err := parseHeaderWithParams(s)
+	if !IsUnknownCharset(err) {
+		t.Errorf("Expected error to verify IsUnknownCharset, but got: %v", err)
+	}
+	if disp != "attachment" {
+		t.Errorf("Expected disposition %q but got %q", "attachment", disp)
+	}
+	if filename := params["filename"]; filename != "caf\xe9.txt" {
+		t.Errorf("Expected raw filename %q but got %q", "caf\xe9.txt", filename)
+	}
+}
+
+func TestHeader_SetText_folding(t *testing.T) {
+	var h Header
+	h.SetText("Subject", strings.Repeat("日本語のテキスト ", 10)+"and some ASCII words at the end")
+
+	var b strings.Builder
+	if err := textproto.WriteHeader(&b, h.Header); err != nil {
+		t.Fatalf("WriteHeader() = %v", err)
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(b.String(), "\r\n\r\n"), "\r\n") {
+		if len(l) > 76 {
+			t.Errorf("Header line longer than 76 characters: %q", l)
+		}
+	}
+
+	if got, err := h.Text("Subject"); err != nil {
+		t.Errorf("Text() = %v", err)
+	} else if want := strings.Repeat("日本語のテキスト ", 10) + "and some ASCII words at the end"; got != want {
+		t.Errorf("Text() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,217 @@
+package message
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// TextOptions contains options for Header.TextWithOptions.
+type TextOptions struct {
+	// Lenient enables tolerant decoding of malformed RFC 2047 encoded-words,
+	// as commonly found in real-world messages:
+	//
+	//   - Adjacent encoded-words using the same charset are joined before
+	//     charset conversion, so that multibyte sequences split across words
+	//     are decoded correctly.
+	//   - Encoded-words don't need to be separated by whitespace, and are
+	//     decoded inside quoted strings too.
+	//   - Malformed encoded-words are left as-is instead of failing the whole
+	//     field.
+	//   - Raw 8-bit bytes which aren't valid UTF-8 are decoded with
+	//     RawCharsets.
+	Lenient bool
+	// RawCharsets is a list of charsets used in lenient mode to decode raw
+	// 8-bit bytes which aren't valid UTF-8, either outside encoded-words or
+	// inside encoded-words whose charset is unknown or mislabeled. Charsets
+	// are tried in order, the first one known to CharsetReader is used. If
+	// none is known, invalid bytes are replaced with U+FFFD.
+	RawCharsets []string
+}
+
+// decodeRaw decodes a string which may contain invalid UTF-8 bytes.
+func decodeRaw(s string, opts *TextOptions) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	for _, charset := range opts.RawCharsets {
+		r, err := charsetReader(charset, strings.NewReader(s))
+		if err != nil {
+			continue
+		}
+		b, err := ioutil.ReadAll(r)
+		if err == nil && utf8.Valid(b) {
+			return string(b)
+		}
+	}
+	return strings.ToValidUTF8(s, "�")
+}
+
+// convertCharset converts b from charset to UTF-8. If the charset is unknown,
+// b is decoded with decodeRaw and an error is returned.
+func convertCharset(charset string, b []byte, opts *TextOptions) (string, error) {
+	r, err := charsetReader(charset, bytes.NewReader(b))
+	if err != nil {
+		return decodeRaw(string(b), opts), err
+	}
+	dec, err := ioutil.ReadAll(r)
+	if err != nil {
+		return decodeRaw(string(b), opts), err
+	}
+	return decodeRaw(string(dec), opts), nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// decodeQ decodes Q-encoded text, leaving invalid escape sequences as-is.
+func decodeQ(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '_':
+			b = append(b, ' ')
+		case '=':
+			if i+2 < len(s) {
+				hi, ok1 := unhex(s[i+1])
+				lo, ok2 := unhex(s[i+2])
+				if ok1 && ok2 {
+					b = append(b, hi<<4|lo)
+					i += 2
+					continue
+				}
+			}
+			b = append(b, c)
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// decodeB decodes B-encoded text, tolerating missing padding and whitespace.
+func decodeB(s string) ([]byte, bool) {
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+	b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+	return b, err == nil
+}
+
+// parseEncodedWord parses an encoded-word at the start of s. It returns the
+// charset, the decoded bytes and the length of the encoded-word.
+func parseEncodedWord(s string) (charset string, b []byte, n int, ok bool) {
+	if !strings.HasPrefix(s, "=?") {
+		return "", nil, 0, false
+	}
+	i := strings.IndexByte(s[2:], '?')
+	if i <= 0 || i > 64 || strings.ContainsAny(s[2:2+i], " \t\r\n") {
+		return "", nil, 0, false
+	}
+	charset = s[2 : 2+i]
+	if star := strings.IndexByte(charset, '*'); star >= 0 {
+		// Strip the RFC 2231 language
+		charset = charset[:star]
+	}
+
+	rest := s[2+i+1:]
+	if len(rest) < 2 || rest[1] != '?' {
+		return "", nil, 0, false
+	}
+	enc := rest[0]
+	rest = rest[2:]
+	end := strings.Index(rest, "?=")
+	if end < 0 {
+		return "", nil, 0, false
+	}
+	text := rest[:end]
+	n = len(s) - len(rest) + end + 2
+
+	switch enc {
+	case 'q', 'Q':
+		b = decodeQ(text)
+	case 'b', 'B':
+		if b, ok = decodeB(text); !ok {
+			return "", nil, 0, false
+		}
+	default:
+		return "", nil, 0, false
+	}
+	return charset, b, n, true
+}
+
+func isWhitespace(s string) bool {
+	return strings.Trim(s, " \t\r\n") == ""
+}
+
+// decodeHeaderLenient is a tolerant version of decodeHeader. It returns a
+// best-effort decoded string even if an error is returned.
+func decodeHeaderLenient(s string, opts *TextOptions) (string, error) {
+	var sb strings.Builder
+	var firstErr error
+
+	// The pending encoded-word data, joined until the charset changes or
+	// until non-whitespace text is found
+	var charset string
+	var pending []byte
+	hasPending := false
+	flush := func() {
+		if !hasPending {
+			return
+		}
+		dec, err := convertCharset(charset, pending, opts)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		sb.WriteString(dec)
+		pending = nil
+		hasPending = false
+	}
+
+	for len(s) > 0 {
+		i := strings.Index(s, "=?")
+		if i < 0 {
+			flush()
+			sb.WriteString(decodeRaw(s, opts))
+			break
+		}
+
+		wordCharset, b, n, ok := parseEncodedWord(s[i:])
+		if !ok {
+			flush()
+			sb.WriteString(decodeRaw(s[:i+2], opts))
+			s = s[i+2:]
+			continue
+		}
+
+		// Whitespace between adjacent encoded-words is ignored
+		if text := s[:i]; !hasPending || !isWhitespace(text) {
+			flush()
+			sb.WriteString(decodeRaw(text, opts))
+		}
+		if hasPending && !strings.EqualFold(charset, wordCharset) {
+			flush()
+		}
+		charset = wordCharset
+		pending = append(pending, b...)
+		hasPending = true
+		s = s[i+n:]
+	}
+	flush()
+
+	return sb.String(), firstErr
+}
@@ -0,0 +1,93 @@
+package message
+
+import (
+	"io"
+	"testing"
+)
+
+var decodeHeaderLenientTests = []struct {
+	name string
+	in   string
+	want string
+}{
+	{
+		name: "valid",
+		in:   "=?utf-8?q?Caf=C3=A9?= au lait",
+		want: "Café au lait",
+	},
+	{
+		name: "splitMultibyte",
+		in:   "=?utf-8?b?5pel?= =?utf-8?b?5pys6Kqe?= =?UTF-8?q?=E3?= =?utf-8?q?=81=AE?=",
+		want: "日本語の",
+	},
+	{
+		name: "noWhitespace",
+		in:   "Re:=?utf-8?q?Caf=C3=A9?==?utf-8?q?_cr=C3=A8me?=",
+		want: "Re:Café crème",
+	},
+	{
+		name: "quoted",
+		in:   `"=?utf-8?q?Caf=C3=A9?=" <cafe@example.org>`,
+		want: `"Café" <cafe@example.org>`,
+	},
+	{
+		name: "malformed",
+		in:   "=?utf-8?b?not base64!?= =?utf-8?x?Caf=C3=A9?= ok",
+		want: "=?utf-8?b?not base64!?= =?utf-8?x?Caf=C3=A9?= ok",
+	},
+	{
+		name: "missingPadding",
+		in:   "=?utf-8?b?Q2Fmw6k?=",
+		want: "Café",
+	},
+	{
+		name: "raw8bit",
+		in:   "Caf\xe9 au lait",
+		want: "Café au lait",
+	},
+	{
+		name: "mislabeled",
+		in:   "=?utf-8?q?Caf=E9?=",
+		want: "Café",
+	},
+	{
+		name: "differentCharsets",
+		in:   "=?iso-8859-1?q?Caf=E9?= =?utf-8?q?_cr=C3=A8me?=",
+		want: "Café crème",
+	},
+}
+
+func TestDecodeHeaderLenient(t *testing.T) {
+	defer func(cr func(string, io.Reader) (io.Reader, error)) {
+		CharsetReader = cr
+	}(CharsetReader)
+	CharsetReader = latin1Reader
+
+	opts := &TextOptions{Lenient: true, RawCharsets: []string{"iso-8859-1"}}
+	for _, tc := range decodeHeaderLenientTests {
+		t.Run(tc.name, func(t *testing.T) {
+			var h Header
+			h.Set("Subject", tc.in)
+			got, err := h.TextWithOptions("Subject", opts)
+			if err != nil {
+				t.Fatalf("TextWithOptions() = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("TextWithOptions(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHeaderLenient_unknownCharset(t *testing.T) {
+	var h Header
+	h.Set("Subject", "=?x-unknown?q?Caf=E9?= au lait")
+
+	got, err := h.TextWithOptions("Subject", &TextOptions{Lenient: true})
+	if !IsUnknownCharset(err) {
+		t.Errorf("Expected an unknown charset error, got %v", err)
+	}
+	if want := "Caf� au lait"; got != want {
+		t.Errorf("TextWithOptions() = %q, want %q", got, want)
+	}
+}
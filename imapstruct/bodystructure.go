@@ -0,0 +1,189 @@
+// Package imapstruct computes the IMAP BODYSTRUCTURE and ENVELOPE of
+// messages.
+//
+// The IMAP data formats are defined in RFC 3501 section 7.4.2.
+package imapstruct
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+// BodyStructure describes the MIME structure of an entity.
+type BodyStructure struct {
+	// The MIME type and subtype, in lowercase.
+	MIMEType    string
+	MIMESubType string
+	// The Content-Type parameters.
+	Params map[string]string
+
+	// The Content-Id, Content-Description and Content-Transfer-Encoding header
+	// fields. Encoding defaults to "7bit". They are empty for multipart
+	// entities.
+	ID          string
+	Description string
+	Encoding    string
+	// The size of the body in its transfer encoding, in bytes.
+	Size int64
+	// The number of lines of the body in its transfer encoding. It is only set
+	// for text/* and message/rfc822 entities.
+	Lines int64
+
+	// Children contains the parts of a multipart entity.
+	Children []*BodyStructure
+
+	// Envelope and Embedded describe the message embedded in a message/rfc822
+	// or message/global entity.
+	Envelope *Envelope
+	Embedded *BodyStructure
+
+	// The Content-MD5 header field.
+	MD5 string
+	// The Content-Disposition header field.
+	Disposition       string
+	DispositionParams map[string]string
+	// The Content-Language header field, as a list of language tags.
+	Language []string
+	// The Content-Location header field.
+	Location string
+}
+
+// MediaType returns the full media type, e.g. "text/plain".
+func (bs *BodyStructure) MediaType() string {
+	return bs.MIMEType + "/" + bs.MIMESubType
+}
+
+// lineCounter counts the bytes and lines written to it.
+type lineCounter struct {
+	n     int64
+	lines int64
+	last  byte
+}
+
+func (lc *lineCounter) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		lc.n += int64(len(b))
+		lc.lines += int64(bytes.Count(b, []byte{'\n'}))
+		lc.last = b[len(b)-1]
+	}
+	return len(b), nil
+}
+
+// Lines returns the number of lines written so far. A trailing incomplete
+// line is counted as a line.
+func (lc *lineCounter) Lines() int64 {
+	if lc.n > 0 && lc.last != '\n' {
+		return lc.lines + 1
+	}
+	return lc.lines
+}
+
+func isEmbeddedMessage(mediaType string) bool {
+	return mediaType == "message/rfc822" || mediaType == "message/global"
+}
+
+// readBodyStructure computes the body structure of an entity, given its
+// header and its raw body.
+func readBodyStructure(h message.Header, body io.Reader) (*BodyStructure, error) {
+	mediaType, params, _ := h.ContentType()
+	if !h.Has("Content-Type") {
+		// RFC 2045 section 5.2
+		params = map[string]string{"charset": "us-ascii"}
+	}
+	mediaType = strings.ToLower(mediaType)
+
+	bs := &BodyStructure{Params: params}
+	typ := strings.SplitN(mediaType, "/", 2)
+	bs.MIMEType = typ[0]
+	if len(typ) == 2 {
+		bs.MIMESubType = typ[1]
+	}
+
+	bs.Disposition, bs.DispositionParams, _ = h.ContentDisposition()
+	if lang := h.Get("Content-Language"); lang != "" {
+		for _, tag := range strings.Split(lang, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				bs.Language = append(bs.Language, tag)
+			}
+		}
+	}
+	bs.Location = h.Get("Content-Location")
+
+	if bs.MIMEType == "multipart" {
+		mr := textproto.NewMultipartReader(body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			child, err := readBodyStructure(message.Header{Header: p.Header}, p)
+			if err != nil {
+				return nil, err
+			}
+			bs.Children = append(bs.Children, child)
+		}
+		return bs, nil
+	}
+
+	bs.ID = h.Get("Content-Id")
+	bs.Description, _ = h.Text("Content-Description")
+	bs.Encoding = strings.ToLower(h.Get("Content-Transfer-Encoding"))
+	if bs.Encoding == "" {
+		bs.Encoding = "7bit"
+	}
+	bs.MD5 = h.Get("Content-Md5")
+
+	lc := new(lineCounter)
+	body = io.TeeReader(body, lc)
+
+	if isEmbeddedMessage(mediaType) {
+		br := bufio.NewReader(body)
+		eh, err := textproto.ReadHeader(br)
+		if err != nil {
+			return nil, err
+		}
+		bs.Envelope = ReadEnvelope(mail.Header{Header: message.Header{Header: eh}})
+		if bs.Embedded, err = readBodyStructure(message.Header{Header: eh}, br); err != nil {
+			return nil, err
+		}
+		body = br
+	}
+
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return nil, err
+	}
+
+	bs.Size = lc.n
+	if bs.MIMEType == "text" || isEmbeddedMessage(mediaType) {
+		bs.Lines = lc.Lines()
+	}
+	return bs, nil
+}
+
+// ReadBodyStructure computes the body structure of an entity. The entity's
+// body is consumed, and must not have been read before.
+//
+// Sizes and line counts are computed from the raw body, in its transfer
+// encoding and charset. The parts of multipart entities are parsed from their
+// raw form.
+func ReadBodyStructure(e *message.Entity) (*BodyStructure, error) {
+	body := e.Body
+	if e.MultipartReader() == nil {
+		raw, err := e.RawBody()
+		if err != nil {
+			return nil, err
+		}
+		body = raw
+	}
+	return readBodyStructure(e.Header, body)
+}
@@ -0,0 +1,130 @@
+package imapstruct
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+)
+
+const testMessage = "From: Mitsuha Miyamizu <mitsuha.miyamizu@example.org>\r\n" +
+	"Subject: Your Name.\r\n" +
+	"Content-Type: multipart/mixed; boundary=message-boundary\r\n" +
+	"\r\n" +
+	"--message-boundary\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"Content-Language: en, fr\r\n" +
+	"\r\n" +
+	"Who are you?=\r\n" +
+	"\r\n" +
+	"--message-boundary\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Id: <attachment@example.org>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-Disposition: attachment; filename=note.txt\r\n" +
+	"Content-Md5: Q2hlY2sgSW50ZWdyaXR5IQ==\r\n" +
+	"\r\n" +
+	"SSBhbSB0aGUgb25lIQ==\r\n" +
+	"--message-boundary\r\n" +
+	"Content-Type: message/rfc822\r\n" +
+	"\r\n" +
+	"From: Taki Tachibana <taki.tachibana@example.org>\r\n" +
+	"Subject: Re: Your Name.\r\n" +
+	"\r\n" +
+	"I'm Taki.\r\n" +
+	"--message-boundary--\r\n"
+
+func TestReadBodyStructure(t *testing.T) {
+	e, err := message.Read(strings.NewReader(testMessage))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+
+	bs, err := ReadBodyStructure(e)
+	if err != nil {
+		t.Fatalf("ReadBodyStructure() = %v", err)
+	}
+
+	want := &BodyStructure{
+		MIMEType:    "multipart",
+		MIMESubType: "mixed",
+		Params:      map[string]string{"boundary": "message-boundary"},
+		Children: []*BodyStructure{
+			{
+				MIMEType:    "text",
+				MIMESubType: "plain",
+				Params:      map[string]string{"charset": "utf-8"},
+				Encoding:    "quoted-printable",
+				Size:        15,
+				Lines:       1,
+				Language:    []string{"en", "fr"},
+			},
+			{
+				MIMEType:          "application",
+				MIMESubType:       "octet-stream",
+				Params:            map[string]string{},
+				ID:                "<attachment@example.org>",
+				Encoding:          "base64",
+				Size:              20,
+				MD5:               "Q2hlY2sgSW50ZWdyaXR5IQ==",
+				Disposition:       "attachment",
+				DispositionParams: map[string]string{"filename": "note.txt"},
+			},
+			{
+				MIMEType:    "message",
+				MIMESubType: "rfc822",
+				Params:      map[string]string{},
+				Encoding:    "7bit",
+				Size:        87,
+				Lines:       4,
+				Envelope: &Envelope{
+					Subject: "Re: Your Name.",
+					From:    []*Address{{Name: "Taki Tachibana", Mailbox: "taki.tachibana", Host: "example.org"}},
+					Sender:  []*Address{{Name: "Taki Tachibana", Mailbox: "taki.tachibana", Host: "example.org"}},
+					ReplyTo: []*Address{{Name: "Taki Tachibana", Mailbox: "taki.tachibana", Host: "example.org"}},
+				},
+				Embedded: &BodyStructure{
+					MIMEType:    "text",
+					MIMESubType: "plain",
+					Params:      map[string]string{"charset": "us-ascii"},
+					Encoding:    "7bit",
+					Size:        9,
+					Lines:       1,
+				},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(bs, want) {
+		t.Errorf("Expected body structure:\n%+v\nbut got:\n%+v", want, bs)
+	}
+}
+
+func TestReadBodyStructure_nonMultipart(t *testing.T) {
+	s := "Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		"SGVsbG8gd29ybGQh"
+
+	e, err := message.Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+
+	bs, err := ReadBodyStructure(e)
+	if err != nil {
+		t.Fatalf("ReadBodyStructure() = %v", err)
+	}
+
+	if bs.MediaType() != "text/plain" {
+		t.Errorf("Expected media type text/plain, got %v", bs.MediaType())
+	}
+	if bs.Encoding != "base64" {
+		t.Errorf("Expected encoding base64, got %v", bs.Encoding)
+	}
+	if bs.Size != 16 || bs.Lines != 1 {
+		t.Errorf("Expected size 16 and 1 line, got size %v and %v lines", bs.Size, bs.Lines)
+	}
+}
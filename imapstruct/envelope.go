@@ -0,0 +1,80 @@
+package imapstruct
+
+import (
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+)
+
+// Address is an address in an envelope, split as required by IMAP.
+type Address struct {
+	// The display name, decoded to UTF-8.
+	Name string
+	// The local part of the address.
+	Mailbox string
+	// The domain of the address.
+	Host string
+}
+
+func newAddress(addr *mail.Address) *Address {
+	a := &Address{Name: addr.Name, Mailbox: addr.Address}
+	if i := strings.LastIndexByte(addr.Address, '@'); i >= 0 {
+		a.Mailbox, a.Host = addr.Address[:i], addr.Address[i+1:]
+	}
+	return a
+}
+
+// Envelope contains the IMAP ENVELOPE data of a message, as defined in RFC
+// 3501 section 7.4.2. Values are decoded to UTF-8: IMAP servers need to
+// encode them again when sending them to clients.
+type Envelope struct {
+	Date      time.Time
+	Subject   string
+	From      []*Address
+	Sender    []*Address
+	ReplyTo   []*Address
+	To        []*Address
+	Cc        []*Address
+	Bcc       []*Address
+	InReplyTo []string
+	MessageID string
+}
+
+// ReadEnvelope extracts the envelope data from a message header.
+//
+// Since IMAP clients expect an envelope even for broken messages, malformed
+// header fields are left empty instead of causing an error. If the Sender or
+// Reply-To header field is missing, it defaults to From, as required by IMAP.
+func ReadEnvelope(h mail.Header) *Envelope {
+	env := new(Envelope)
+	env.Date, _ = h.Date()
+	env.Subject, _ = h.Subject()
+	env.From = addressList(h, "From")
+	env.Sender = addressList(h, "Sender")
+	if env.Sender == nil {
+		env.Sender = env.From
+	}
+	env.ReplyTo = addressList(h, "Reply-To")
+	if env.ReplyTo == nil {
+		env.ReplyTo = env.From
+	}
+	env.To = addressList(h, "To")
+	env.Cc = addressList(h, "Cc")
+	env.Bcc = addressList(h, "Bcc")
+	env.InReplyTo, _ = h.MsgIDList("In-Reply-To")
+	env.MessageID, _ = h.MessageID()
+	return env
+}
+
+func addressList(h mail.Header, k string) []*Address {
+	addrs, err := h.AddressList(k)
+	if err != nil || len(addrs) == 0 {
+		return nil
+	}
+	l := make([]*Address, len(addrs))
+	for i, addr := range addrs {
+		l[i] = newAddress(addr)
+	}
+	return l
+}
@@ -0,0 +1,55 @@
+package imapstruct
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+	"github.com/emersion/go-message/textproto"
+)
+
+func TestReadEnvelope(t *testing.T) {
+	s := "Date: Wed, 11 May 2016 14:31:59 +0000\r\n" +
+		"Message-Id: <0123456789@example.org>\r\n" +
+		"In-Reply-To: <9876543210@example.org>\r\n" +
+		"From: Mitsuha Miyamizu <mitsuha.miyamizu@example.org>\r\n" +
+		"Reply-To: Mitsuha Miyamizu <mitsuha.miyamizu+replies@example.org>\r\n" +
+		"To: Taki Tachibana <taki.tachibana@example.org>, undisclosed@example.org\r\n" +
+		"Cc: invalid address\r\n" +
+		"Subject: =?utf-8?q?Caf=C3=A9?=\r\n" +
+		"\r\n"
+
+	h, err := textproto.ReadHeader(bufio.NewReader(strings.NewReader(s)))
+	if err != nil {
+		t.Fatalf("ReadHeader() = %v", err)
+	}
+
+	env := ReadEnvelope(mail.Header{Header: message.Header{Header: h}})
+
+	from := []*Address{{Name: "Mitsuha Miyamizu", Mailbox: "mitsuha.miyamizu", Host: "example.org"}}
+	want := &Envelope{
+		Date:    time.Date(2016, 5, 11, 14, 31, 59, 0, time.UTC),
+		Subject: "Café",
+		From:    from,
+		Sender:  from,
+		ReplyTo: []*Address{{Name: "Mitsuha Miyamizu", Mailbox: "mitsuha.miyamizu+replies", Host: "example.org"}},
+		To: []*Address{
+			{Name: "Taki Tachibana", Mailbox: "taki.tachibana", Host: "example.org"},
+			{Mailbox: "undisclosed", Host: "example.org"},
+		},
+		InReplyTo: []string{"9876543210@example.org"},
+		MessageID: "0123456789@example.org",
+	}
+
+	if !env.Date.Equal(want.Date) {
+		t.Errorf("Expected date %v, got %v", want.Date, env.Date)
+	}
+	env.Date = want.Date
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("Expected envelope:\n%+v\nbut got:\n%+v", want, env)
+	}
+}
@@ -0,0 +1,329 @@
+package message
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// IndexedPart describes the location of an entity in a message read with
+// ReadIndex. Offsets are relative to the start of the message.
+type IndexedPart struct {
+	// Path is the list of multipart indices leading to the part, as in
+	// WalkFunc. The root entity has a nil path.
+	Path   []int
+	Header Header
+
+	// Offset is the offset of the header. The body starts right after the
+	// header, at Offset+HeaderSize.
+	Offset     int64
+	HeaderSize int64 // including the blank line separating it from the body
+	BodySize   int64
+	BodyLines  int64
+
+	// Children contains the parts of a multipart entity, in order.
+	Children []*IndexedPart
+
+	r io.ReaderAt
+}
+
+// Size returns the size of the part, including its header.
+func (p *IndexedPart) Size() int64 {
+	return p.HeaderSize + p.BodySize
+}
+
+// BodyOffset returns the offset of the part's body.
+func (p *IndexedPart) BodyOffset() int64 {
+	return p.Offset + p.HeaderSize
+}
+
+// Raw returns a reader for the raw part, including its header.
+func (p *IndexedPart) Raw() *io.SectionReader {
+	return io.NewSectionReader(p.r, p.Offset, p.Size())
+}
+
+// RawHeader returns a reader for the raw part's header, including the blank
+// line separating it from the body.
+func (p *IndexedPart) RawHeader() *io.SectionReader {
+	return io.NewSectionReader(p.r, p.Offset, p.HeaderSize)
+}
+
+// RawBody returns a reader for the raw part's body, without any transfer
+// encoding or charset decoding.
+func (p *IndexedPart) RawBody() *io.SectionReader {
+	return io.NewSectionReader(p.r, p.BodyOffset(), p.BodySize)
+}
+
+// Entity opens the part as an Entity, whose body is decoded as with New.
+// Each call returns a new Entity reading from the start of the body.
+//
+// If the part uses an unknown transfer encoding or charset, Entity returns an
+// error that verifies IsUnknownCharset, but also returns an Entity that can be
+// read.
+func (p *IndexedPart) Entity() (*Entity, error) {
+	return New(p.Header, p.RawBody())
+}
+
+// Part returns the descendant part with the provided path, relative to p. A
+// nil path designates p itself. If there is no such part, nil is returned.
+func (p *IndexedPart) Part(path []int) *IndexedPart {
+	for _, i := range path {
+		if i < 0 || i >= len(p.Children) {
+			return nil
+		}
+		p = p.Children[i]
+	}
+	return p
+}
+
+// Walk calls fn for p and each of its descendants, in depth-first order. If
+// fn returns an error, processing stops.
+func (p *IndexedPart) Walk(fn func(p *IndexedPart) error) error {
+	if err := fn(p); err != nil {
+		return err
+	}
+	for _, child := range p.Children {
+		if err := child.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countingReader counts the bytes read from the underlying io.Reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// ReadIndex parses the message stored in the first size bytes of r and
+// records the location of every part, so that parts can later be read
+// directly without parsing the whole message again.
+//
+// Only headers and boundary lines are parsed: part bodies are neither decoded
+// nor kept in memory.
+func ReadIndex(r io.ReaderAt, size int64) (*IndexedPart, error) {
+	return ReadIndexWithOptions(r, size, nil)
+}
+
+// ReadIndexWithOptions is like ReadIndex, but with options. If opts is nil,
+// the defaults are used.
+//
+// If the message exceeds one of the limits set in opts, an error of type
+// LimitExceededError is returned.
+func ReadIndexWithOptions(r io.ReaderAt, size int64, opts *ReadOptions) (*IndexedPart, error) {
+	if opts == nil {
+		opts = new(ReadOptions)
+	}
+	rs := &readState{opts: *opts}
+	return rs.readIndex(r, nil, 0, size, 0, -1)
+}
+
+// readIndex indexes the part stored at offset. lines is the number of lines of
+// the part, as returned by countLines, or -1 if unknown.
+func (rs *readState) readIndex(r io.ReaderAt, path []int, offset, size int64, depth int, lines int64) (*IndexedPart, error) {
+	cr := &countingReader{r: io.NewSectionReader(r, offset, size)}
+	br := bufio.NewReader(cr)
+	h, _, err := textproto.ReadHeaderWithOptions(br, rs.headerOptions())
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	headerSize := cr.n - int64(br.Buffered())
+
+	p := &IndexedPart{
+		Path:       path,
+		Header:     Header{h},
+		Offset:     offset,
+		HeaderSize: headerSize,
+		BodySize:   size - headerSize,
+		r:          r,
+	}
+
+	mediaType, mediaParams, _ := p.Header.ContentType()
+	boundary := mediaParams["boundary"]
+	if !strings.HasPrefix(mediaType, "multipart/") || boundary == "" {
+		if lines < 0 {
+			p.BodyLines, err = countLines(p.RawBody())
+		} else if p.BodySize > 0 {
+			// The header ends with a line break, only count its lines
+			var headerLines int64
+			headerLines, err = countLines(p.RawHeader())
+			p.BodyLines = lines - headerLines
+		}
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+
+	if max := rs.opts.MaxMultipartDepth; max > 0 && depth >= max {
+		return nil, LimitExceededError{Limit: "multipart depth", Max: int64(max)}
+	}
+
+	ranges, bodyLines, err := scanMultipart(p.RawBody(), boundary)
+	if err != nil {
+		return nil, err
+	}
+	p.BodyLines = bodyLines
+	for i, rg := range ranges {
+		rs.parts++
+		if max := rs.opts.MaxParts; max > 0 && rs.parts > max {
+			return nil, LimitExceededError{Limit: "multipart parts", Max: int64(max)}
+		}
+
+		childPath := make([]int, len(path)+1)
+		copy(childPath, path)
+		childPath[len(path)] = i
+
+		child, err := rs.readIndex(r, childPath, p.BodyOffset()+rg.start, rg.end-rg.start, depth+1, rg.lines)
+		if err != nil {
+			return nil, err
+		}
+		p.Children = append(p.Children, child)
+	}
+
+	return p, nil
+}
+
+// countLines returns the number of lines in r. A trailing incomplete line is
+// counted as a line.
+func countLines(r io.Reader) (int64, error) {
+	var lines int64
+	var last byte
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if last != 0 && last != '\n' {
+		lines++
+	}
+	return lines, nil
+}
+
+// partRange is the location of a part in a multipart body.
+type partRange struct {
+	start, end int64
+	lines      int64 // see countLines
+}
+
+// scanMultipart scans a multipart body for boundary delimiter lines and
+// returns the location of each part. The line break preceding a boundary
+// delimiter line belongs to the delimiter, as defined in RFC 2046 section
+// 5.1.1. The number of lines of the whole body is returned too, so that the
+// body only needs to be read once.
+//
+// Like textproto.MultipartReader, lines may end with "\n" instead of "\r\n".
+// A missing final boundary delimiter is tolerated.
+func scanMultipart(r io.Reader, boundary string) ([]partRange, int64, error) {
+	dashBoundary := []byte("--" + boundary)
+
+	var ranges []partRange
+	br := bufio.NewReader(r)
+	var offset int64
+	var nl int64 // number of line breaks before offset
+	var last byte
+	partStart, partNL := int64(-1), int64(0)
+	var prevNL int64  // length of the line break ending the previous line
+	prevEmpty := true // the previous line only contains its line break
+	midLine := false
+	done := false // the final boundary delimiter line has been read
+	for {
+		line, err := br.ReadSlice('\n')
+		full := err == bufio.ErrBufferFull
+		if err != nil && err != io.EOF && !full {
+			return nil, 0, err
+		}
+
+		lineNL := int64(0)
+		if bytes.HasSuffix(line, []byte("\n")) {
+			lineNL = 1
+		}
+
+		if !done && !midLine && bytes.HasPrefix(line, dashBoundary) {
+			rest := line[len(dashBoundary):]
+			final := bytes.HasPrefix(rest, []byte("--"))
+			if final {
+				rest = rest[2:]
+			}
+			rest = skipLWSP(rest)
+			if len(rest) == 0 || string(rest) == "\n" || string(rest) == "\r\n" {
+				if partStart >= 0 {
+					rg := partRange{start: partStart, end: offset - prevNL, lines: nl - partNL}
+					if prevNL > 0 {
+						rg.lines-- // the line break belongs to the delimiter
+					}
+					if rg.end <= rg.start {
+						rg.end, rg.lines = rg.start, 0
+					} else if !prevEmpty {
+						rg.lines++ // incomplete last line
+					}
+					ranges = append(ranges, rg)
+				}
+				partStart = offset + int64(len(line))
+				partNL = nl + lineNL
+				done = final
+			}
+		}
+
+		offset += int64(len(line))
+		nl += lineNL
+		if len(line) > 0 {
+			last = line[len(line)-1]
+		}
+		if !full {
+			switch {
+			case bytes.HasSuffix(line, []byte("\r\n")):
+				prevNL = 2
+			case bytes.HasSuffix(line, []byte("\n")):
+				prevNL = 1
+			default:
+				prevNL = 0
+			}
+			prevEmpty = !midLine && int64(len(line)) == prevNL
+		}
+		midLine = full
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if partStart >= 0 && !done {
+		rg := partRange{start: partStart, end: offset, lines: nl - partNL}
+		if offset > partStart && last != '\n' {
+			rg.lines++
+		}
+		ranges = append(ranges, rg)
+	}
+
+	lines := nl
+	if offset > 0 && last != '\n' {
+		lines++
+	}
+	return ranges, lines, nil
+}
+
+func skipLWSP(b []byte) []byte {
+	for len(b) > 0 && (b[0] == ' ' || b[0] == '\t') {
+		b = b[1:]
+	}
+	return b
+}
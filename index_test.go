@@ -0,0 +1,186 @@
+package message
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIndexMessage = "Subject: Indexed\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"Preamble\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Hello\r\n" +
+	"World\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"Y2lhbw==\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>ciao</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer--\r\n" +
+	"Epilogue\r\n"
+
+func TestReadIndex(t *testing.T) {
+	root, err := ReadIndex(strings.NewReader(testIndexMessage), int64(len(testIndexMessage)))
+	if err != nil {
+		t.Fatalf("ReadIndex() = %v", err)
+	}
+
+	type partInfo struct {
+		path      []int
+		mediaType string
+		rawBody   string
+		lines     int64
+	}
+	want := []partInfo{
+		{nil, "multipart/mixed", testIndexMessage[strings.Index(testIndexMessage, "Preamble"):], 21},
+		{[]int{0}, "text/plain", "Hello\r\nWorld", 2},
+		{[]int{1}, "multipart/alternative", "--inner\r\nContent-Type: text/plain\r\n" +
+			"Content-Transfer-Encoding: base64\r\n\r\nY2lhbw==\r\n--inner\r\n" +
+			"Content-Type: text/html\r\n\r\n<p>ciao</p>\r\n--inner--", 10},
+		{[]int{1, 0}, "text/plain", "Y2lhbw==", 1},
+		{[]int{1, 1}, "text/html", "<p>ciao</p>", 1},
+	}
+
+	var got []partInfo
+	err = root.Walk(func(p *IndexedPart) error {
+		mediaType, _, _ := p.Header.ContentType()
+		b, err := ioutil.ReadAll(p.RawBody())
+		if err != nil {
+			return err
+		}
+		got = append(got, partInfo{p.Path, mediaType, string(b), p.BodyLines})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected parts:\n%+v\nbut got:\n%+v", want, got)
+	}
+
+	p := root.Part([]int{1, 0})
+	if p == nil {
+		t.Fatalf("Part() = nil")
+	}
+	raw, _ := ioutil.ReadAll(p.Raw())
+	wantRaw := "Content-Type: text/plain\r\nContent-Transfer-Encoding: base64\r\n\r\nY2lhbw=="
+	if string(raw) != wantRaw {
+		t.Errorf("Expected raw part %q, got %q", wantRaw, raw)
+	}
+	if got := testIndexMessage[p.Offset : p.Offset+p.Size()]; got != wantRaw {
+		t.Errorf("Expected offsets to point to %q, got %q", wantRaw, got)
+	}
+
+	e, err := p.Entity()
+	if err != nil {
+		t.Fatalf("Entity() = %v", err)
+	}
+	b, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		t.Fatalf("failed to read decoded body: %v", err)
+	}
+	if s := string(b); s != "ciao" {
+		t.Errorf("Expected decoded body %q, got %q", "ciao", s)
+	}
+
+	if p := root.Part([]int{2}); p != nil {
+		t.Errorf("Expected nil for a missing part, got %+v", p)
+	}
+}
+
+func TestReadIndex_entityMultipart(t *testing.T) {
+	root, err := ReadIndex(strings.NewReader(testIndexMessage), int64(len(testIndexMessage)))
+	if err != nil {
+		t.Fatalf("ReadIndex() = %v", err)
+	}
+
+	e, err := root.Part([]int{1}).Entity()
+	if err != nil {
+		t.Fatalf("Entity() = %v", err)
+	}
+	var types []string
+	err = e.Walk(func(path []int, part *Entity, err error) error {
+		t, _, _ := part.Header.ContentType()
+		types = append(types, t)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Walk() = %v", err)
+	}
+	want := []string{"multipart/alternative", "text/plain", "text/html"}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("Expected types %v, got %v", want, types)
+	}
+}
+
+func TestReadIndex_lf(t *testing.T) {
+	s := strings.Replace(testIndexMessage, "\r\n", "\n", -1)
+	root, err := ReadIndex(strings.NewReader(s), int64(len(s)))
+	if err != nil {
+		t.Fatalf("ReadIndex() = %v", err)
+	}
+	b, _ := ioutil.ReadAll(root.Part([]int{0}).RawBody())
+	if string(b) != "Hello\nWorld" {
+		t.Errorf("Expected body %q, got %q", "Hello\nWorld", b)
+	}
+}
+
+func TestReadIndexWithOptions_limits(t *testing.T) {
+	r := strings.NewReader(testIndexMessage)
+	size := int64(len(testIndexMessage))
+
+	_, err := ReadIndexWithOptions(r, size, &ReadOptions{MaxMultipartDepth: 1})
+	if limitErr, ok := err.(LimitExceededError); !ok || limitErr.Limit != "multipart depth" {
+		t.Errorf("Expected a multipart depth error, got %v", err)
+	}
+
+	_, err = ReadIndexWithOptions(r, size, &ReadOptions{MaxParts: 3})
+	if limitErr, ok := err.(LimitExceededError); !ok || limitErr.Limit != "multipart parts" {
+		t.Errorf("Expected a multipart parts error, got %v", err)
+	}
+}
+
+func TestReadIndex_bodyLines(t *testing.T) {
+	tests := []string{
+		testIndexMessage,
+		strings.Replace(testIndexMessage, "\r\n", "\n", -1),
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+			"--b\r\n\r\nTrailing empty line\r\n\r\n" +
+			"--b\r\n--b\r\nContent-Type: text/plain\r\n\r\n" +
+			strings.Repeat("a", 10000) + "\r\n" +
+			"--b\r\n\r\nNo final boundary",
+		"Content-Type: multipart/mixed; boundary=b\n\n--b\n\n\n--b--\nEpilogue",
+	}
+	for _, s := range tests {
+		root, err := ReadIndex(strings.NewReader(s), int64(len(s)))
+		if err != nil {
+			t.Fatalf("ReadIndex() = %v", err)
+		}
+		err = root.Walk(func(p *IndexedPart) error {
+			want, err := countLines(p.RawBody())
+			if err != nil {
+				return err
+			}
+			if p.BodyLines != want {
+				t.Errorf("Expected part %v of %q to have %v lines, got %v", p.Path, s, want, p.BodyLines)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk() = %v", err)
+		}
+	}
+}
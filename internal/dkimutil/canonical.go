@@ -0,0 +1,225 @@
+package dkimutil
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// isWSP reports whether c is a WSP character, as defined in RFC 5234.
+func isWSP(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// CanonicalizeHeader canonicalizes a raw header field, including its trailing
+// CRLF. If relaxed is false, the simple algorithm is used. Both CRLF and bare
+// LF line endings are accepted.
+func CanonicalizeHeader(raw []byte, relaxed bool) []byte {
+	if !relaxed {
+		return bytes.ReplaceAll(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	}
+
+	// RFC 6376 section 3.4.2
+	kv := bytes.SplitN(raw, []byte{':'}, 2)
+	k := strings.ToLower(strings.TrimRight(string(kv[0]), " \t"))
+	var v []byte
+	if len(kv) == 2 {
+		v = kv[1]
+	}
+
+	var b bytes.Buffer
+	b.Grow(len(raw))
+	b.WriteString(k)
+	b.WriteByte(':')
+
+	wsp := false
+	for _, c := range v {
+		switch {
+		case c == '\r' || c == '\n':
+			// Unfold
+		case isWSP(c):
+			wsp = true
+		default:
+			if wsp && b.Len() > len(k)+1 {
+				b.WriteByte(' ')
+			}
+			wsp = false
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+// BodyCanonicalizer canonicalizes a message body, as defined in RFC 6376
+// section 3.4.
+type BodyCanonicalizer struct {
+	w       io.Writer
+	relaxed bool
+
+	line       []byte // current incomplete line
+	crlfs      int    // pending empty lines
+	nonEmpty   bool   // at least one non-empty line has been written
+	lineEnding bool   // the last byte was a line ending
+}
+
+// NewBodyCanonicalizer creates a BodyCanonicalizer writing to w. If relaxed is
+// false, the simple algorithm is used.
+func NewBodyCanonicalizer(w io.Writer, relaxed bool) *BodyCanonicalizer {
+	return &BodyCanonicalizer{w: w, relaxed: relaxed}
+}
+
+func (bc *BodyCanonicalizer) Write(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			bc.line = append(bc.line, b...)
+			break
+		}
+		bc.line = append(bc.line, b[:i]...)
+		b = b[i+1:]
+
+		if err := bc.writeLine(); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (bc *BodyCanonicalizer) writeLine() error {
+	line := bc.line
+	bc.line = bc.line[:0]
+
+	// Accept both CRLF and bare LF line endings
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+
+	if bc.relaxed {
+		line = relaxLine(line)
+	}
+
+	if len(line) == 0 {
+		// Empty lines at the end of the body are ignored, so wait until
+		// we know whether this is the end
+		bc.crlfs++
+		return nil
+	}
+
+	var b bytes.Buffer
+	for ; bc.crlfs > 0; bc.crlfs-- {
+		b.WriteString("\r\n")
+	}
+	b.Write(line)
+	b.WriteString("\r\n")
+	bc.nonEmpty = true
+	_, err := bc.w.Write(b.Bytes())
+	return err
+}
+
+// Close flushes the last line. It doesn't close the underlying writer.
+func (bc *BodyCanonicalizer) Close() error {
+	if len(bc.line) > 0 {
+		// The body doesn't end with a CRLF, add one
+		if err := bc.writeLine(); err != nil {
+			return err
+		}
+	}
+
+	if !bc.nonEmpty && !bc.relaxed {
+		// RFC 6376 section 3.4.3: an empty body is canonicalized as a single
+		// CRLF by the simple algorithm
+		_, err := bc.w.Write([]byte("\r\n"))
+		return err
+	}
+	return nil
+}
+
+// relaxLine ignores whitespace at the end of the line and reduces sequences of
+// whitespace to a single space, as defined in RFC 6376 section 3.4.4.
+func relaxLine(line []byte) []byte {
+	var b bytes.Buffer
+	wsp := false
+	for _, c := range line {
+		if isWSP(c) {
+			wsp = true
+			continue
+		}
+		if wsp {
+			b.WriteByte(' ')
+			wsp = false
+		}
+		b.WriteByte(c)
+	}
+	return b.Bytes()
+}
+
+// LimitWriter discards bytes written after the first N bytes.
+type LimitWriter struct {
+	W io.Writer
+	N int64 // negative for no limit
+}
+
+func (lw *LimitWriter) Write(b []byte) (int, error) {
+	if lw.N < 0 {
+		return lw.W.Write(b)
+	}
+	n := len(b)
+	if int64(len(b)) > lw.N {
+		b = b[:lw.N]
+	}
+	lw.N -= int64(len(b))
+	if len(b) == 0 {
+		return n, nil
+	}
+	_, err := lw.W.Write(b)
+	return n, err
+}
+
+// CountWriter counts the number of bytes written to it.
+type CountWriter struct {
+	W io.Writer
+	N int64
+}
+
+func (cw *CountWriter) Write(b []byte) (int, error) {
+	n, err := cw.W.Write(b)
+	cw.N += int64(n)
+	return n, err
+}
+
+// RemoveSignature removes the value of the b= tag of a raw header field
+// containing a tag-list, as required to compute the header hash.
+func RemoveSignature(raw []byte) []byte {
+	colon := bytes.IndexByte(raw, ':')
+	if colon < 0 {
+		return raw
+	}
+
+	var b bytes.Buffer
+	b.Write(raw[:colon+1])
+	rest := raw[colon+1:]
+	for len(rest) > 0 {
+		var tag []byte
+		if i := bytes.IndexByte(rest, ';'); i >= 0 {
+			tag, rest = rest[:i+1], rest[i+1:]
+		} else {
+			tag, rest = rest, nil
+		}
+
+		eq := bytes.IndexByte(tag, '=')
+		if eq < 0 || string(bytes.TrimSpace(tag[:eq])) != "b" {
+			b.Write(tag)
+			continue
+		}
+
+		b.Write(tag[:eq+1])
+		if bytes.HasSuffix(tag, []byte{';'}) {
+			b.WriteByte(';')
+		} else if bytes.HasSuffix(tag, []byte("\r\n")) {
+			b.WriteString("\r\n")
+		} else if bytes.HasSuffix(tag, []byte("\n")) {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
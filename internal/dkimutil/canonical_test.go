@@ -0,0 +1,58 @@
+package dkimutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCanonicalizeHeader(t *testing.T) {
+	raw := "SubJect : Hello \r\n\t World  \r\n"
+	tests := []struct {
+		relaxed bool
+		want    string
+	}{
+		{false, raw},
+		{true, "subject:Hello World\r\n"},
+	}
+	for _, test := range tests {
+		got := string(CanonicalizeHeader([]byte(raw), test.relaxed))
+		if got != test.want {
+			t.Errorf("CanonicalizeHeader(%q, %v) = %q, want %q", raw, test.relaxed, got, test.want)
+		}
+	}
+
+	raw = "SubJect : Hello \n\t World  \n"
+	if got, want := string(CanonicalizeHeader([]byte(raw), false)), "SubJect : Hello \r\n\t World  \r\n"; got != want {
+		t.Errorf("CanonicalizeHeader(%q, false) = %q, want %q", raw, got, want)
+	}
+}
+
+func TestBodyCanonicalizer(t *testing.T) {
+	tests := []struct {
+		relaxed bool
+		body    string
+		want    string
+	}{
+		{false, "", "\r\n"},
+		{false, "Hi \r\n\r\n\r\n", "Hi \r\n"},
+		{false, "Hi", "Hi\r\n"},
+		{true, "", ""},
+		{true, " C \r\nD \t E\r\n\r\n\r\n", " C\r\nD E\r\n"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		bc := NewBodyCanonicalizer(&buf, test.relaxed)
+		// Write byte by byte to exercise buffering across writes
+		for i := 0; i < len(test.body); i++ {
+			if _, err := bc.Write([]byte{test.body[i]}); err != nil {
+				t.Fatalf("Write() = %v", err)
+			}
+		}
+		if err := bc.Close(); err != nil {
+			t.Fatalf("Close() = %v", err)
+		}
+		if got := buf.String(); got != test.want {
+			t.Errorf("canonicalize body %q with %v = %q, want %q", test.body, test.relaxed, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package dkimutil contains helpers shared by the dkim and arc packages.
+package dkimutil
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// HashAlgo is the only supported hash algorithm, see RFC 8301.
+const HashAlgo = crypto.SHA256
+
+// ParseTagList parses a tag-list, as defined in RFC 6376 section 3.2.
+func ParseTagList(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, tv := range strings.Split(s, ";") {
+		tv = strings.TrimSpace(tv)
+		if tv == "" {
+			// The last tag can be followed by a semicolon
+			continue
+		}
+
+		kv := strings.SplitN(tv, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("malformed tag-list: missing '=' in %q", tv)
+		}
+		k := strings.TrimSpace(kv[0])
+		if k == "" {
+			return nil, fmt.Errorf("malformed tag-list: empty tag name")
+		}
+		if _, ok := tags[k]; ok {
+			return nil, fmt.Errorf("malformed tag-list: duplicate tag %q", k)
+		}
+		tags[k] = strings.TrimSpace(kv[1])
+	}
+	return tags, nil
+}
+
+// StripWhitespace removes all whitespace from s. It's used for base64 tag
+// values, which can contain FWS.
+func StripWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// SplitColonList parses a colon-separated list, e.g. the value of a h= tag.
+// Empty items are ignored.
+func SplitColonList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ":") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
+
+// SignedHeaderFields returns the raw header fields to hash for the provided
+// header keys, as defined in RFC 6376 section 5.4.2: if a key appears
+// multiple times, fields are selected from the bottom of the header. A key
+// without a corresponding field is ignored.
+func SignedHeaderFields(h *textproto.Header, keys []string) [][]byte {
+	fields := make(map[string][][]byte)
+	var l [][]byte
+	for _, k := range keys {
+		k = strings.ToLower(k)
+		fs, ok := fields[k]
+		if !ok {
+			hf := h.FieldsByKey(k)
+			for hf.Next() {
+				raw, err := hf.Raw()
+				if err != nil {
+					continue
+				}
+				fs = append(fs, raw)
+			}
+		}
+		if len(fs) > 0 {
+			l = append(l, fs[len(fs)-1])
+			fs = fs[:len(fs)-1]
+		}
+		fields[k] = fs
+	}
+	return l
+}
+
+// FormatTagList formats a header field containing a tag-list. Lines are
+// folded between tags, and the values of the b= and bh= tags are split.
+func FormatTagList(name string, tags [][2]string) []byte {
+	const maxLen = 76
+
+	var b strings.Builder
+	b.WriteString(name + ":")
+	lineLen := b.Len()
+	for i, tag := range tags {
+		s := " " + tag[0] + "=" + tag[1]
+		if i < len(tags)-1 {
+			s += ";"
+		}
+
+		if tag[0] == "b" || tag[0] == "bh" {
+			// Base64 values can contain FWS: split them if necessary. Folding
+			// must not depend on the value length, so that the field hashed
+			// with an empty b= tag has the same layout as the final one.
+			for len(s) > 0 {
+				n := maxLen - lineLen
+				if n < 8 {
+					b.WriteString("\r\n ")
+					lineLen = 1
+					continue
+				}
+				if n > len(s) {
+					n = len(s)
+				}
+				b.WriteString(s[:n])
+				lineLen += n
+				s = s[n:]
+			}
+		} else {
+			if lineLen+len(s) > maxLen && lineLen > len(name)+1 {
+				b.WriteString("\r\n")
+				lineLen = 0
+			}
+			b.WriteString(s)
+			lineLen += len(s)
+		}
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// KeyAlgo returns the name of the signing algorithm for a public key, either
+// "rsa" or "ed25519". It returns an empty string if the key type is not
+// supported.
+func KeyAlgo(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return "rsa"
+	case ed25519.PublicKey:
+		return "ed25519"
+	default:
+		return ""
+	}
+}
+
+// Sign signs a hash computed with HashAlgo.
+func Sign(signer crypto.Signer, hashed []byte) ([]byte, error) {
+	switch KeyAlgo(signer.Public()) {
+	case "rsa":
+		return signer.Sign(rand.Reader, hashed, HashAlgo)
+	case "ed25519":
+		// RFC 8463 section 3: the hash is signed with PureEdDSA
+		return signer.Sign(rand.Reader, hashed, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %T", signer.Public())
+	}
+}
+
+// Verify checks a signature of a hash computed with HashAlgo.
+func Verify(pub crypto.PublicKey, hashed, sig []byte) error {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, HashAlgo, hashed, sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, hashed, sig) {
+			return errors.New("ed25519: verification error")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key algorithm %T", pub)
+	}
+}
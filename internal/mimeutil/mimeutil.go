@@ -0,0 +1,142 @@
+// Package mimeutil contains helpers shared by the smime and pgpmime packages
+// to read and write multipart/signed entities, as defined in RFC 1847.
+package mimeutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// CRLFWriter converts bare LF line endings to CRLF, the canonical form of
+// MIME entities.
+type CRLFWriter struct {
+	W  io.Writer
+	cr bool // the last byte written was a CR
+}
+
+func (cw *CRLFWriter) Write(b []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, c := range b {
+		if c == '\n' && !cw.cr {
+			buf.WriteByte('\r')
+		}
+		buf.WriteByte(c)
+		cw.cr = c == '\r'
+	}
+	if _, err := cw.W.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// RandomBoundary generates a random multipart boundary.
+func RandomBoundary() string {
+	var buf [30]byte
+	if _, err := io.ReadFull(rand.Reader, buf[:]); err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%x", buf[:])
+}
+
+// MultipartSignedWriter writes a multipart/signed entity.
+type MultipartSignedWriter struct {
+	w        io.Writer
+	cw       *CRLFWriter
+	boundary string
+}
+
+// NewMultipartSignedWriter writes the header of a multipart/signed entity to
+// w. params are the Content-Type parameters, the boundary is generated.
+//
+// The signed entity written to the returned MultipartSignedWriter is
+// converted to canonical form, and written to both w and signed.
+func NewMultipartSignedWriter(w io.Writer, h message.Header, params map[string]string, signed io.Writer) (*MultipartSignedWriter, error) {
+	h = h.Copy()
+	if !h.Has("Mime-Version") {
+		h.Set("MIME-Version", "1.0")
+	}
+	boundary := RandomBoundary()
+	ctParams := map[string]string{"boundary": boundary}
+	for k, v := range params {
+		ctParams[k] = v
+	}
+	h.SetContentType("multipart/signed", ctParams)
+	h.Del("Content-Transfer-Encoding")
+
+	if err := textproto.WriteHeader(w, h.Header); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(w, "This is a cryptographically signed message in MIME format.\r\n\r\n--%s\r\n", boundary); err != nil {
+		return nil, err
+	}
+
+	return &MultipartSignedWriter{
+		w:        w,
+		cw:       &CRLFWriter{W: io.MultiWriter(w, signed)},
+		boundary: boundary,
+	}, nil
+}
+
+// Write writes a part of the signed entity.
+func (mw *MultipartSignedWriter) Write(b []byte) (int, error) {
+	return mw.cw.Write(b)
+}
+
+// WriteSignature finishes the signed entity and writes the signature part,
+// with the provided header and raw body.
+func (mw *MultipartSignedWriter) WriteSignature(h textproto.Header, body []byte) error {
+	if _, err := fmt.Fprintf(mw.w, "\r\n--%s\r\n", mw.boundary); err != nil {
+		return err
+	}
+	if err := textproto.WriteHeader(mw.w, h); err != nil {
+		return err
+	}
+	if _, err := (&CRLFWriter{W: mw.w}).Write(body); err != nil {
+		return err
+	}
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		if _, err := io.WriteString(mw.w, "\r\n"); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(mw.w, "--%s--\r\n", mw.boundary)
+	return err
+}
+
+// ReadMultipartSigned splits the body of a multipart/signed entity into the
+// signed entity and the signature entity.
+//
+// The signed entity is returned byte-for-byte, using the raw representation
+// of its header, in canonical form.
+func ReadMultipartSigned(body io.Reader, boundary string) (signed []byte, sig *message.Entity, err error) {
+	mr := textproto.NewMultipartReader(body, boundary)
+
+	p, err := mr.NextPart()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read signed part: %v", err)
+	}
+	var buf bytes.Buffer
+	cw := &CRLFWriter{W: &buf}
+	if err := textproto.WriteHeader(cw, p.Header); err != nil {
+		return nil, nil, err
+	}
+	if _, err := io.Copy(cw, p); err != nil {
+		return nil, nil, fmt.Errorf("failed to read signed part: %v", err)
+	}
+
+	p, err = mr.NextPart()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read signature part: %v", err)
+	}
+	sig, err = message.New(message.Header{Header: p.Header}, p)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), sig, nil
+}
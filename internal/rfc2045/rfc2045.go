@@ -0,0 +1,26 @@
+// Package rfc2045 contains helpers for the lexical tokens of MIME header
+// fields, as defined in RFC 2045.
+package rfc2045
+
+import (
+	"strings"
+)
+
+// IsTokenChar reports whether c is a token character, as defined in RFC 2045
+// section 5.1.
+func IsTokenChar(c byte) bool {
+	return c > ' ' && c < 0x7f && !strings.ContainsRune(`()<>@,;:\"/[]?=`, rune(c))
+}
+
+// IsToken reports whether s is a non-empty token.
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !IsTokenChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+// Package rfc2047 implements encoding of non-ASCII header text with RFC 2047
+// encoded-words.
+package rfc2047
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxWordLen is the maximum length of an encoded-word, as defined in RFC
+	// 2047 section 2.
+	maxWordLen = 75
+	// maxLineLen is the preferred maximum length of a header line, as defined
+	// in RFC 5322 section 2.1.1.
+	maxLineLen = 76
+
+	charset = "utf-8"
+	// wordOverhead is the length of "=?utf-8?q?" and "?="
+	wordOverhead = len("=?" + charset + "?q?" + "?=")
+)
+
+// isPhraseSafe reports whether c can be represented as itself in a Q-encoded
+// word appearing in a phrase, as defined in RFC 2047 section 5 (3).
+func isPhraseSafe(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '!' || c == '*' || c == '+' || c == '-' || c == '/'
+}
+
+// isTextSafe reports whether c can be represented as itself in a Q-encoded
+// word appearing in unstructured text.
+func isTextSafe(c byte) bool {
+	return '!' <= c && c <= '~' && c != '=' && c != '?' && c != '_'
+}
+
+// isAtext reports whether c is an ASCII atom character, as defined in RFC
+// 5322 section 3.2.3.
+func isAtext(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0
+}
+
+type encoder struct {
+	phrase bool
+}
+
+// needsEncoding reports whether a word needs to be encoded.
+func (enc *encoder) needsEncoding(w string) bool {
+	if strings.Contains(w, "=?") && strings.Contains(w, "?=") {
+		// Could be mistaken for an encoded-word
+		return true
+	}
+	for i := 0; i < len(w); i++ {
+		c := w[i]
+		if c >= utf8.RuneSelf || (c < ' ' && c != '\t') || c == 0x7f {
+			return true
+		}
+		if enc.phrase && !isAtext(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// qLen returns the length of the Q encoding of s.
+func (enc *encoder) qLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c == ' ' || enc.isSafe(c) {
+			n++
+		} else {
+			n += 3
+		}
+	}
+	return n
+}
+
+func (enc *encoder) isSafe(c byte) bool {
+	if enc.phrase {
+		return isPhraseSafe(c)
+	}
+	return isTextSafe(c)
+}
+
+func (enc *encoder) writeQ(sb *strings.Builder, s string) {
+	const hex = "0123456789ABCDEF"
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == ' ':
+			sb.WriteByte('_')
+		case enc.isSafe(c):
+			sb.WriteByte(c)
+		default:
+			sb.WriteByte('=')
+			sb.WriteByte(hex[c>>4])
+			sb.WriteByte(hex[c&0x0f])
+		}
+	}
+}
+
+// encodeRun encodes s as a list of encoded-words separated by spaces. The
+// first encoded-word is at most first characters long, the next ones at most
+// maxWordLen characters long. Words are split at rune boundaries. The shortest
+// encoding between B and Q is used.
+func (enc *encoder) encodeRun(s string, first int) string {
+	useB := base64.StdEncoding.EncodedLen(len(s)) < enc.qLen(s)
+
+	var sb strings.Builder
+	limit := first
+	for len(s) > 0 {
+		// Find the longest prefix fitting in the limit
+		n, encLen := 0, 0
+		for n < len(s) {
+			_, size := utf8.DecodeRuneInString(s[n:])
+			var l int
+			if useB {
+				l = base64.StdEncoding.EncodedLen(n+size) - encLen
+			} else {
+				l = enc.qLen(s[n : n+size])
+			}
+			if wordOverhead+encLen+l > limit && n > 0 {
+				break
+			}
+			n += size
+			encLen += l
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+		if useB {
+			sb.WriteString("=?" + charset + "?b?")
+			sb.WriteString(base64.StdEncoding.EncodeToString([]byte(s[:n])))
+		} else {
+			sb.WriteString("=?" + charset + "?q?")
+			enc.writeQ(&sb, s[:n])
+		}
+		sb.WriteString("?=")
+
+		s = s[n:]
+		limit = maxWordLen
+	}
+	return sb.String()
+}
+
+func (enc *encoder) encode(s string, offset int) string {
+	words := strings.Split(s, " ")
+
+	// needsEncoding reports whether the word at index i needs to be part of
+	// an encoded run. Empty words (consecutive spaces) followed by a word
+	// which needs encoding are included, since whitespace between adjacent
+	// encoded-words is ignored.
+	needsEncoding := func(i int) bool {
+		for ; i < len(words); i++ {
+			if words[i] != "" {
+				return enc.needsEncoding(words[i])
+			}
+		}
+		return false
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(words); {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+
+		if words[i] == "" || !needsEncoding(i) {
+			sb.WriteString(words[i])
+			i++
+			continue
+		}
+
+		j := i + 1
+		for j < len(words) && needsEncoding(j) {
+			j++
+		}
+
+		first := maxWordLen
+		if sb.Len() == 0 {
+			// The encoded-word starts the header field value, it can't be
+			// folded onto a new line
+			if avail := maxLineLen - offset; avail < first {
+				first = avail
+			}
+		}
+		sb.WriteString(enc.encodeRun(strings.Join(words[i:j], " "), first))
+		i = j
+	}
+	return sb.String()
+}
+
+// EncodeText encodes unstructured text, as defined in RFC 2047 section 5 (1).
+// Words containing non-ASCII or control characters are encoded, other words
+// are left as-is. offset is the number of characters preceding the value on
+// the first line, usually the length of the header field name plus two, so
+// that header lines folded at whitespace don't exceed 76 characters.
+func EncodeText(s string, offset int) string {
+	enc := encoder{}
+	return enc.encode(s, offset)
+}
+
+// EncodePhrase encodes a phrase, e.g. a display name, as defined in RFC 2047
+// section 5 (3). Words which aren't atoms are encoded. offset is as in
+// EncodeText.
+func EncodePhrase(s string, offset int) string {
+	enc := encoder{phrase: true}
+	return enc.encode(s, offset)
+}
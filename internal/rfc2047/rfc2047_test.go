@@ -0,0 +1,92 @@
+package rfc2047
+
+import (
+	"mime"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var encodeTests = []struct {
+	name string
+	s    string
+}{
+	{"ascii", "Hello world"},
+	{"latin", "Café au lait, s'il vous plaît"},
+	{"cjk", "日本語のテキストはとても長いのでいくつかのエンコードされた単語に分割されるべきです"},
+	{"emoji", strings.Repeat("😀", 40)},
+	{"spaces", "Café  à  la   crème"},
+	{"encodedWordLookalike", "=?utf-8?q?not_encoded?="},
+	{"long", strings.Repeat("Ünïcödé ", 20)},
+}
+
+func TestEncodeText(t *testing.T) {
+	dec := new(mime.WordDecoder)
+	for _, tc := range encodeTests {
+		t.Run(tc.name, func(t *testing.T) {
+			enc := EncodeText(tc.s, len("Subject: "))
+
+			got, err := dec.DecodeHeader(enc)
+			if err != nil {
+				t.Fatalf("DecodeHeader(%q) = %v", enc, err)
+			}
+			if got != tc.s {
+				t.Errorf("EncodeText(%q) = %q, which decodes to %q", tc.s, enc, got)
+			}
+
+			for i, w := range strings.Fields(enc) {
+				if !strings.HasPrefix(w, "=?") {
+					continue
+				}
+				if max := maxWordLen; i == 0 {
+					max = maxLineLen - len("Subject: ")
+					if len(w) > max {
+						t.Errorf("First encoded-word %q is longer than %v", w, max)
+					}
+				} else if len(w) > max {
+					t.Errorf("Encoded-word %q is longer than %v", w, max)
+				}
+				decoded, err := dec.Decode(w)
+				if err != nil {
+					t.Errorf("Decode(%q) = %v", w, err)
+				} else if !utf8.ValidString(decoded) {
+					t.Errorf("Encoded-word %q isn't split at a rune boundary", w)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeText_ascii(t *testing.T) {
+	s := "Re: Café meeting"
+	want := "Re: =?utf-8?b?Q2Fmw6k=?= meeting"
+	if got := EncodeText(s, 0); got != want {
+		t.Errorf("EncodeText(%q) = %q, want %q", s, got, want)
+	}
+}
+
+func TestEncodeText_chooseEncoding(t *testing.T) {
+	if got := EncodeText("Ünderstandingly", 0); !strings.Contains(got, "?q?") {
+		t.Errorf("Expected Q encoding for mostly ASCII text, got %q", got)
+	}
+	if got := EncodeText("日本語", 0); !strings.Contains(got, "?b?") {
+		t.Errorf("Expected B encoding for CJK text, got %q", got)
+	}
+}
+
+func TestEncodePhrase(t *testing.T) {
+	s := "Doe, Jöhn"
+	enc := EncodePhrase(s, 0)
+	for i := 0; i < len(enc); i++ {
+		if enc[i] == ',' {
+			t.Fatalf("EncodePhrase(%q) = %q, contains a special character", s, enc)
+		}
+	}
+	got, err := new(mime.WordDecoder).DecodeHeader(enc)
+	if err != nil {
+		t.Fatalf("DecodeHeader(%q) = %v", enc, err)
+	}
+	if got != s {
+		t.Errorf("EncodePhrase(%q) = %q, which decodes to %q", s, enc, got)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"mime"
 	"net/mail"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/rfc2047"
 )
 
 // Address represents a single mail address.
@@ -13,10 +15,28 @@ import (
 // Address is expected
 type Address = mail.Address
 
-func formatAddressList(l []*Address) string {
+// formatAddress formats an address. Non-ASCII display names are encoded with
+// RFC 2047. offset is the number of characters preceding the address on the
+// line.
+func formatAddress(a *Address, offset int) string {
+	for i := 0; i < len(a.Name); i++ {
+		if a.Name[i] >= utf8.RuneSelf {
+			addr := &Address{Address: a.Address}
+			return rfc2047.EncodePhrase(a.Name, offset) + " " + addr.String()
+		}
+	}
+	return a.String()
+}
+
+// formatAddressList formats a list of addresses for the header field k.
+func formatAddressList(k string, l []*Address) string {
 	formatted := make([]string, len(l))
 	for i, a := range l {
-		formatted[i] = a.String()
+		offset := 0
+		if i == 0 {
+			offset = len(k) + len(": ")
+		}
+		formatted[i] = formatAddress(a, offset)
 	}
 	return strings.Join(formatted, ", ")
 }
@@ -0,0 +1,410 @@
+package mail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/emersion/go-message/internal/rfc2045"
+)
+
+// An AuthResultProperty is a property of an authentication result, as defined
+// in RFC 8601 section 2.3. For instance, the property "header.d=example.org"
+// has the type "header", the name "d" and the value "example.org".
+type AuthResultProperty struct {
+	Type  string
+	Name  string
+	Value string
+	// Comments attached to the property, without parentheses.
+	Comments []string
+}
+
+// An AuthResult is the result of an authentication method, as defined in
+// RFC 8601 section 2.2.
+//
+// Unknown methods and properties are preserved as-is.
+type AuthResult struct {
+	// The authentication method, e.g. "spf", "dkim", "dmarc" or "arc".
+	Method string
+	// The version of the method, if any.
+	MethodVersion string
+	// Comments attached to the method, without parentheses.
+	MethodComments []string
+	// The result of the method, e.g. "pass" or "fail".
+	Value string
+	// A human-readable reason for the result (reason= tag).
+	Reason string
+	// Comments attached to the result and its reason, without parentheses.
+	Comments []string
+	// Additional properties, e.g. "smtp.mailfrom" or "header.d".
+	Properties []AuthResultProperty
+}
+
+// Property returns the value of the first property with the specified type
+// and name. It returns an empty string if there is no such property.
+func (r *AuthResult) Property(typ, name string) string {
+	for _, prop := range r.Properties {
+		if strings.EqualFold(prop.Type, typ) && strings.EqualFold(prop.Name, name) {
+			return prop.Value
+		}
+	}
+	return ""
+}
+
+// AuthenticationResults is the content of an Authentication-Results header
+// field, as defined in RFC 8601.
+type AuthenticationResults struct {
+	// The authentication service identifier, usually a host name.
+	Identifier string
+	// The version of the header field format, if any.
+	Version string
+	// Comments attached to the identifier and version, without parentheses.
+	Comments []string
+	// The authentication results. If empty, no authentication was performed.
+	Results []AuthResult
+}
+
+// ParseAuthenticationResults parses the value of an Authentication-Results
+// header field.
+func ParseAuthenticationResults(s string) (*AuthenticationResults, error) {
+	p := authResultsParser{headerParser{s}}
+
+	ar := &AuthenticationResults{}
+	if err := p.consumeCFWS(&ar.Comments); err != nil {
+		return nil, err
+	}
+	id, err := p.parseValue()
+	if err != nil {
+		return nil, fmt.Errorf("mail: malformed authserv-id: %v", err)
+	}
+	ar.Identifier = id
+	if err := p.consumeCFWS(&ar.Comments); err != nil {
+		return nil, err
+	}
+
+	if !p.empty() && isDigit(p.peek()) {
+		ar.Version = p.consumeWhile(isDigit)
+		if err := p.consumeCFWS(&ar.Comments); err != nil {
+			return nil, err
+		}
+	}
+
+	for !p.empty() {
+		if !p.consume(';') {
+			return nil, fmt.Errorf("mail: expected ';' in authentication results, got %q", p.s)
+		}
+		if err := p.consumeCFWS(nil); err != nil {
+			return nil, err
+		}
+		if p.empty() {
+			// Trailing semicolon
+			break
+		}
+
+		r, err := p.parseResult()
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			// "none"
+			continue
+		}
+		ar.Results = append(ar.Results, *r)
+	}
+
+	return ar, nil
+}
+
+// String formats the authentication results as an Authentication-Results
+// header field value.
+func (ar *AuthenticationResults) String() string {
+	var b strings.Builder
+	b.WriteString(formatAuthResultValue(ar.Identifier))
+	if ar.Version != "" {
+		b.WriteString(" " + ar.Version)
+	}
+	writeComments(&b, ar.Comments)
+	if len(ar.Results) == 0 {
+		b.WriteString("; none")
+		return b.String()
+	}
+	for _, r := range ar.Results {
+		b.WriteString("; " + r.Method)
+		if r.MethodVersion != "" {
+			b.WriteString("/" + r.MethodVersion)
+		}
+		writeComments(&b, r.MethodComments)
+		if len(r.MethodComments) > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString("=" + r.Value)
+		writeComments(&b, r.Comments)
+		if r.Reason != "" {
+			b.WriteString(" reason=" + formatAuthResultValue(r.Reason))
+		}
+		for _, prop := range r.Properties {
+			b.WriteString(" " + prop.Type + "." + prop.Name + "=" + formatPropertyValue(prop.Value))
+			writeComments(&b, prop.Comments)
+		}
+	}
+	return b.String()
+}
+
+// writeComments writes comments to b, each one preceded by a space.
+func writeComments(b *strings.Builder, comments []string) {
+	for _, comment := range comments {
+		b.WriteString(" (" + escapeComment(comment) + ")")
+	}
+}
+
+type authResultsParser struct {
+	headerParser
+}
+
+// consumeCFWS skips CFWS. The comments it contains are appended to comments,
+// unless it's nil.
+func (p *authResultsParser) consumeCFWS(comments *[]string) error {
+	for {
+		p.s = strings.TrimLeft(p.s, " \t\r\n")
+		if !p.consume('(') {
+			return nil
+		}
+		comment, ok := p.consumeComment()
+		if !ok {
+			return errors.New("mail: malformed parenthetical comment")
+		}
+		if comments != nil {
+			*comments = append(*comments, strings.TrimSpace(comment))
+		}
+	}
+}
+
+func (p *authResultsParser) consumeWhile(f func(c byte) bool) string {
+	i := 0
+	for i < len(p.s) && f(p.s[i]) {
+		i++
+	}
+	var s string
+	s, p.s = p.s[:i], p.s[i:]
+	return s
+}
+
+// parseKeyword parses a Keyword, as defined in RFC 5321.
+func (p *authResultsParser) parseKeyword() (string, error) {
+	kw := p.consumeWhile(isKeywordChar)
+	if kw == "" {
+		return "", fmt.Errorf("mail: expected keyword, got %q", p.s)
+	}
+	return kw, nil
+}
+
+// parseValue parses a value, as defined in RFC 2045: either a token or a
+// quoted-string.
+func (p *authResultsParser) parseValue() (string, error) {
+	if !p.empty() && p.peek() == '"' {
+		return p.parseQuotedString()
+	}
+	tok := p.consumeWhile(rfc2045.IsTokenChar)
+	if tok == "" {
+		return "", fmt.Errorf("mail: expected value, got %q", p.s)
+	}
+	return tok, nil
+}
+
+func (p *authResultsParser) parseQuotedString() (string, error) {
+	if !p.consume('"') {
+		return "", errors.New("mail: missing '\"' in quoted-string")
+	}
+	var b strings.Builder
+	for {
+		if p.empty() {
+			return "", errors.New("mail: unterminated quoted-string")
+		}
+		c := p.peek()
+		p.s = p.s[1:]
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.empty() {
+				return "", errors.New("mail: unterminated quoted-string")
+			}
+			b.WriteByte(p.peek())
+			p.s = p.s[1:]
+		case '\r', '\n':
+			// Unfold
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+// parsePropertyValue parses a pvalue, as defined in RFC 8601 section 2.2. It
+// can be a value, an e-mail address or a domain name.
+func (p *authResultsParser) parsePropertyValue() (string, error) {
+	if !p.empty() && p.peek() == '"' {
+		return p.parseQuotedString()
+	}
+	v := p.consumeWhile(func(c byte) bool {
+		return c > ' ' && c != 0x7f && c != ';' && c != '(' && c != ')' && c != '"'
+	})
+	if v == "" {
+		return "", fmt.Errorf("mail: expected property value, got %q", p.s)
+	}
+	return v, nil
+}
+
+// parseResult parses a resinfo, without the leading semicolon. It returns
+// nil for "none".
+func (p *authResultsParser) parseResult() (*AuthResult, error) {
+	method, err := p.parseKeyword()
+	if err != nil {
+		return nil, err
+	}
+	r := &AuthResult{Method: method}
+
+	if err := p.consumeCFWS(&r.MethodComments); err != nil {
+		return nil, err
+	}
+	if p.empty() || p.peek() == ';' {
+		if strings.EqualFold(method, "none") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("mail: missing result for method %q", method)
+	}
+
+	if p.consume('/') {
+		if err := p.consumeCFWS(&r.MethodComments); err != nil {
+			return nil, err
+		}
+		if r.MethodVersion, err = p.parseKeyword(); err != nil {
+			return nil, err
+		}
+		if err := p.consumeCFWS(&r.MethodComments); err != nil {
+			return nil, err
+		}
+	}
+
+	if !p.consume('=') {
+		return nil, fmt.Errorf("mail: missing '=' after method %q", method)
+	}
+	if err := p.consumeCFWS(&r.Comments); err != nil {
+		return nil, err
+	}
+	if r.Value, err = p.parseKeyword(); err != nil {
+		return nil, err
+	}
+
+	// Comments following a property belong to it, the other ones belong to
+	// the result
+	comments := &r.Comments
+	for {
+		if err := p.consumeCFWS(comments); err != nil {
+			return nil, err
+		}
+		if p.empty() || p.peek() == ';' {
+			return r, nil
+		}
+
+		k, err := p.parseKeyword()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.EqualFold(k, "reason") {
+			if err := p.consumeCFWS(&r.Comments); err != nil {
+				return nil, err
+			}
+			if p.consume('=') {
+				if err := p.consumeCFWS(&r.Comments); err != nil {
+					return nil, err
+				}
+				if r.Reason, err = p.parseValue(); err != nil {
+					return nil, err
+				}
+				comments = &r.Comments
+				continue
+			}
+		}
+
+		var prop AuthResultProperty
+		prop.Type = k
+		if err := p.consumeCFWS(&prop.Comments); err != nil {
+			return nil, err
+		}
+		if !p.consume('.') {
+			return nil, fmt.Errorf("mail: missing '.' in property type %q", k)
+		}
+		if err := p.consumeCFWS(&prop.Comments); err != nil {
+			return nil, err
+		}
+		if prop.Name, err = p.parseKeyword(); err != nil {
+			return nil, err
+		}
+		if err := p.consumeCFWS(&prop.Comments); err != nil {
+			return nil, err
+		}
+		if !p.consume('=') {
+			return nil, fmt.Errorf("mail: missing '=' after property %q", k+"."+prop.Name)
+		}
+		if err := p.consumeCFWS(&prop.Comments); err != nil {
+			return nil, err
+		}
+		if prop.Value, err = p.parsePropertyValue(); err != nil {
+			return nil, err
+		}
+		r.Properties = append(r.Properties, prop)
+		comments = &r.Properties[len(r.Properties)-1].Comments
+	}
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isKeywordChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '-' || c == '_'
+}
+
+func formatAuthResultValue(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return r >= 0x80 || !rfc2045.IsTokenChar(byte(r))
+	}) < 0 {
+		return s
+	}
+	return formatQuotedString(s)
+}
+
+func formatPropertyValue(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == 0x7f || strings.ContainsRune(`;()"\`, r)
+	}) < 0 {
+		return s
+	}
+	return formatQuotedString(s)
+}
+
+func formatQuotedString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func escapeComment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', ')', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
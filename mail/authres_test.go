@@ -0,0 +1,201 @@
+package mail_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+)
+
+var authResultsTests = []struct {
+	value     string
+	formatted string
+	results   *mail.AuthenticationResults
+}{
+	{
+		value:     "example.org 1; none",
+		formatted: "example.org 1; none",
+		results: &mail.AuthenticationResults{
+			Identifier: "example.org",
+			Version:    "1",
+		},
+	},
+	{
+		value:     "example.com; spf=pass smtp.mailfrom=example.net",
+		formatted: "example.com; spf=pass smtp.mailfrom=example.net",
+		results: &mail.AuthenticationResults{
+			Identifier: "example.com",
+			Results: []mail.AuthResult{{
+				Method:     "spf",
+				Value:      "pass",
+				Properties: []mail.AuthResultProperty{{Type: "smtp", Name: "mailfrom", Value: "example.net"}},
+			}},
+		},
+	},
+	{
+		value: "mx.example.org;\r\n" +
+			"  dkim=pass (good signature) header.d=example.com header.i=@mail.example.com;\r\n" +
+			"  dkim = fail reason=\"bad signature\" header.d = example.net;\r\n" +
+			"  dmarc=pass (p=reject dis=none) header.from=example.com;\r\n" +
+			"  x-custom/2=neutral policy.x-test=\"a b\"",
+		formatted: "mx.example.org; dkim=pass (good signature) header.d=example.com header.i=@mail.example.com; " +
+			"dkim=fail reason=\"bad signature\" header.d=example.net; " +
+			"dmarc=pass (p=reject dis=none) header.from=example.com; " +
+			"x-custom/2=neutral policy.x-test=\"a b\"",
+		results: &mail.AuthenticationResults{
+			Identifier: "mx.example.org",
+			Results: []mail.AuthResult{
+				{
+					Method:   "dkim",
+					Value:    "pass",
+					Comments: []string{"good signature"},
+					Properties: []mail.AuthResultProperty{
+						{Type: "header", Name: "d", Value: "example.com"},
+						{Type: "header", Name: "i", Value: "@mail.example.com"},
+					},
+				},
+				{
+					Method:     "dkim",
+					Value:      "fail",
+					Reason:     "bad signature",
+					Properties: []mail.AuthResultProperty{{Type: "header", Name: "d", Value: "example.net"}},
+				},
+				{
+					Method:     "dmarc",
+					Value:      "pass",
+					Comments:   []string{"p=reject dis=none"},
+					Properties: []mail.AuthResultProperty{{Type: "header", Name: "from", Value: "example.com"}},
+				},
+				{
+					Method:        "x-custom",
+					MethodVersion: "2",
+					Value:         "neutral",
+					Properties:    []mail.AuthResultProperty{{Type: "policy", Name: "x-test", Value: "a b"}},
+				},
+			},
+		},
+	},
+}
+
+var authResultsCommentsTest = struct {
+	value     string
+	formatted string
+	results   *mail.AuthenticationResults
+}{
+	value: "(before) mx.example.org (host) 1 (version);\r\n" +
+		" spf (method) = (result) pass (checked) smtp.mailfrom=example.net (sender);\r\n" +
+		" dkim=fail reason=\"bad\" (why) header (type) . d = example.com (domain) (again)",
+	formatted: "mx.example.org 1 (before) (host) (version); " +
+		"spf (method) =pass (result) (checked) smtp.mailfrom=example.net (sender); " +
+		"dkim=fail (why) reason=bad header.d=example.com (type) (domain) (again)",
+	results: &mail.AuthenticationResults{
+		Identifier: "mx.example.org",
+		Version:    "1",
+		Comments:   []string{"before", "host", "version"},
+		Results: []mail.AuthResult{
+			{
+				Method:         "spf",
+				MethodComments: []string{"method"},
+				Value:          "pass",
+				Comments:       []string{"result", "checked"},
+				Properties: []mail.AuthResultProperty{{
+					Type:     "smtp",
+					Name:     "mailfrom",
+					Value:    "example.net",
+					Comments: []string{"sender"},
+				}},
+			},
+			{
+				Method:   "dkim",
+				Value:    "fail",
+				Reason:   "bad",
+				Comments: []string{"why"},
+				Properties: []mail.AuthResultProperty{{
+					Type:     "header",
+					Name:     "d",
+					Value:    "example.com",
+					Comments: []string{"type", "domain", "again"},
+				}},
+			},
+		},
+	},
+}
+
+func TestParseAuthenticationResults_comments(t *testing.T) {
+	test := authResultsCommentsTest
+	ar, err := mail.ParseAuthenticationResults(test.value)
+	if err != nil {
+		t.Fatalf("ParseAuthenticationResults(%q) = %v", test.value, err)
+	}
+	if !reflect.DeepEqual(ar, test.results) {
+		t.Errorf("ParseAuthenticationResults(%q) = \n%#v\n but want \n%#v", test.value, ar, test.results)
+	}
+	if s := ar.String(); s != test.formatted {
+		t.Errorf("AuthenticationResults.String() = %q, want %q", s, test.formatted)
+	}
+	if again, err := mail.ParseAuthenticationResults(test.formatted); err != nil {
+		t.Errorf("ParseAuthenticationResults(%q) = %v", test.formatted, err)
+	} else if again.String() != test.formatted {
+		t.Errorf("Expected formatted results to be parsed back unchanged, got %q", again.String())
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	for _, test := range authResultsTests {
+		ar, err := mail.ParseAuthenticationResults(test.value)
+		if err != nil {
+			t.Errorf("ParseAuthenticationResults(%q) = %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(ar, test.results) {
+			t.Errorf("ParseAuthenticationResults(%q) = \n%#v\n but want \n%#v", test.value, ar, test.results)
+		}
+	}
+}
+
+func TestParseAuthenticationResults_invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"example.org; spf",
+		"example.org; spf=pass smtp",
+		"example.org; spf=pass smtp.mailfrom=",
+		"example.org; spf=pass (unterminated",
+		"\"example.org; none",
+	}
+	for _, s := range tests {
+		if _, err := mail.ParseAuthenticationResults(s); err == nil {
+			t.Errorf("ParseAuthenticationResults(%q) = nil, want an error", s)
+		}
+	}
+}
+
+func TestAuthenticationResults_String(t *testing.T) {
+	for _, test := range authResultsTests {
+		if s := test.results.String(); s != test.formatted {
+			t.Errorf("AuthenticationResults.String() = %q, want %q", s, test.formatted)
+		}
+	}
+}
+
+func TestHeader_AuthenticationResults(t *testing.T) {
+	l := []*mail.AuthenticationResults{
+		authResultsTests[1].results,
+		authResultsTests[2].results,
+	}
+
+	var h mail.Header
+	h.Set("Authentication-Results", "old.example.org; none")
+	h.SetAuthenticationResults(l)
+
+	if got := h.Values("Authentication-Results"); len(got) != 2 || got[0] != authResultsTests[1].formatted {
+		t.Errorf("Expected two Authentication-Results fields, got %q", got)
+	}
+
+	got, err := h.AuthenticationResults()
+	if err != nil {
+		t.Fatalf("Expected no error while parsing Authentication-Results, got: %v", err)
+	}
+	if !reflect.DeepEqual(got, l) {
+		t.Errorf("Expected Authentication-Results to be %v, but got %v", l, got)
+	}
+}
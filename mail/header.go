@@ -239,7 +239,7 @@ func (h *Header) AddressList(key string) ([]*Address, error) {
 //
 // This can be used on From, Sender, Reply-To, To, Cc and Bcc header fields.
 func (h *Header) SetAddressList(key string, addrs []*Address) {
-	h.Set(key, formatAddressList(addrs))
+	h.Set(key, formatAddressList(key, addrs))
 }
 
 // Date parses the Date header field.
@@ -261,6 +261,12 @@ func (h *Header) Subject() (string, error) {
 	return h.Text("Subject")
 }
 
+// SubjectWithOptions is like Subject, but with options. See
+// message.Header.TextWithOptions.
+func (h *Header) SubjectWithOptions(opts *message.TextOptions) (string, error) {
+	return h.TextWithOptions("Subject", opts)
+}
+
 // SetSubject formats the Subject header field.
 func (h *Header) SetSubject(s string) {
 	h.SetText("Subject", s)
@@ -347,6 +353,32 @@ func (h *Header) SetMsgIDList(key string, l []string) {
 	h.Set(key, v)
 }
 
+// AuthenticationResults parses the Authentication-Results header fields, in
+// the order they appear in the header. If the header has no such field, it
+// returns nil.
+func (h *Header) AuthenticationResults() ([]*AuthenticationResults, error) {
+	var l []*AuthenticationResults
+	fields := h.FieldsByKey("Authentication-Results")
+	for fields.Next() {
+		ar, err := ParseAuthenticationResults(fields.Value())
+		if err != nil {
+			return l, err
+		}
+		l = append(l, ar)
+	}
+	return l, nil
+}
+
+// SetAuthenticationResults formats the Authentication-Results header fields.
+// Existing fields are replaced, and the new fields appear in the same order
+// as l.
+func (h *Header) SetAuthenticationResults(l []*AuthenticationResults) {
+	h.Del("Authentication-Results")
+	for i := len(l) - 1; i >= 0; i-- {
+		h.Add("Authentication-Results", l[i].String())
+	}
+}
+
 // Copy creates a stand-aline copy of the header.
 func (h *Header) Copy() Header {
 	return Header{h.Header.Copy()}
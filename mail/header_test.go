@@ -3,9 +3,11 @@ package mail_test
 import (
 	netmail "net/mail"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
 )
 
@@ -176,3 +178,35 @@ func TestHeader_CanUseNetMailAddress(t *testing.T) {
 		t.Errorf("Expected header address list to be %v, but got %v", netfrom, got)
 	}
 }
+
+func TestHeader_SetAddressList_encoded(t *testing.T) {
+	from := []*mail.Address{
+		{Name: "宮水 三葉", Address: "mitsuha.miyamizu@example.org"},
+		{Name: "Tachibana, Takí", Address: "taki.tachibana@example.org"},
+	}
+
+	var h mail.Header
+	h.SetAddressList("From", from)
+
+	v := h.Get("From")
+	if !strings.HasPrefix(v, "=?utf-8?b?") {
+		t.Errorf("Expected a B-encoded display name, got %q", v)
+	}
+	if got, err := h.AddressList("From"); err != nil {
+		t.Error("Expected no error while parsing header address list, got:", err)
+	} else if !reflect.DeepEqual(got, from) {
+		t.Errorf("Expected header address list to be %v, but got %v", from, got)
+	}
+}
+
+func TestHeader_SubjectWithOptions(t *testing.T) {
+	var h mail.Header
+	h.Set("Subject", "=?utf-8?q?Caf=C3?= =?utf-8?q?=A9?= =?utf-8?b?bad base64?=")
+
+	want := "Café =?utf-8?b?bad base64?="
+	if got, err := h.SubjectWithOptions(&message.TextOptions{Lenient: true}); err != nil {
+		t.Error("Expected no error while parsing header subject, got:", err)
+	} else if got != want {
+		t.Errorf("Expected header subject to be %q, but got %q", want, got)
+	}
+}
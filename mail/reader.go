@@ -62,7 +62,16 @@ func NewReader(e *message.Entity) *Reader {
 // returns an error that verifies message.IsUnknownCharset, but also returns a
 // Reader that can be used.
 func CreateReader(r io.Reader) (*Reader, error) {
-	e, err := message.Read(r)
+	return CreateReaderWithOptions(r, nil)
+}
+
+// CreateReaderWithOptions is like CreateReader, but with options. If opts is
+// nil, the defaults are used.
+//
+// If the message exceeds one of the limits set in opts, NextPart returns an
+// error of type message.LimitExceededError.
+func CreateReaderWithOptions(r io.Reader, opts *message.ReadOptions) (*Reader, error) {
+	e, err := message.ReadWithOptions(r, opts)
 	if err != nil && !message.IsUnknownCharset(err) {
 		return nil, err
 	}
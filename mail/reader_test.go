@@ -1,12 +1,15 @@
 package mail_test
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"strings"
 	"testing"
 
+	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
 )
 
@@ -202,3 +205,69 @@ func TestReader_nested(t *testing.T) {
 		i++
 	}
 }
+
+func testNestedMailText(depth int) string {
+	var sb strings.Builder
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&sb, "Content-Type: multipart/mixed; boundary=b%d\r\n\r\n", i)
+		fmt.Fprintf(&sb, "--b%d\r\nContent-Type: text/plain\r\n\r\nText %d\r\n", i, i)
+		fmt.Fprintf(&sb, "--b%d\r\n", i)
+	}
+	sb.WriteString("Content-Type: text/plain\r\n\r\nInnermost\r\n")
+	for i := depth - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "--b%d--\r\n", i)
+	}
+	return sb.String()
+}
+
+func TestReader_limits(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  message.ReadOptions
+		limit string
+	}{
+		{"depth", message.ReadOptions{MaxMultipartDepth: 10}, "multipart depth"},
+		{"parts", message.ReadOptions{MaxParts: 100}, "multipart parts"},
+	}
+	for _, test := range tests {
+		mr, err := mail.CreateReaderWithOptions(strings.NewReader(testNestedMailText(10000)), &test.opts)
+		if err != nil {
+			t.Fatalf("mail.CreateReaderWithOptions() = %v", err)
+		}
+
+		for {
+			_, err = mr.NextPart()
+			if err != nil {
+				break
+			}
+		}
+
+		var limitErr message.LimitExceededError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%v: expected a LimitExceededError, got: %v", test.name, err)
+		} else if limitErr.Limit != test.limit {
+			t.Errorf("%v: expected limit %q to be exceeded, got %q", test.name, test.limit, limitErr.Limit)
+		}
+	}
+
+	mr, err := mail.CreateReaderWithOptions(strings.NewReader(testNestedMailText(10)), &message.ReadOptions{
+		MaxMultipartDepth: 10,
+		MaxParts:          20,
+	})
+	if err != nil {
+		t.Fatalf("mail.CreateReaderWithOptions() = %v", err)
+	}
+	n := 0
+	for {
+		_, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Expected no error when the message is within limits, got: %v", err)
+		}
+		n++
+	}
+	if n != 11 {
+		t.Errorf("Expected 11 text parts, got %v", n)
+	}
+}
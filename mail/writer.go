@@ -41,10 +41,16 @@ type Writer struct {
 
 // CreateWriter writes a mail header to w and creates a new Writer.
 func CreateWriter(w io.Writer, header Header) (*Writer, error) {
+	return CreateWriterWithOptions(w, header, nil)
+}
+
+// CreateWriterWithOptions is like CreateWriter, but with options. If opts is
+// nil, the defaults are used.
+func CreateWriterWithOptions(w io.Writer, header Header, opts *message.WriterOptions) (*Writer, error) {
 	header = header.Copy() // don't modify the caller's view
 	header.Set("Content-Type", "multipart/mixed")
 
-	mw, err := message.CreateWriter(w, header.Header)
+	mw, err := message.CreateWriterWithOptions(w, header.Header, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -108,6 +114,39 @@ func (w *Writer) CreateAttachment(h AttachmentHeader) (io.WriteCloser, error) {
 	return w.mw.CreatePart(h.Header)
 }
 
+// CreateMessageAttachment creates a new attachment containing an embedded
+// message, with the provided header. The embedded message, including its
+// header, should be written to the returned io.WriteCloser.
+//
+// If the header doesn't specify a Content-Type, message/rfc822 is used. If it
+// doesn't specify a Content-Disposition, attachment is used. No
+// Content-Transfer-Encoding is added: as per RFC 2046 section 5.2.1, embedded
+// messages can't be encoded.
+func (w *Writer) CreateMessageAttachment(h AttachmentHeader) (io.WriteCloser, error) {
+	h = AttachmentHeader{h.Header.Copy()} // don't modify the caller's view
+	if !h.Has("Content-Type") {
+		h.Set("Content-Type", "message/rfc822")
+	}
+	if !h.Has("Content-Disposition") {
+		h.Set("Content-Disposition", "attachment")
+	}
+	return w.mw.CreatePart(h.Header)
+}
+
+// AttachMessage attaches an existing message, e.g. to forward it as an
+// attachment. See CreateMessageAttachment. The message's body is consumed.
+func (w *Writer) AttachMessage(h AttachmentHeader, e *message.Entity) error {
+	aw, err := w.CreateMessageAttachment(h)
+	if err != nil {
+		return err
+	}
+	if err := e.WriteTo(aw); err != nil {
+		aw.Close()
+		return err
+	}
+	return aw.Close()
+}
+
 // Close finishes the Writer.
 func (w *Writer) Close() error {
 	return w.mw.Close()
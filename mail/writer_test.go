@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"io"
 	"log"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
 )
 
@@ -137,3 +139,93 @@ func TestWriter_singleInline(t *testing.T) {
 
 	testReader(t, &b)
 }
+
+func TestWriter_AttachMessage(t *testing.T) {
+	forwarded, err := message.Read(strings.NewReader("Subject: Forwarded\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"I'm Taki.\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	var h mail.Header
+	h.SetSubject("Your Name")
+	mw, err := mail.CreateWriter(&b, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.AttachMessage(mail.AttachmentHeader{}, forwarded); err != nil {
+		t.Fatalf("AttachMessage() = %v", err)
+	}
+	mw.Close()
+
+	mr, err := mail.CreateReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	ah, ok := p.Header.(*mail.AttachmentHeader)
+	if !ok {
+		t.Fatalf("Expected an attachment, got %T", p.Header)
+	}
+	if mediaType, _, _ := ah.ContentType(); mediaType != "message/rfc822" {
+		t.Errorf("Expected message/rfc822, got %v", mediaType)
+	}
+	if ah.Has("Content-Transfer-Encoding") {
+		t.Errorf("Expected no Content-Transfer-Encoding, got %q", ah.Get("Content-Transfer-Encoding"))
+	}
+
+	embedded, err := message.Read(p.Body)
+	if err != nil {
+		t.Fatalf("failed to read embedded message: %v", err)
+	}
+	if subject := embedded.Header.Get("Subject"); subject != "Forwarded" {
+		t.Errorf("Expected subject %q, got %q", "Forwarded", subject)
+	}
+}
+
+func TestWriter_CreateMessageAttachment_disposition(t *testing.T) {
+	var b bytes.Buffer
+	mw, err := mail.CreateWriter(&b, mail.Header{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ah mail.AttachmentHeader
+	ah.SetContentDisposition("inline", map[string]string{"filename": "forwarded.eml"})
+	w, err := mw.CreateMessageAttachment(ah)
+	if err != nil {
+		t.Fatalf("CreateMessageAttachment() = %v", err)
+	}
+	io.WriteString(w, "Subject: Forwarded\r\n\r\nI'm Taki.\r\n")
+	w.Close()
+	mw.Close()
+
+	mr, err := mail.CreateReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	var h message.Header
+	switch ph := p.Header.(type) {
+	case *mail.InlineHeader:
+		h = ph.Header
+	case *mail.AttachmentHeader:
+		h = ph.Header
+	}
+	disp, params, err := h.ContentDisposition()
+	if err != nil {
+		t.Fatalf("ContentDisposition() = %v", err)
+	}
+	if disp != "inline" || params["filename"] != "forwarded.eml" {
+		t.Errorf("Expected the inline disposition and its filename to be kept, got %q %v", disp, params)
+	}
+}
@@ -0,0 +1,393 @@
+// Package maildir implements Maildir mailbox storage.
+//
+// The Maildir format is described in
+// https://cr.yp.to/proto/maildir.html. A Maildir is a directory with three
+// subdirectories: tmp, new and cur. Messages are written to tmp, then
+// atomically moved to new. Once a client has seen a message, it is moved to
+// cur and its flags are stored in the filename.
+package maildir
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/mail"
+)
+
+const (
+	dirTmp = "tmp"
+	dirNew = "new"
+	dirCur = "cur"
+)
+
+// infoSeparator separates the unique name from the info in a filename.
+const infoSeparator = ':'
+
+// Flag is a message flag, stored in the info part of the filename.
+type Flag rune
+
+const (
+	FlagPassed  Flag = 'P' // the message has been resent or forwarded
+	FlagReplied Flag = 'R' // the message has been replied to
+	FlagSeen    Flag = 'S' // the message has been viewed
+	FlagTrashed Flag = 'T' // the message has been marked for deletion
+	FlagDraft   Flag = 'D' // the message is a draft
+	FlagFlagged Flag = 'F' // the message has been flagged
+)
+
+// KeyError occurs when a key matches zero or more than one message.
+type KeyError struct {
+	Key string
+	N   int // number of matching messages
+}
+
+func (err KeyError) Error() string {
+	if err.N == 0 {
+		return fmt.Sprintf("maildir: message %q not found", err.Key)
+	}
+	return fmt.Sprintf("maildir: key %q matches %v messages", err.Key, err.N)
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a message doesn't exist.
+func IsNotExist(err error) bool {
+	keyErr, ok := err.(KeyError)
+	return ok && keyErr.N == 0
+}
+
+// parseFilename splits a filename into its key and its flags.
+func parseFilename(name string) (key string, flags []Flag) {
+	i := strings.IndexByte(name, infoSeparator)
+	if i < 0 {
+		return name, nil
+	}
+	key, info := name[:i], name[i+1:]
+	if !strings.HasPrefix(info, "2,") {
+		// Experimental or unknown semantics
+		return key, nil
+	}
+	for _, r := range info[2:] {
+		flags = append(flags, Flag(r))
+	}
+	return key, flags
+}
+
+// formatFilename builds a filename from a key and a list of flags. Flags are
+// sorted and deduplicated, as required by the Maildir specification.
+func formatFilename(key string, flags []Flag) string {
+	l := make([]Flag, 0, len(flags))
+	seen := make(map[Flag]bool, len(flags))
+	for _, f := range flags {
+		if !seen[f] {
+			seen[f] = true
+			l = append(l, f)
+		}
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i] < l[j]
+	})
+
+	var sb strings.Builder
+	sb.WriteString(key)
+	sb.WriteRune(infoSeparator)
+	sb.WriteString("2,")
+	for _, f := range l {
+		sb.WriteRune(rune(f))
+	}
+	return sb.String()
+}
+
+var deliveryCounter uint32
+
+// newKey generates a new unique key.
+func newKey() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	host = strings.Replace(host, "/", `\057`, -1)
+	host = strings.Replace(host, string(infoSeparator), `\072`, -1)
+
+	var b [8]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	n := atomic.AddUint32(&deliveryCounter, 1)
+	return fmt.Sprintf("%d.M%dP%dQ%dR%s.%s", now.Unix(), now.Nanosecond()/1000,
+		os.Getpid(), n, hex.EncodeToString(b[:]), host), nil
+}
+
+// Dir is a Maildir, identified by its path.
+type Dir string
+
+// Init creates the Maildir directory structure, if it doesn't exist yet.
+func (d Dir) Init() error {
+	for _, name := range []string{dirTmp, dirNew, dirCur} {
+		if err := os.MkdirAll(filepath.Join(string(d), name), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkDir calls fn for each message file in the subdirectory sub.
+func (d Dir) walkDir(sub string, fn func(name string) error) error {
+	infos, err := ioutil.ReadDir(filepath.Join(string(d), sub))
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if err := fn(info.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk calls fn for each message in the Maildir, with its key and its flags.
+// Messages in new are visited before messages in cur. If fn returns an error,
+// Walk stops and returns it.
+func (d Dir) Walk(fn func(key string, flags []Flag) error) error {
+	for _, sub := range []string{dirNew, dirCur} {
+		err := d.walkDir(sub, func(name string) error {
+			key, flags := parseFilename(name)
+			return fn(key, flags)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys of all messages in the Maildir.
+func (d Dir) Keys() ([]string, error) {
+	var keys []string
+	err := d.Walk(func(key string, flags []Flag) error {
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+// Unseen moves messages from new to cur and returns their keys. After this
+// call, the messages are no longer considered new.
+func (d Dir) Unseen() ([]string, error) {
+	var keys []string
+	err := d.walkDir(dirNew, func(name string) error {
+		key, flags := parseFilename(name)
+		src := filepath.Join(string(d), dirNew, name)
+		dst := filepath.Join(string(d), dirCur, formatFilename(key, flags))
+		if err := os.Rename(src, dst); err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		return nil
+	})
+	return keys, err
+}
+
+// Filename returns the path to the file containing the message with the
+// specified key. The path changes when the message is moved to cur or when
+// its flags are updated.
+func (d Dir) Filename(key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, "/"+string(infoSeparator)) {
+		return "", KeyError{Key: key, N: 0}
+	}
+
+	// Messages in new usually have no info, try this first
+	filename := filepath.Join(string(d), dirNew, key)
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	var matches []string
+	for _, sub := range []string{dirNew, dirCur} {
+		err := d.walkDir(sub, func(name string) error {
+			if !strings.HasPrefix(name, key) {
+				return nil
+			}
+			if k, _ := parseFilename(name); k == key {
+				matches = append(matches, filepath.Join(string(d), sub, name))
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(matches) != 1 {
+		return "", KeyError{Key: key, N: len(matches)}
+	}
+	return matches[0], nil
+}
+
+// Flags returns the flags of the message with the specified key.
+func (d Dir) Flags(key string) ([]Flag, error) {
+	filename, err := d.Filename(key)
+	if err != nil {
+		return nil, err
+	}
+	_, flags := parseFilename(filepath.Base(filename))
+	return flags, nil
+}
+
+// SetFlags replaces the flags of the message with the specified key. If the
+// message is in new, it is moved to cur.
+func (d Dir) SetFlags(key string, flags []Flag) error {
+	src, err := d.Filename(key)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(string(d), dirCur, formatFilename(key, flags))
+	if src == dst {
+		return nil
+	}
+	return os.Rename(src, dst)
+}
+
+// Remove deletes the message with the specified key.
+func (d Dir) Remove(key string) error {
+	filename, err := d.Filename(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+// Open opens the raw contents of the message with the specified key.
+func (d Dir) Open(key string) (io.ReadCloser, error) {
+	filename, err := d.Filename(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(filename)
+}
+
+// A Reader reads a mail message stored in a Maildir. The message is read
+// lazily from its file, which is closed by Close.
+type Reader struct {
+	*mail.Reader
+
+	rc io.ReadCloser
+}
+
+// Close finishes the reader and closes the underlying file.
+func (r *Reader) Close() error {
+	err := r.Reader.Close()
+	if closeErr := r.rc.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// OpenReader opens the message with the specified key and creates a mail
+// reader for it. Only the header is read until parts are requested.
+//
+// If the message uses an unknown transfer encoding or charset, OpenReader
+// returns an error that verifies message.IsUnknownCharset, but also returns a
+// Reader that can be used.
+func (d Dir) OpenReader(key string) (*Reader, error) {
+	rc, err := d.Open(key)
+	if err != nil {
+		return nil, err
+	}
+
+	mr, err := mail.CreateReader(rc)
+	if err != nil && !message.IsUnknownCharset(err) {
+		rc.Close()
+		return nil, err
+	}
+	return &Reader{Reader: mr, rc: rc}, err
+}
+
+// Delivery writes a new message to a Maildir. The message is written to tmp
+// and moved to new when the Delivery is closed.
+type Delivery struct {
+	d   Dir
+	key string
+	f   *os.File
+}
+
+// NewDelivery starts delivering a new message to the Maildir.
+func (d Dir) NewDelivery() (*Delivery, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(string(d), dirTmp, key), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Delivery{d: d, key: key, f: f}, nil
+}
+
+// Key returns the key of the message being delivered.
+func (dv *Delivery) Key() string {
+	return dv.key
+}
+
+// Write implements io.Writer.
+func (dv *Delivery) Write(b []byte) (int, error) {
+	return dv.f.Write(b)
+}
+
+// Close flushes the message to disk and moves it to new. Once Close has
+// returned successfully, the message is delivered.
+func (dv *Delivery) Close() error {
+	if err := dv.f.Sync(); err != nil {
+		dv.Abort()
+		return err
+	}
+	if err := dv.f.Close(); err != nil {
+		os.Remove(dv.f.Name())
+		return err
+	}
+	if err := os.Rename(dv.f.Name(), filepath.Join(string(dv.d), dirNew, dv.key)); err != nil {
+		os.Remove(dv.f.Name())
+		return err
+	}
+	return nil
+}
+
+// Abort cancels the delivery and removes the temporary file.
+func (dv *Delivery) Abort() error {
+	err := dv.f.Close()
+	if removeErr := os.Remove(dv.f.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// Deliver writes e to the Maildir and returns the key of the new message.
+func (d Dir) Deliver(e *message.Entity) (string, error) {
+	dv, err := d.NewDelivery()
+	if err != nil {
+		return "", err
+	}
+	if err := e.WriteTo(dv); err != nil {
+		dv.Abort()
+		return "", err
+	}
+	if err := dv.Close(); err != nil {
+		return "", err
+	}
+	return dv.key, nil
+}
@@ -0,0 +1,201 @@
+package maildir
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+)
+
+const testMessage = "From: Mitsuha Miyamizu <mitsuha.miyamizu@example.org>\r\n" +
+	"Subject: Your Name.\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Who are you?\r\n"
+
+func newTestDir(t *testing.T) (Dir, func()) {
+	path, err := ioutil.TempDir("", "go-message-maildir")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	d := Dir(path)
+	if err := d.Init(); err != nil {
+		os.RemoveAll(path)
+		t.Fatalf("Init() = %v", err)
+	}
+	return d, func() { os.RemoveAll(path) }
+}
+
+func deliverTestMessage(t *testing.T, d Dir) string {
+	e, err := message.Read(strings.NewReader(testMessage))
+	if err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	key, err := d.Deliver(e)
+	if err != nil {
+		t.Fatalf("Deliver() = %v", err)
+	}
+	return key
+}
+
+func TestDir_Deliver(t *testing.T) {
+	d, cleanup := newTestDir(t)
+	defer cleanup()
+
+	key := deliverTestMessage(t, d)
+
+	if _, err := os.Stat(filepath.Join(string(d), "new", key)); err != nil {
+		t.Errorf("Expected message in new: %v", err)
+	}
+	if tmp, _ := ioutil.ReadDir(filepath.Join(string(d), "tmp")); len(tmp) != 0 {
+		t.Errorf("Expected tmp to be empty, got %v files", len(tmp))
+	}
+
+	keys, err := d.Keys()
+	if err != nil {
+		t.Fatalf("Keys() = %v", err)
+	}
+	if len(keys) != 1 || keys[0] != key {
+		t.Errorf("Expected keys [%q], got %q", key, keys)
+	}
+
+	if other := deliverTestMessage(t, d); other == key {
+		t.Errorf("Expected unique keys, got %q twice", key)
+	}
+}
+
+func TestDir_Delivery_abort(t *testing.T) {
+	d, cleanup := newTestDir(t)
+	defer cleanup()
+
+	dv, err := d.NewDelivery()
+	if err != nil {
+		t.Fatalf("NewDelivery() = %v", err)
+	}
+	io.WriteString(dv, testMessage)
+	if err := dv.Abort(); err != nil {
+		t.Fatalf("Abort() = %v", err)
+	}
+
+	keys, err := d.Keys()
+	if err != nil {
+		t.Fatalf("Keys() = %v", err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("Expected no message, got %q", keys)
+	}
+	if tmp, _ := ioutil.ReadDir(filepath.Join(string(d), "tmp")); len(tmp) != 0 {
+		t.Errorf("Expected tmp to be empty, got %v files", len(tmp))
+	}
+}
+
+func TestDir_flags(t *testing.T) {
+	d, cleanup := newTestDir(t)
+	defer cleanup()
+
+	key := deliverTestMessage(t, d)
+
+	unseen, err := d.Unseen()
+	if err != nil {
+		t.Fatalf("Unseen() = %v", err)
+	}
+	if len(unseen) != 1 || unseen[0] != key {
+		t.Errorf("Expected unseen keys [%q], got %q", key, unseen)
+	}
+	if _, err := os.Stat(filepath.Join(string(d), "cur", key+":2,")); err != nil {
+		t.Errorf("Expected message in cur: %v", err)
+	}
+
+	if err := d.SetFlags(key, []Flag{FlagSeen, FlagFlagged, FlagReplied, FlagSeen}); err != nil {
+		t.Fatalf("SetFlags() = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(string(d), "cur", key+":2,FRS")); err != nil {
+		t.Errorf("Expected sorted flags in filename: %v", err)
+	}
+
+	flags, err := d.Flags(key)
+	if err != nil {
+		t.Fatalf("Flags() = %v", err)
+	}
+	if string(flagsToRunes(flags)) != "FRS" {
+		t.Errorf("Expected flags FRS, got %q", string(flagsToRunes(flags)))
+	}
+
+	var walked int
+	err = d.Walk(func(k string, flags []Flag) error {
+		walked++
+		if k != key || string(flagsToRunes(flags)) != "FRS" {
+			t.Errorf("Walk: unexpected message %q with flags %q", k, string(flagsToRunes(flags)))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() = %v", err)
+	}
+	if walked != 1 {
+		t.Errorf("Expected Walk to visit 1 message, got %v", walked)
+	}
+}
+
+func flagsToRunes(flags []Flag) []rune {
+	l := make([]rune, len(flags))
+	for i, f := range flags {
+		l[i] = rune(f)
+	}
+	return l
+}
+
+func TestDir_OpenReader(t *testing.T) {
+	d, cleanup := newTestDir(t)
+	defer cleanup()
+
+	key := deliverTestMessage(t, d)
+
+	r, err := d.OpenReader(key)
+	if err != nil {
+		t.Fatalf("OpenReader() = %v", err)
+	}
+	defer r.Close()
+
+	if subject, err := r.Header.Subject(); err != nil || subject != "Your Name." {
+		t.Errorf("Expected subject %q, got %q (%v)", "Your Name.", subject, err)
+	}
+
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	b, err := ioutil.ReadAll(p.Body)
+	if err != nil {
+		t.Fatalf("failed to read part body: %v", err)
+	}
+	if s := string(b); s != "Who are you?\r\n" {
+		t.Errorf("Expected body %q, got %q", "Who are you?\r\n", s)
+	}
+}
+
+func TestDir_notExist(t *testing.T) {
+	d, cleanup := newTestDir(t)
+	defer cleanup()
+
+	if _, err := d.Open("missing"); !IsNotExist(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+	for _, key := range []string{"", "..", "../cur", "missing:2,S"} {
+		if _, err := d.Filename(key); !IsNotExist(err) {
+			t.Errorf("Expected a not found error for key %q, got %v", key, err)
+		}
+	}
+
+	key := deliverTestMessage(t, d)
+	if err := d.Remove(key); err != nil {
+		t.Fatalf("Remove() = %v", err)
+	}
+	if _, err := d.Flags(key); !IsNotExist(err) {
+		t.Errorf("Expected a not found error after Remove, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Package mbox implements reading and writing mbox files.
+//
+// The mbox family of formats is described in RFC 4155. Messages are
+// separated by "From " lines. Variants differ in how lines starting with
+// "From " in message bodies are escaped, and in whether a Content-Length
+// header field delimits messages.
+package mbox
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Format is an mbox variant.
+type Format int
+
+const (
+	// FormatMboxo escapes body lines starting with "From " with a '>'.
+	// Unescaping is ambiguous: lines starting with ">From " are always
+	// unescaped.
+	FormatMboxo Format = iota
+	// FormatMboxrd escapes body lines matching /^>*From / with a '>'. The
+	// escaping is reversible.
+	FormatMboxrd
+	// FormatMboxcl escapes like FormatMboxo, and adds a Content-Length
+	// header field containing the length of the escaped body.
+	FormatMboxcl
+	// FormatMboxcl2 doesn't escape bodies, and adds a Content-Length header
+	// field. Messages are delimited by the Content-Length only.
+	FormatMboxcl2
+)
+
+func (f Format) String() string {
+	switch f {
+	case FormatMboxo:
+		return "mboxo"
+	case FormatMboxrd:
+		return "mboxrd"
+	case FormatMboxcl:
+		return "mboxcl"
+	case FormatMboxcl2:
+		return "mboxcl2"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+// hasContentLength reports whether the format uses a Content-Length header
+// field to delimit messages.
+func (f Format) hasContentLength() bool {
+	return f == FormatMboxcl || f == FormatMboxcl2
+}
+
+var fromPrefix = []byte("From ")
+
+// isFromLine reports whether a line is a message separator.
+func isFromLine(line []byte) bool {
+	return bytes.HasPrefix(line, fromPrefix)
+}
+
+// isEscapedFromLine reports whether a body line needs a '>' to be added when
+// escaping, or removed when unescaping.
+func (f Format) isEscapedFromLine(line []byte, escaped bool) bool {
+	switch f {
+	case FormatMboxo, FormatMboxcl:
+		if escaped {
+			return bytes.HasPrefix(line, []byte(">From "))
+		}
+		return isFromLine(line)
+	case FormatMboxrd:
+		rest := bytes.TrimLeft(line, ">")
+		if escaped && len(rest) == len(line) {
+			return false
+		}
+		return isFromLine(rest)
+	default:
+		return false
+	}
+}
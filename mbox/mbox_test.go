@@ -0,0 +1,182 @@
+package mbox
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testMboxrd = "From alice@example.org Thu Jan  1 00:00:00 2015\n" +
+	"Subject: First\n" +
+	"\n" +
+	"Hello\n" +
+	">From the start\n" +
+	">>From nested\n" +
+	"\n" +
+	"From bob@example.org Fri Jan  2 00:00:00 2015\n" +
+	"Subject: Second\n" +
+	"\n" +
+	"Bye\n" +
+	"\n"
+
+func readAll(t *testing.T, r *Reader) (fromLines, subjects, bodies []string) {
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		b, err := ioutil.ReadAll(e.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		fromLines = append(fromLines, r.FromLine())
+		subjects = append(subjects, e.Header.Get("Subject"))
+		bodies = append(bodies, string(b))
+	}
+}
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		format Format
+		bodies []string
+	}{
+		{FormatMboxrd, []string{"Hello\nFrom the start\n>From nested\n", "Bye\n"}},
+		{FormatMboxo, []string{"Hello\nFrom the start\n>>From nested\n", "Bye\n"}},
+	}
+	for _, test := range tests {
+		fromLines, subjects, bodies := readAll(t, NewReader(strings.NewReader(testMboxrd), test.format))
+
+		wantFromLines := []string{"alice@example.org Thu Jan  1 00:00:00 2015", "bob@example.org Fri Jan  2 00:00:00 2015"}
+		if strings.Join(fromLines, "|") != strings.Join(wantFromLines, "|") {
+			t.Errorf("%v: expected From lines %q, got %q", test.format, wantFromLines, fromLines)
+		}
+		if strings.Join(subjects, "|") != "First|Second" {
+			t.Errorf("%v: expected subjects First and Second, got %q", test.format, subjects)
+		}
+		if strings.Join(bodies, "|") != strings.Join(test.bodies, "|") {
+			t.Errorf("%v: expected bodies %q, got %q", test.format, test.bodies, bodies)
+		}
+	}
+}
+
+func TestReader_skipBody(t *testing.T) {
+	r := NewReader(strings.NewReader(testMboxrd), FormatMboxrd)
+	if _, err := r.Next(); err != nil {
+		t.Fatalf("Next() = %v", err)
+	}
+	e, err := r.Next()
+	if err != nil {
+		t.Fatalf("Next() = %v", err)
+	}
+	if subject := e.Header.Get("Subject"); subject != "Second" {
+		t.Errorf("Expected second message, got subject %q", subject)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestReader_contentLength(t *testing.T) {
+	s := "From alice@example.org Thu Jan  1 00:00:00 2015\n" +
+		"Subject: First\n" +
+		"Content-Length: 22\n" +
+		"\n" +
+		"Hello\n" +
+		"From the start\n" +
+		"\n" +
+		"\n" +
+		"From bob@example.org Fri Jan  2 00:00:00 2015\n" +
+		"Subject: Second\n" +
+		"\n" +
+		"Bye\n"
+
+	_, subjects, bodies := readAll(t, NewReader(strings.NewReader(s), FormatMboxcl2))
+	if strings.Join(subjects, "|") != "First|Second" {
+		t.Errorf("Expected subjects First and Second, got %q", subjects)
+	}
+	want := []string{"Hello\nFrom the start\n\n", "Bye\n"}
+	if strings.Join(bodies, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected bodies %q, got %q", want, bodies)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	body := "Hello\nFrom the start\n>From quoted\n"
+	date := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, format := range []Format{FormatMboxo, FormatMboxrd, FormatMboxcl, FormatMboxcl2} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, format)
+		for _, subject := range []string{"First", "Second"} {
+			mw, err := w.CreateMessage("alice@example.org", date)
+			if err != nil {
+				t.Fatalf("%v: CreateMessage() = %v", format, err)
+			}
+			io.WriteString(mw, "Subject: "+subject+"\n\n"+body)
+			if err := mw.Close(); err != nil {
+				t.Fatalf("%v: Close() = %v", format, err)
+			}
+		}
+
+		s := buf.String()
+		if !strings.HasPrefix(s, "From alice@example.org Thu Jan  1 00:00:00 2015\n") {
+			t.Errorf("%v: invalid From line in %q", format, s)
+		}
+		if strings.Contains(s, "\r\n") {
+			t.Errorf("%v: expected LF line endings in %q", format, s)
+		}
+		if format != FormatMboxcl2 && strings.Contains(s, "\nFrom the start") {
+			t.Errorf("%v: body \"From \" line not escaped in %q", format, s)
+		}
+
+		fromLines, subjects, bodies := readAll(t, NewReader(strings.NewReader(s), format))
+		if len(fromLines) != 2 || fromLines[1] != "alice@example.org Thu Jan  1 00:00:00 2015" {
+			t.Errorf("%v: unexpected From lines %q", format, fromLines)
+		}
+		if strings.Join(subjects, "|") != "First|Second" {
+			t.Errorf("%v: expected subjects First and Second, got %q", format, subjects)
+		}
+
+		want := body
+		if format == FormatMboxo || format == FormatMboxcl {
+			// Unescaping is lossy
+			want = "Hello\nFrom the start\nFrom quoted\n"
+		}
+		for _, b := range bodies {
+			if b != want {
+				t.Errorf("%v: expected body %q, got %q", format, want, b)
+			}
+		}
+	}
+}
+
+func TestWriter_crlf(t *testing.T) {
+	date := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, FormatMboxcl)
+	mw, err := w.CreateMessage("alice@example.org", date)
+	if err != nil {
+		t.Fatalf("CreateMessage() = %v", err)
+	}
+	io.WriteString(mw, "Subject: Hi\r\n\r\nHello\r\nFrom the start\r\n")
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	want := "From alice@example.org Thu Jan  1 00:00:00 2015\n" +
+		"Content-Length: 22\n" +
+		"Subject: Hi\n" +
+		"\n" +
+		"Hello\n" +
+		">From the start\n" +
+		"\n"
+	if s := buf.String(); s != want {
+		t.Errorf("Expected output to be \n%q\n but got \n%q", want, s)
+	}
+}
@@ -0,0 +1,217 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// Reader reads messages from an mbox file.
+type Reader struct {
+	br     *bufio.Reader
+	format Format
+
+	started  bool
+	next     []byte    // the next "From " line, nil if there are no more messages
+	needFrom bool      // the next "From " line hasn't been read yet
+	cur      io.Reader // the current message
+	fromLine string
+}
+
+// NewReader creates a new mbox reader with the specified format.
+func NewReader(r io.Reader, format Format) *Reader {
+	return &Reader{br: bufio.NewReader(r), format: format}
+}
+
+func isBlankLine(line []byte) bool {
+	return len(bytes.TrimRight(line, "\r\n")) == 0
+}
+
+func trimLineEnding(line []byte) []byte {
+	return bytes.TrimRight(line, "\r\n")
+}
+
+// readFromLine skips blank lines and reads the next "From " line.
+func (r *Reader) readFromLine() error {
+	r.needFrom = false
+	r.next = nil
+	for {
+		line, err := r.br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		if isFromLine(line) {
+			r.next = trimLineEnding(line)
+			return nil
+		}
+		if !isBlankLine(line) {
+			return errors.New("mbox: expected a \"From \" line")
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Next returns the next message in the mbox file. It returns io.EOF when
+// there are no more messages.
+//
+// The body of the previous message is discarded if it hasn't been read
+// completely.
+func (r *Reader) Next() (*message.Entity, error) {
+	if r.cur != nil {
+		if _, err := io.Copy(ioutil.Discard, r.cur); err != nil {
+			return nil, err
+		}
+		r.cur = nil
+	}
+	if !r.started {
+		r.started = true
+		r.needFrom = true
+	}
+	if r.needFrom {
+		if err := r.readFromLine(); err != nil {
+			return nil, err
+		}
+	}
+	if r.next == nil {
+		return nil, io.EOF
+	}
+	r.fromLine = string(bytes.TrimPrefix(r.next, fromPrefix))
+	r.next = nil
+
+	if r.format.hasContentLength() {
+		h, err := textproto.ReadHeader(r.br)
+		if err != nil {
+			return nil, err
+		}
+		var hbuf bytes.Buffer
+		if err := textproto.WriteHeader(&hbuf, h); err != nil {
+			return nil, err
+		}
+
+		if n, err := strconv.ParseInt(strings.TrimSpace(h.Get("Content-Length")), 10, 64); err == nil && n >= 0 {
+			r.cur = io.MultiReader(&hbuf, &lineReader{
+				src:    bufio.NewReader(io.LimitReader(r.br, n)),
+				format: r.format,
+			})
+			r.needFrom = true
+		} else {
+			// Missing or invalid Content-Length, fallback to "From " lines
+			r.cur = io.MultiReader(&hbuf, &lineReader{
+				r:          r,
+				src:        r.br,
+				format:     r.format,
+				stopAtFrom: true,
+			})
+		}
+	} else {
+		r.cur = &lineReader{
+			r:          r,
+			src:        r.br,
+			format:     r.format,
+			stopAtFrom: true,
+		}
+	}
+
+	e, err := message.Read(r.cur)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	return e, err
+}
+
+// FromLine returns the "From " line of the last message returned by Next,
+// without the "From " prefix. It usually contains the envelope sender and
+// the delivery date.
+func (r *Reader) FromLine() string {
+	return r.fromLine
+}
+
+// lineReader reads the lines of a message, unescaping "From " lines.
+type lineReader struct {
+	r          *Reader
+	src        *bufio.Reader
+	format     Format
+	stopAtFrom bool // stop at the next "From " line, and set r.next
+
+	buf     []byte // pending output
+	blank   []byte // pending blank line, dropped if it's the last one
+	midLine bool   // the last read stopped in the middle of a long line
+	eof     bool
+}
+
+func (lr *lineReader) Read(p []byte) (int, error) {
+	for len(lr.buf) == 0 {
+		if lr.eof {
+			return 0, io.EOF
+		}
+		if err := lr.readLine(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, lr.buf)
+	lr.buf = lr.buf[n:]
+	return n, nil
+}
+
+func (lr *lineReader) readLine() error {
+	line, err := lr.src.ReadSlice('\n')
+	full := err == bufio.ErrBufferFull
+	if err != nil && err != io.EOF && !full {
+		return err
+	}
+	if len(line) == 0 && err == io.EOF {
+		// The blank line before the end of the file is a separator
+		lr.eof = true
+		return nil
+	}
+
+	startOfLine := !lr.midLine
+	lr.midLine = full
+
+	if startOfLine && lr.stopAtFrom {
+		if isFromLine(line) {
+			next := append([]byte(nil), line...)
+			if full {
+				rest, err := lr.src.ReadBytes('\n')
+				if err != nil && err != io.EOF {
+					return err
+				}
+				next = append(next, rest...)
+			}
+			// The blank line before a "From " line is a separator
+			lr.r.next = trimLineEnding(next)
+			lr.eof = true
+			return nil
+		}
+		if !full && isBlankLine(line) {
+			lr.buf = append(lr.buf[:0], lr.blank...)
+			lr.blank = append(lr.blank[:0], line...)
+			if err == io.EOF {
+				lr.eof = true
+			}
+			return nil
+		}
+	}
+
+	if startOfLine && lr.format.isEscapedFromLine(line, true) {
+		line = line[1:]
+	}
+	lr.buf = append(append(lr.buf[:0], lr.blank...), line...)
+	lr.blank = lr.blank[:0]
+	if err == io.EOF {
+		lr.eof = true
+	}
+	return nil
+}
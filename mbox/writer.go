@@ -0,0 +1,153 @@
+package mbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/textproto"
+)
+
+// fromDateLayout is the date format of "From " lines, as produced by the C
+// asctime function.
+const fromDateLayout = "Mon Jan _2 15:04:05 2006"
+
+// Writer writes messages to an mbox file.
+type Writer struct {
+	w      io.Writer
+	format Format
+	cur    *messageWriter
+}
+
+// NewWriter creates a new mbox writer with the specified format. To append
+// messages to an existing mbox file, w can be opened with os.O_APPEND.
+func NewWriter(w io.Writer, format Format) *Writer {
+	return &Writer{w: w, format: format}
+}
+
+// escape escapes the lines of a message body.
+func (f Format) escape(w io.Writer, body []byte) error {
+	for len(body) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(body, '\n'); i >= 0 {
+			line, body = body[:i+1], body[i+1:]
+		} else {
+			line, body = body, nil
+		}
+		if f.isEscapedFromLine(line, false) {
+			if _, err := io.WriteString(w, ">"); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type messageWriter struct {
+	mw       *Writer
+	fromLine string
+	buf      bytes.Buffer
+}
+
+func (w *messageWriter) Write(b []byte) (int, error) {
+	if w.mw == nil {
+		return 0, errors.New("mbox: message already closed")
+	}
+	return w.buf.Write(b)
+}
+
+func (w *messageWriter) Close() error {
+	if w.mw == nil {
+		return errors.New("mbox: message already closed")
+	}
+	mw := w.mw
+	w.mw = nil
+	mw.cur = nil
+
+	br := bufio.NewReader(&w.buf)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return err
+	}
+	body, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+	// mbox files use LF line endings
+	body = bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n"))
+	if len(body) > 0 && body[len(body)-1] != '\n' {
+		body = append(body, '\n')
+	}
+
+	var escaped bytes.Buffer
+	if err := mw.format.escape(&escaped, body); err != nil {
+		return err
+	}
+
+	if mw.format.hasContentLength() {
+		h.Set("Content-Length", strconv.Itoa(escaped.Len()))
+	}
+
+	var hbuf bytes.Buffer
+	if err := textproto.WriteHeader(&hbuf, h); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(w.fromLine)
+	buf.Write(bytes.ReplaceAll(hbuf.Bytes(), []byte("\r\n"), []byte("\n")))
+	if _, err := buf.Write(escaped.Bytes()); err != nil {
+		return err
+	}
+	// Messages are followed by a blank line
+	buf.WriteString("\n")
+	_, err = mw.w.Write(buf.Bytes())
+	return err
+}
+
+// CreateMessage starts a new message in the mbox file. The raw message,
+// including its header, must be written to the returned io.WriteCloser. The
+// message is buffered, and appended to the mbox file when the io.WriteCloser
+// is closed. Only one message can be written at a time. CRLF line endings
+// are converted to LF, like in the rest of the mbox file.
+//
+// from is the envelope sender and date is the delivery date, used to format
+// the "From " line. If from is empty, "MAILER-DAEMON" is used.
+func (w *Writer) CreateMessage(from string, date time.Time) (io.WriteCloser, error) {
+	if w.cur != nil {
+		return nil, errors.New("mbox: previous message not closed")
+	}
+	if from == "" {
+		from = "MAILER-DAEMON"
+	}
+	if strings.ContainsAny(from, " \t\r\n") {
+		return nil, errors.New("mbox: invalid envelope sender")
+	}
+
+	w.cur = &messageWriter{
+		mw:       w,
+		fromLine: "From " + from + " " + date.UTC().Format(fromDateLayout) + "\n",
+	}
+	return w.cur, nil
+}
+
+// WriteMessage appends an entity to the mbox file. See CreateMessage.
+func (w *Writer) WriteMessage(from string, date time.Time, e *message.Entity) error {
+	mw, err := w.CreateMessage(from, date)
+	if err != nil {
+		return err
+	}
+	if err := e.WriteTo(mw); err != nil {
+		return err
+	}
+	return mw.Close()
+}
@@ -18,17 +18,70 @@ type MultipartReader interface {
 	NextPart() (*Entity, error)
 }
 
+// MultipartPreambleEpilogueReader is a MultipartReader giving access to the
+// text before the first part and after the last part of a multipart body. The
+// MultipartReader returned by Entity.MultipartReader implements it.
+type MultipartPreambleEpilogueReader interface {
+	MultipartReader
+
+	// Preamble returns the bytes preceding the first part, see
+	// textproto.MultipartReader.Preamble. It returns nil if there is no
+	// preamble or if NextPart hasn't been called yet. Preambles larger than
+	// 64 KiB are truncated.
+	Preamble() []byte
+	// Epilogue reads and returns the bytes following the last part, see
+	// textproto.MultipartReader.Epilogue. It must be called after NextPart
+	// has returned io.EOF.
+	Epilogue() ([]byte, error)
+}
+
 type multipartReader struct {
 	r *textproto.MultipartReader
+
+	rs    *readState // may be nil
+	depth int        // nesting level of parts
+	err   error      // sticky error
 }
 
 // NextPart implements MultipartReader.
 func (r *multipartReader) NextPart() (*Entity, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
 	p, err := r.r.NextPart()
 	if err != nil {
 		return nil, err
 	}
-	return New(Header{p.Header}, p)
+
+	if r.rs != nil {
+		r.rs.parts++
+		if max := r.rs.opts.MaxParts; max > 0 && r.rs.parts > max {
+			r.err = LimitExceededError{Limit: "multipart parts", Max: int64(max)}
+			return nil, r.err
+		}
+	}
+
+	e, err := New(Header{p.Header}, p)
+	e.rs = r.rs
+	e.depth = r.depth
+	return e, err
+}
+
+// Preamble implements MultipartPreambleEpilogueReader.
+func (r *multipartReader) Preamble() []byte {
+	if r.r == nil {
+		return nil
+	}
+	return r.r.Preamble()
+}
+
+// Epilogue implements MultipartPreambleEpilogueReader.
+func (r *multipartReader) Epilogue() ([]byte, error) {
+	if r.r == nil {
+		return nil, r.err
+	}
+	return r.r.Epilogue()
 }
 
 // Close implements io.Closer.
@@ -53,7 +106,9 @@ func (m *multipartBody) Read(p []byte) (n int, err error) {
 		m.r = r
 
 		var err error
-		m.w, err = createWriter(w, &m.header)
+		m.w, err = createWriter(&m.header, WriterOptions{}, func(*Header) (io.Writer, error) {
+			return w, nil
+		})
 		if err != nil {
 			return 0, err
 		}
@@ -98,6 +153,16 @@ func (m *multipartBody) NextPart() (*Entity, error) {
 	return part, nil
 }
 
+// Preamble implements MultipartPreambleEpilogueReader.
+func (m *multipartBody) Preamble() []byte {
+	return nil
+}
+
+// Epilogue implements MultipartPreambleEpilogueReader.
+func (m *multipartBody) Epilogue() ([]byte, error) {
+	return nil, nil
+}
+
 func (m *multipartBody) writeBodyTo(w *Writer) error {
 	for _, p := range m.parts {
 		pw, err := w.CreatePart(p.Header)
@@ -0,0 +1,62 @@
+// Package pgpmime implements PGP/MIME signed and encrypted messages.
+//
+// PGP/MIME is defined in RFC 3156. This package only handles the MIME
+// structure: OpenPGP operations are delegated to the Signer, Encrypter,
+// Verifier and Decrypter interfaces, which can be implemented with any
+// OpenPGP library.
+package pgpmime
+
+import (
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message"
+)
+
+// A Signer creates detached OpenPGP signatures.
+type Signer interface {
+	// DetachSign reads the data to sign from r and writes an ASCII-armored
+	// detached signature to w.
+	DetachSign(w io.Writer, r io.Reader) error
+	// MICAlg returns the name of the hash algorithm used for signatures,
+	// as defined in RFC 3156 section 5, e.g. "pgp-sha256".
+	MICAlg() string
+}
+
+// An Encrypter encrypts OpenPGP messages.
+type Encrypter interface {
+	// Encrypt returns an io.WriteCloser that encrypts the plaintext written
+	// to it, and writes an ASCII-armored OpenPGP message to w. The message
+	// is complete when the io.WriteCloser is closed.
+	Encrypt(w io.Writer) (io.WriteCloser, error)
+}
+
+// A Verifier checks detached OpenPGP signatures.
+type Verifier interface {
+	// VerifyDetached checks that sig is a valid signature of the data read
+	// from signed.
+	VerifyDetached(signed io.Reader, sig io.Reader) error
+}
+
+// A Decrypter decrypts OpenPGP messages.
+type Decrypter interface {
+	// Decrypt reads an OpenPGP message from r and returns the plaintext.
+	Decrypt(r io.Reader) (io.Reader, error)
+}
+
+const (
+	signatureProtocol = "application/pgp-signature"
+	encryptedProtocol = "application/pgp-encrypted"
+)
+
+// IsSigned reports whether an entity is a PGP/MIME signed message.
+func IsSigned(h message.Header) bool {
+	t, params, _ := h.ContentType()
+	return t == "multipart/signed" && strings.EqualFold(params["protocol"], signatureProtocol)
+}
+
+// IsEncrypted reports whether an entity is a PGP/MIME encrypted message.
+func IsEncrypted(h message.Header) bool {
+	t, params, _ := h.ContentType()
+	return t == "multipart/encrypted" && strings.EqualFold(params["protocol"], encryptedProtocol)
+}
@@ -0,0 +1,214 @@
+package pgpmime
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message"
+)
+
+// testPGP is a fake OpenPGP implementation: signatures are SHA-256 hashes and
+// encryption is base64.
+type testPGP struct{}
+
+const (
+	testSigPrefix = "-----BEGIN PGP SIGNATURE-----\r\n\r\n"
+	testSigSuffix = "\r\n-----END PGP SIGNATURE-----\r\n"
+)
+
+func (testPGP) DetachSign(w io.Writer, r io.Reader) error {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, testSigPrefix+hex.EncodeToString(h.Sum(nil))+testSigSuffix)
+	return err
+}
+
+func (testPGP) MICAlg() string {
+	return "pgp-sha256"
+}
+
+func (testPGP) VerifyDetached(signed, sig io.Reader) error {
+	var want bytes.Buffer
+	if err := (testPGP{}).DetachSign(&want, signed); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(sig)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(bytes.TrimSpace(b), bytes.TrimSpace(want.Bytes())) {
+		return errors.New("bad signature")
+	}
+	return nil
+}
+
+type nopCloser struct {
+	io.Writer
+	close func() error
+}
+
+func (nc nopCloser) Close() error {
+	return nc.close()
+}
+
+func (testPGP) Encrypt(w io.Writer) (io.WriteCloser, error) {
+	io.WriteString(w, "-----BEGIN PGP MESSAGE-----\r\n\r\n")
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	return nopCloser{enc, func() error {
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\r\n-----END PGP MESSAGE-----\r\n")
+		return err
+	}}, nil
+}
+
+func (testPGP) Decrypt(r io.Reader) (io.Reader, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSpace(string(b))
+	s = strings.TrimPrefix(s, "-----BEGIN PGP MESSAGE-----")
+	s = strings.TrimSuffix(s, "-----END PGP MESSAGE-----")
+	dec, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(dec), nil
+}
+
+const testInnerEntity = "Content-Type: text/plain\n" +
+	"X-Raw:   preserved  as-is\n" +
+	"\n" +
+	"Hi Taki,\n"
+
+func testHeader() message.Header {
+	var h message.Header
+	h.Set("From", "Mitsuha Miyamizu <mitsuha.miyamizu@example.org>")
+	h.Set("Subject", "Your Name")
+	return h
+}
+
+func writeSigned(t *testing.T) string {
+	var buf bytes.Buffer
+	w, err := CreateSignedWriter(&buf, testHeader(), testPGP{})
+	if err != nil {
+		t.Fatalf("CreateSignedWriter() = %v", err)
+	}
+	if _, err := io.WriteString(w, testInnerEntity); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	return buf.String()
+}
+
+func TestSigned(t *testing.T) {
+	s := writeSigned(t)
+
+	e, err := message.Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	if !IsSigned(e.Header) {
+		t.Fatalf("Expected message to be signed, got Content-Type %q", e.Header.Get("Content-Type"))
+	}
+
+	se, err := ReadSigned(e)
+	if err != nil {
+		t.Fatalf("ReadSigned() = %v", err)
+	}
+	wantSigned := strings.Replace(testInnerEntity, "\n", "\r\n", -1)
+	if string(se.Signed) != wantSigned {
+		t.Errorf("Expected signed data to be %q, got %q", wantSigned, string(se.Signed))
+	}
+	if se.MICAlg != "pgp-sha256" {
+		t.Errorf("Expected micalg %q, got %q", "pgp-sha256", se.MICAlg)
+	}
+	if err := se.Verify(testPGP{}); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	if got := se.Entity.Header.Get("X-Raw"); got != "preserved  as-is" {
+		t.Errorf("Expected X-Raw to be %q, got %q", "preserved  as-is", got)
+	}
+
+	tampered := strings.Replace(s, "Hi Taki", "Hi Tessie", 1)
+	e, _ = message.Read(strings.NewReader(tampered))
+	if se, err := ReadSigned(e); err != nil {
+		t.Fatalf("ReadSigned() = %v", err)
+	} else if err := se.Verify(testPGP{}); err == nil {
+		t.Errorf("Expected verification of a tampered message to fail")
+	}
+}
+
+type failingSigner struct{}
+
+func (failingSigner) DetachSign(w io.Writer, r io.Reader) error {
+	return errors.New("no key")
+}
+
+func (failingSigner) MICAlg() string {
+	return "pgp-sha256"
+}
+
+func TestCreateSignedWriter_signError(t *testing.T) {
+	w, err := CreateSignedWriter(ioutil.Discard, testHeader(), failingSigner{})
+	if err != nil {
+		t.Fatalf("CreateSignedWriter() = %v", err)
+	}
+	io.WriteString(w, testInnerEntity)
+	if err := w.Close(); err == nil {
+		t.Errorf("Expected Close() to return the signer error")
+	}
+}
+
+func TestEncrypted(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := CreateEncryptedWriter(&buf, testHeader(), testPGP{})
+	if err != nil {
+		t.Fatalf("CreateEncryptedWriter() = %v", err)
+	}
+	// Sign and encrypt
+	sw, err := CreateSignedWriter(w, message.Header{}, testPGP{})
+	if err != nil {
+		t.Fatalf("CreateSignedWriter() = %v", err)
+	}
+	io.WriteString(sw, testInnerEntity)
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	e, err := message.Read(&buf)
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	if !IsEncrypted(e.Header) {
+		t.Fatalf("Expected message to be encrypted, got Content-Type %q", e.Header.Get("Content-Type"))
+	}
+
+	dec, err := Decrypt(e, testPGP{})
+	if err != nil {
+		t.Fatalf("Decrypt() = %v", err)
+	}
+	se, err := ReadSigned(dec)
+	if err != nil {
+		t.Fatalf("ReadSigned() = %v", err)
+	}
+	if err := se.Verify(testPGP{}); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+}
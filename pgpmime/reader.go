@@ -0,0 +1,128 @@
+package pgpmime
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/mimeutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+// A SignedEntity is a PGP/MIME signed entity, as defined in RFC 3156 section
+// 5.
+type SignedEntity struct {
+	// The signed entity, parsed from Signed.
+	Entity *message.Entity
+	// The exact signed data: the signed entity in canonical form, including
+	// the raw representation of its header.
+	Signed []byte
+	// The ASCII-armored detached signature.
+	Signature []byte
+	// The hash algorithm, from the micalg parameter. It's informative only.
+	MICAlg string
+}
+
+// ReadSigned reads a PGP/MIME signed entity. The body of e is consumed.
+func ReadSigned(e *message.Entity) (*SignedEntity, error) {
+	if !IsSigned(e.Header) {
+		t, _, _ := e.Header.ContentType()
+		return nil, fmt.Errorf("pgpmime: not a PGP/MIME signed entity: %q", t)
+	}
+	_, params, _ := e.Header.ContentType()
+
+	signed, sigEntity, err := mimeutil.ReadMultipartSigned(e.Body, params["boundary"])
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: %v", err)
+	}
+	if t, _, _ := sigEntity.Header.ContentType(); t != signatureProtocol {
+		return nil, fmt.Errorf("pgpmime: unexpected signature media type %q", t)
+	}
+	sig, err := ioutil.ReadAll(sigEntity.Body)
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: failed to read signature: %v", err)
+	}
+
+	se := &SignedEntity{
+		Signed:    signed,
+		Signature: sig,
+		MICAlg:    strings.ToLower(params["micalg"]),
+	}
+	se.Entity, err = message.Read(bytes.NewReader(signed))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	return se, nil
+}
+
+// Verify checks the signature with v.
+func (se *SignedEntity) Verify(v Verifier) error {
+	return v.VerifyDetached(bytes.NewReader(se.Signed), bytes.NewReader(se.Signature))
+}
+
+// Decrypt reads a PGP/MIME encrypted entity, as defined in RFC 3156 section 4,
+// and returns the decrypted entity. The body of e is consumed.
+//
+// The decrypted entity can be a PGP/MIME signed entity.
+func Decrypt(e *message.Entity, d Decrypter) (*message.Entity, error) {
+	if !IsEncrypted(e.Header) {
+		t, _, _ := e.Header.ContentType()
+		return nil, fmt.Errorf("pgpmime: not a PGP/MIME encrypted entity: %q", t)
+	}
+	_, params, _ := e.Header.ContentType()
+
+	mr := textproto.NewMultipartReader(e.Body, params["boundary"])
+
+	p, err := mr.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: failed to read control part: %v", err)
+	}
+	control, err := message.New(message.Header{Header: p.Header}, p)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	if t, _, _ := control.Header.ContentType(); t != encryptedProtocol {
+		return nil, fmt.Errorf("pgpmime: unexpected control part media type %q", t)
+	}
+	b, err := ioutil.ReadAll(control.Body)
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: failed to read control part: %v", err)
+	}
+	if !hasVersion1(b) {
+		return nil, errors.New("pgpmime: unsupported PGP/MIME version")
+	}
+
+	p, err = mr.NextPart()
+	if err != nil {
+		return nil, fmt.Errorf("pgpmime: failed to read encrypted part: %v", err)
+	}
+	data, err := message.New(message.Header{Header: p.Header}, p)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+
+	plaintext, err := d.Decrypt(data.Body)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := message.Read(plaintext)
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	return dec, nil
+}
+
+// hasVersion1 reports whether the body of a PGP/MIME control part contains
+// the "Version: 1" field.
+func hasVersion1(b []byte) bool {
+	for _, line := range strings.Split(string(b), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) == 2 && strings.EqualFold(strings.TrimSpace(kv[0]), "Version") {
+			return strings.TrimSpace(kv[1]) == "1"
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package pgpmime
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/mimeutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+type signedWriter struct {
+	*mimeutil.MultipartSignedWriter
+	signer  Signer
+	pr      *io.PipeReader
+	pw      *io.PipeWriter
+	started bool         // the signer goroutine has been started
+	sig     bytes.Buffer // written by the signer goroutine until done is sent
+	done    chan error
+}
+
+// start starts the signer goroutine, if it isn't running yet. The goroutine
+// exits once the pipe is closed by Close.
+func (sw *signedWriter) start() {
+	if sw.started {
+		return
+	}
+	sw.started = true
+
+	go func() {
+		err := sw.signer.DetachSign(&sw.sig, sw.pr)
+		if err == nil {
+			// Drain any data the signer didn't read, so that writes don't
+			// block
+			_, err = io.Copy(ioutil.Discard, sw.pr)
+		}
+		sw.pr.CloseWithError(err)
+		sw.done <- err
+	}()
+}
+
+func (sw *signedWriter) Write(b []byte) (int, error) {
+	sw.start()
+	return sw.MultipartSignedWriter.Write(b)
+}
+
+func (sw *signedWriter) Close() error {
+	sw.start()
+	sw.pw.Close()
+	if err := <-sw.done; err != nil {
+		return err
+	}
+
+	var h textproto.Header
+	h.Set("Content-Type", signatureProtocol+"; name=\"signature.asc\"")
+	h.Set("Content-Description", "OpenPGP digital signature")
+	h.Set("Content-Disposition", "attachment; filename=\"signature.asc\"")
+	return sw.WriteSignature(h, sw.sig.Bytes())
+}
+
+// CreateSignedWriter writes a multipart/signed message to w. h is the header
+// of the message, its Content-Type is overwritten.
+//
+// The entity to sign, including its header, must be written to the returned
+// io.WriteCloser, e.g. with message.CreateWriter or mail.CreateSingleInlineWriter.
+// It's written to w as-is, except bare LF line endings are converted to
+// CRLF. As recommended in RFC 3156 section 5, the entity should only contain
+// 7-bit data and no trailing whitespace. The signature is written when the
+// io.WriteCloser is closed.
+//
+// The signer runs in a goroutine started by the first write. The
+// io.WriteCloser must be closed once a write has been made, even if an error
+// occurred, otherwise the goroutine is leaked.
+func CreateSignedWriter(w io.Writer, h message.Header, signer Signer) (io.WriteCloser, error) {
+	if signer == nil {
+		return nil, errors.New("pgpmime: no signer specified")
+	}
+
+	pr, pw := io.Pipe()
+	sw := &signedWriter{signer: signer, pr: pr, pw: pw, done: make(chan error, 1)}
+
+	mw, err := mimeutil.NewMultipartSignedWriter(w, h, map[string]string{
+		"protocol": signatureProtocol,
+		"micalg":   signer.MICAlg(),
+	}, pw)
+	if err != nil {
+		return nil, err
+	}
+	sw.MultipartSignedWriter = mw
+
+	return sw, nil
+}
+
+type encryptedWriter struct {
+	cw  *mimeutil.CRLFWriter
+	enc io.WriteCloser
+	mw  *textproto.MultipartWriter
+}
+
+func (ew *encryptedWriter) Write(b []byte) (int, error) {
+	return ew.cw.Write(b)
+}
+
+func (ew *encryptedWriter) Close() error {
+	if err := ew.enc.Close(); err != nil {
+		return err
+	}
+	return ew.mw.Close()
+}
+
+// CreateEncryptedWriter writes a multipart/encrypted message to w. h is the
+// header of the message, its Content-Type is overwritten.
+//
+// The entity to encrypt, including its header, must be written to the
+// returned io.WriteCloser, e.g. with message.CreateWriter. To sign and
+// encrypt a message, a signed entity can be written to it. The message is
+// complete when the io.WriteCloser is closed.
+func CreateEncryptedWriter(w io.Writer, h message.Header, encrypter Encrypter) (io.WriteCloser, error) {
+	if encrypter == nil {
+		return nil, errors.New("pgpmime: no encrypter specified")
+	}
+
+	h = h.Copy()
+	if !h.Has("Mime-Version") {
+		h.Set("MIME-Version", "1.0")
+	}
+	mw := textproto.NewMultipartWriter(w)
+	h.SetContentType("multipart/encrypted", map[string]string{
+		"protocol": encryptedProtocol,
+		"boundary": mw.Boundary(),
+	})
+	h.Del("Content-Transfer-Encoding")
+
+	if err := textproto.WriteHeader(w, h.Header); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, "This is an OpenPGP/MIME encrypted message (RFC 4880 and 3156).\r\n\r\n"); err != nil {
+		return nil, err
+	}
+
+	var controlHeader textproto.Header
+	controlHeader.Set("Content-Type", encryptedProtocol)
+	controlHeader.Set("Content-Description", "PGP/MIME version identification")
+	cw, err := mw.CreatePart(controlHeader)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(cw, "Version: 1\r\n"); err != nil {
+		return nil, err
+	}
+
+	var dataHeader textproto.Header
+	dataHeader.Set("Content-Type", "application/octet-stream; name=\"encrypted.asc\"")
+	dataHeader.Set("Content-Description", "OpenPGP encrypted message")
+	dataHeader.Set("Content-Disposition", "inline; filename=\"encrypted.asc\"")
+	dw, err := mw.CreatePart(dataHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	enc, err := encrypter.Encrypt(dw)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedWriter{
+		cw:  &mimeutil.CRLFWriter{W: enc},
+		enc: enc,
+		mw:  mw,
+	}, nil
+}
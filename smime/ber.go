@@ -0,0 +1,136 @@
+package smime
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+)
+
+// berToDER converts a BER-encoded structure to DER, so that it can be parsed
+// with encoding/asn1. Many S/MIME implementations produce BER with
+// indefinite lengths and constructed octet strings.
+//
+// Only the encoding of lengths and octet strings is normalized, SET OF
+// elements are not sorted.
+func berToDER(b []byte) ([]byte, error) {
+	der, rest, err := convertBER(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimRight(rest, "\x00")) > 0 {
+		return nil, errors.New("smime: trailing data after BER structure")
+	}
+	return der, nil
+}
+
+// maxBERDepth limits the nesting level of BER structures.
+const maxBERDepth = 64
+
+func convertBER(b []byte, depth int) (der, rest []byte, err error) {
+	if depth > maxBERDepth {
+		return nil, nil, errors.New("smime: BER structure too deep")
+	}
+	if len(b) < 2 {
+		return nil, nil, errors.New("smime: truncated BER element")
+	}
+
+	i := 1
+	if b[0]&0x1f == 0x1f {
+		// High tag number form
+		for i < len(b) && b[i]&0x80 != 0 {
+			i++
+		}
+		i++
+	}
+	if i >= len(b) {
+		return nil, nil, errors.New("smime: truncated BER tag")
+	}
+	tag := b[:i]
+	constructed := b[0]&0x20 != 0
+
+	l := b[i]
+	i++
+
+	var children [][]byte
+	if l == 0x80 {
+		// Indefinite length, terminated by an end-of-contents marker
+		if !constructed {
+			return nil, nil, errors.New("smime: indefinite length for primitive BER element")
+		}
+		rest = b[i:]
+		for {
+			if len(rest) >= 2 && rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			var child []byte
+			if child, rest, err = convertBER(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+			children = append(children, child)
+		}
+	} else {
+		n := int(l)
+		if l&0x80 != 0 {
+			nb := int(l & 0x7f)
+			if nb == 0 || nb > 4 || len(b)-i < nb {
+				return nil, nil, errors.New("smime: invalid BER length")
+			}
+			n = 0
+			for _, c := range b[i : i+nb] {
+				n = n<<8 | int(c)
+			}
+			i += nb
+		}
+		if n < 0 || len(b)-i < n {
+			return nil, nil, errors.New("smime: truncated BER element")
+		}
+		content := b[i : i+n]
+		rest = b[i+n:]
+		if !constructed {
+			return appendElement(nil, tag, content), rest, nil
+		}
+		for len(content) > 0 {
+			var child []byte
+			if child, content, err = convertBER(content, depth+1); err != nil {
+				return nil, nil, err
+			}
+			children = append(children, child)
+		}
+	}
+
+	if tag[0] == 0x24 {
+		// Constructed OCTET STRING: concatenate the segments in a primitive
+		// OCTET STRING
+		var content []byte
+		for _, child := range children {
+			var rv asn1.RawValue
+			if _, err := asn1.Unmarshal(child, &rv); err != nil {
+				return nil, nil, err
+			}
+			content = append(content, rv.Bytes...)
+		}
+		return appendElement(nil, []byte{0x04}, content), rest, nil
+	}
+
+	return appendElement(nil, tag, bytes.Join(children, nil)), rest, nil
+}
+
+// appendElement appends a DER element with the provided tag and content to b.
+func appendElement(b, tag, content []byte) []byte {
+	b = append(b, tag...)
+	n := len(content)
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	case n < 0x1000000:
+		b = append(b, 0x83, byte(n>>16), byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, content...)
+}
@@ -0,0 +1,578 @@
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// This file implements the subset of the Cryptographic Message Syntax (CMS),
+// defined in RFC 5652, used by S/MIME.
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	oidAttrContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttrMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttrSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+const (
+	classContextSpecific = 2
+	tagSet               = 17
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type envelopedData struct {
+	Version              int
+	OriginatorInfo       asn1.RawValue   `asn1:"optional,tag:0"`
+	RecipientInfos       []asn1.RawValue `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	RID                    asn1.RawValue
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// explicitTag wraps a DER element in a [0] EXPLICIT tag.
+func explicitTag(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: classContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func marshalContentInfo(contentType asn1.ObjectIdentifier, content interface{}) ([]byte, error) {
+	der, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: contentType, Content: explicitTag(der)})
+}
+
+// parseContentInfo parses a BER or DER ContentInfo and checks its content
+// type.
+func parseContentInfo(b []byte, contentType asn1.ObjectIdentifier, content interface{}) error {
+	der, err := berToDER(b)
+	if err != nil {
+		return err
+	}
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil {
+		return fmt.Errorf("smime: malformed CMS structure: %v", err)
+	} else if len(rest) > 0 {
+		return errors.New("smime: trailing data after CMS structure")
+	}
+	if !ci.ContentType.Equal(contentType) {
+		return fmt.Errorf("smime: unexpected CMS content type %v, want %v", ci.ContentType, contentType)
+	}
+	// encoding/asn1 doesn't unwrap explicit tags for RawValue fields
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, content); err != nil {
+		return fmt.Errorf("smime: malformed CMS content: %v", err)
+	}
+	return nil
+}
+
+func marshalIssuerAndSerialNumber(cert *x509.Certificate) (asn1.RawValue, error) {
+	der, err := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	})
+	return asn1.RawValue{FullBytes: der}, err
+}
+
+// matchesIdentifier reports whether a certificate matches a SignerIdentifier
+// or a RecipientIdentifier: either an IssuerAndSerialNumber or a [0]
+// SubjectKeyIdentifier.
+func matchesIdentifier(cert *x509.Certificate, id asn1.RawValue) bool {
+	if id.Class == classContextSpecific && id.Tag == 0 {
+		return len(cert.SubjectKeyId) > 0 && bytes.Equal(cert.SubjectKeyId, id.Bytes)
+	}
+	var ias issuerAndSerialNumber
+	if _, err := asn1.Unmarshal(id.FullBytes, &ias); err != nil {
+		return false
+	}
+	return bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0
+}
+
+func marshalAttribute(typ asn1.ObjectIdentifier, v interface{}) ([]byte, error) {
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(attribute{
+		Type:   typ,
+		Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: tagSet, IsCompound: true, Bytes: der},
+	})
+}
+
+// digestHash returns the hash function for a digest algorithm.
+func digestHash(algo asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case algo.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case algo.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case algo.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	default:
+		return 0, fmt.Errorf("smime: unsupported digest algorithm %v", algo)
+	}
+}
+
+// signatureAlgorithm returns the x509 signature algorithm for a hash function
+// and a public key.
+func signatureAlgorithm(h crypto.Hash, pub crypto.PublicKey) (x509.SignatureAlgorithm, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		switch h {
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case *ecdsa.PublicKey:
+		switch h {
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("smime: unsupported signature algorithm %v with key %T", h, pub)
+}
+
+// signer signs content with a certificate.
+type signer struct {
+	cert          *x509.Certificate
+	key           crypto.Signer
+	intermediates []*x509.Certificate
+	time          time.Time
+}
+
+// sign creates a CMS SignedData structure, with the SHA-256 digest of the
+// content. If content is nil, the signature is detached.
+func (s *signer) sign(digest []byte, content []byte) ([]byte, error) {
+	var sigAlgo pkix.AlgorithmIdentifier
+	switch s.key.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlgo = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		sigAlgo = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, fmt.Errorf("smime: unsupported key type %T", s.key.Public())
+	}
+
+	var attrs [][]byte
+	for _, attr := range []struct {
+		typ asn1.ObjectIdentifier
+		v   interface{}
+	}{
+		{oidAttrContentType, oidData},
+		{oidAttrSigningTime, s.time.UTC()},
+		{oidAttrMessageDigest, digest},
+	} {
+		der, err := marshalAttribute(attr.typ, attr.v)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	// DER requires SET OF elements to be sorted
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i], attrs[j]) < 0
+	})
+	attrsContent := bytes.Join(attrs, nil)
+
+	// The signature covers the DER encoding of the attributes with a SET tag,
+	// not the [0] IMPLICIT tag used in SignerInfo
+	signedAttrs, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: tagSet, IsCompound: true, Bytes: attrsContent})
+	if err != nil {
+		return nil, err
+	}
+	hashed := crypto.SHA256.New()
+	hashed.Write(signedAttrs)
+	sig, err := s.key.Sign(rand.Reader, hashed.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("smime: failed to sign: %v", err)
+	}
+
+	sid, err := marshalIssuerAndSerialNumber(s.cert)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []byte
+	for _, cert := range append([]*x509.Certificate{s.cert}, s.intermediates...) {
+		certs = append(certs, cert.Raw...)
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapContentInfo{EContentType: oidData},
+		Certificates:     asn1.RawValue{Class: classContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                sid,
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{Class: classContextSpecific, Tag: 0, IsCompound: true, Bytes: attrsContent},
+			SignatureAlgorithm: sigAlgo,
+			Signature:          sig,
+		}},
+	}
+	if content != nil {
+		der, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		sd.EncapContentInfo.EContent = explicitTag(der)
+	}
+
+	return marshalContentInfo(oidSignedData, sd)
+}
+
+// parseSignedData parses a CMS SignedData structure. It returns the
+// encapsulated content, if any, and the certificates.
+func parseSignedData(b []byte) (*signedData, []byte, []*x509.Certificate, error) {
+	var sd signedData
+	if err := parseContentInfo(b, oidSignedData, &sd); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var content []byte
+	if len(sd.EncapContentInfo.EContent.Bytes) > 0 {
+		if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &content); err != nil {
+			return nil, nil, nil, fmt.Errorf("smime: malformed encapsulated content: %v", err)
+		}
+	}
+
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		var err error
+		if certs, err = x509.ParseCertificates(sd.Certificates.Bytes); err != nil {
+			return nil, nil, nil, fmt.Errorf("smime: malformed certificates: %v", err)
+		}
+	}
+
+	return &sd, content, certs, nil
+}
+
+// verifySignerInfo checks a SignerInfo against the signed content and its
+// type. It returns the signer certificate and the signing time, if any.
+func verifySignerInfo(si *signerInfo, certs []*x509.Certificate, contentType asn1.ObjectIdentifier, content []byte) (*x509.Certificate, time.Time, error) {
+	var cert *x509.Certificate
+	for _, c := range certs {
+		if matchesIdentifier(c, si.SID) {
+			cert = c
+			break
+		}
+	}
+	if cert == nil {
+		return nil, time.Time{}, errors.New("smime: signer certificate not found")
+	}
+
+	h, err := digestHash(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	algo, err := signatureAlgorithm(h, cert.PublicKey)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if len(si.SignedAttrs.FullBytes) == 0 {
+		// Without signed attributes, the signature covers the content
+		if err := cert.CheckSignature(algo, content, si.Signature); err != nil {
+			return nil, time.Time{}, fmt.Errorf("smime: invalid signature: %v", err)
+		}
+		return cert, time.Time{}, nil
+	}
+
+	// The signature covers the attributes with a SET tag
+	signedAttrs := append([]byte(nil), si.SignedAttrs.FullBytes...)
+	signedAttrs[0] = 0x31
+	if err := cert.CheckSignature(algo, signedAttrs, si.Signature); err != nil {
+		return nil, time.Time{}, fmt.Errorf("smime: invalid signature: %v", err)
+	}
+
+	var attrs []attribute
+	if _, err := asn1.UnmarshalWithParams(signedAttrs, &attrs, "set"); err != nil {
+		return nil, time.Time{}, fmt.Errorf("smime: malformed signed attributes: %v", err)
+	}
+	var digest []byte
+	var signedContentType asn1.ObjectIdentifier
+	var signingTime time.Time
+	for _, attr := range attrs {
+		switch {
+		case attr.Type.Equal(oidAttrContentType):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &signedContentType); err != nil {
+				return nil, time.Time{}, fmt.Errorf("smime: malformed content type: %v", err)
+			}
+		case attr.Type.Equal(oidAttrMessageDigest):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+				return nil, time.Time{}, fmt.Errorf("smime: malformed message digest: %v", err)
+			}
+		case attr.Type.Equal(oidAttrSigningTime):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &signingTime); err != nil {
+				return nil, time.Time{}, fmt.Errorf("smime: malformed signing time: %v", err)
+			}
+		}
+	}
+	if digest == nil {
+		return nil, time.Time{}, errors.New("smime: missing message digest attribute")
+	}
+	// RFC 5652 section 5.3: the content type attribute must match the type of
+	// the encapsulated content
+	if signedContentType == nil {
+		return nil, time.Time{}, errors.New("smime: missing content type attribute")
+	} else if !signedContentType.Equal(contentType) {
+		return nil, time.Time{}, fmt.Errorf("smime: content type attribute %v doesn't match the encapsulated content type %v", signedContentType, contentType)
+	}
+
+	hasher := h.New()
+	hasher.Write(content)
+	if !bytes.Equal(hasher.Sum(nil), digest) {
+		return nil, time.Time{}, errors.New("smime: message digest mismatch")
+	}
+
+	return cert, signingTime, nil
+}
+
+func pkcs7Pad(b []byte, blockSize int) []byte {
+	n := blockSize - len(b)%blockSize
+	return append(b, bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func pkcs7Unpad(b []byte, blockSize int) ([]byte, error) {
+	if len(b) == 0 || len(b)%blockSize != 0 {
+		return nil, errors.New("smime: invalid padding")
+	}
+	n := int(b[len(b)-1])
+	if n == 0 || n > blockSize || n > len(b) {
+		return nil, errors.New("smime: invalid padding")
+	}
+	for _, c := range b[len(b)-n:] {
+		if int(c) != n {
+			return nil, errors.New("smime: invalid padding")
+		}
+	}
+	return b[:len(b)-n], nil
+}
+
+// encrypt creates a CMS EnvelopedData structure. The content is encrypted
+// with AES-256-CBC, the content encryption key is encrypted for each
+// recipient with RSA PKCS #1 v1.5.
+func encrypt(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext := pkcs7Pad(append([]byte(nil), content...), aes.BlockSize)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	var ris []asn1.RawValue
+	for _, cert := range recipients {
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("smime: unsupported recipient key type %T", cert.PublicKey)
+		}
+		encKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, err
+		}
+		rid, err := marshalIssuerAndSerialNumber(cert)
+		if err != nil {
+			return nil, err
+		}
+		der, err := asn1.Marshal(keyTransRecipientInfo{
+			Version:                0,
+			RID:                    rid,
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ris = append(ris, asn1.RawValue{FullBytes: der})
+	}
+
+	return marshalContentInfo(oidEnvelopedData, envelopedData{
+		Version:        0,
+		RecipientInfos: ris,
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+				Algorithm:  oidAES256CBC,
+				Parameters: asn1.RawValue{FullBytes: ivDER},
+			},
+			EncryptedContent: asn1.RawValue{Class: classContextSpecific, Tag: 0, Bytes: ciphertext},
+		},
+	})
+}
+
+// decrypt decrypts a CMS EnvelopedData structure.
+func decrypt(b []byte, cert *x509.Certificate, key crypto.Decrypter) ([]byte, error) {
+	var ed envelopedData
+	if err := parseContentInfo(b, oidEnvelopedData, &ed); err != nil {
+		return nil, err
+	}
+
+	var ri *keyTransRecipientInfo
+	for _, raw := range ed.RecipientInfos {
+		if raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence {
+			// Not a KeyTransRecipientInfo
+			continue
+		}
+		var ktri keyTransRecipientInfo
+		if _, err := asn1.Unmarshal(raw.FullBytes, &ktri); err != nil {
+			return nil, fmt.Errorf("smime: malformed recipient info: %v", err)
+		}
+		if matchesIdentifier(cert, ktri.RID) {
+			ri = &ktri
+			break
+		}
+	}
+	if ri == nil {
+		return nil, errors.New("smime: message is not encrypted for this certificate")
+	}
+	if !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAEncryption) {
+		return nil, fmt.Errorf("smime: unsupported key encryption algorithm %v", ri.KeyEncryptionAlgorithm.Algorithm)
+	}
+	contentKey, err := key.Decrypt(rand.Reader, ri.EncryptedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("smime: failed to decrypt content encryption key: %v", err)
+	}
+
+	eci := &ed.EncryptedContentInfo
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("smime: malformed content encryption parameters: %v", err)
+	}
+
+	var block cipher.Block
+	switch algo := eci.ContentEncryptionAlgorithm.Algorithm; {
+	case algo.Equal(oidAES128CBC), algo.Equal(oidAES192CBC), algo.Equal(oidAES256CBC):
+		block, err = aes.NewCipher(contentKey)
+	case algo.Equal(oidDESEDE3CBC):
+		block, err = des.NewTripleDESCipher(contentKey)
+	default:
+		return nil, fmt.Errorf("smime: unsupported content encryption algorithm %v", algo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("smime: invalid content encryption key: %v", err)
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, errors.New("smime: invalid content encryption IV")
+	}
+
+	ciphertext := eci.EncryptedContent.Bytes
+	if eci.EncryptedContent.IsCompound {
+		// Segmented content, as produced by BER encoders
+		ciphertext = nil
+		rest := eci.EncryptedContent.Bytes
+		for len(rest) > 0 {
+			var segment []byte
+			if rest, err = asn1.Unmarshal(rest, &segment); err != nil {
+				return nil, fmt.Errorf("smime: malformed encrypted content: %v", err)
+			}
+			ciphertext = append(ciphertext, segment...)
+		}
+	}
+	if len(ciphertext) == 0 || len(ciphertext)%block.BlockSize() != 0 {
+		return nil, errors.New("smime: invalid encrypted content length")
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	return pkcs7Unpad(plaintext, block.BlockSize())
+}
@@ -0,0 +1,393 @@
+// Package smime implements S/MIME signing, verification, encryption and
+// decryption.
+//
+// S/MIME is defined in RFC 8551. Signed messages use the multipart/signed
+// format defined in RFC 1847 (detached signatures) or an
+// application/pkcs7-mime entity (opaque signatures). Encrypted messages use
+// CMS enveloped data, defined in RFC 5652.
+//
+// Signatures are created with SHA-256 and RSA or ECDSA keys. Content is
+// encrypted with AES-256-CBC, for recipients with RSA keys.
+package smime
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/internal/mimeutil"
+	"github.com/emersion/go-message/textproto"
+)
+
+// SignOptions contains options for signing.
+type SignOptions struct {
+	// The signer certificate. Required.
+	Certificate *x509.Certificate
+	// The private key matching the certificate. Either an *rsa.PrivateKey or
+	// an *ecdsa.PrivateKey. Required.
+	Signer crypto.Signer
+	// Intermediate certificates to include in the signature.
+	Intermediates []*x509.Certificate
+	// The signing time. If zero, the current time is used.
+	Time time.Time
+}
+
+func (opts *SignOptions) signer() (*signer, error) {
+	if opts == nil || opts.Certificate == nil || opts.Signer == nil {
+		return nil, errors.New("smime: no certificate or signer specified")
+	}
+	t := opts.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return &signer{
+		cert:          opts.Certificate,
+		key:           opts.Signer,
+		intermediates: opts.Intermediates,
+		time:          t,
+	}, nil
+}
+
+// encodeBase64 encodes b to base64, with lines of 76 characters.
+func encodeBase64(b []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(b)
+	var buf bytes.Buffer
+	for len(enc) > 0 {
+		n := 76
+		if n > len(enc) {
+			n = len(enc)
+		}
+		buf.WriteString(enc[:n] + "\r\n")
+		enc = enc[n:]
+	}
+	return buf.Bytes()
+}
+
+// writeBase64Entity writes an entity with a base64-encoded body.
+func writeBase64Entity(w io.Writer, h textproto.Header, body []byte) error {
+	h.Set("Content-Transfer-Encoding", "base64")
+	if err := textproto.WriteHeader(w, h); err != nil {
+		return err
+	}
+	_, err := w.Write(encodeBase64(body))
+	return err
+}
+
+type signedWriter struct {
+	*mimeutil.MultipartSignedWriter
+	hasher hash.Hash
+	signer *signer
+}
+
+func (sw *signedWriter) Close() error {
+	sig, err := sw.signer.sign(sw.hasher.Sum(nil), nil)
+	if err != nil {
+		return err
+	}
+
+	var h textproto.Header
+	h.Set("Content-Type", "application/pkcs7-signature; name=smime.p7s")
+	h.Set("Content-Transfer-Encoding", "base64")
+	h.Set("Content-Disposition", "attachment; filename=smime.p7s")
+	return sw.WriteSignature(h, encodeBase64(sig))
+}
+
+// CreateSignedWriter writes a multipart/signed message with a detached
+// signature to w. h is the header of the message, its Content-Type is
+// overwritten.
+//
+// The entity to sign, including its header, must be written to the returned
+// io.WriteCloser, e.g. with message.CreateWriter. It's written to w as-is,
+// except bare LF line endings are converted to CRLF. The entity should only
+// contain 7-bit data, since intermediaries may alter other encodings. The
+// signature is written when the io.WriteCloser is closed.
+func CreateSignedWriter(w io.Writer, h message.Header, opts *SignOptions) (io.WriteCloser, error) {
+	s, err := opts.signer()
+	if err != nil {
+		return nil, err
+	}
+
+	hasher := sha256.New()
+	mw, err := mimeutil.NewMultipartSignedWriter(w, h, map[string]string{
+		"protocol": "application/pkcs7-signature",
+		"micalg":   "sha-256",
+	}, hasher)
+	if err != nil {
+		return nil, err
+	}
+	return &signedWriter{MultipartSignedWriter: mw, hasher: hasher, signer: s}, nil
+}
+
+// bufferedWriter buffers an entity and calls a function with the canonical
+// form of the entity when closed.
+type bufferedWriter struct {
+	buf   bytes.Buffer
+	cw    *mimeutil.CRLFWriter
+	close func(b []byte) error
+}
+
+func newBufferedWriter(close func(b []byte) error) *bufferedWriter {
+	bw := &bufferedWriter{close: close}
+	bw.cw = &mimeutil.CRLFWriter{W: &bw.buf}
+	return bw
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	return bw.cw.Write(b)
+}
+
+func (bw *bufferedWriter) Close() error {
+	return bw.close(bw.buf.Bytes())
+}
+
+func pkcs7MimeHeader(h message.Header, smimeType string) textproto.Header {
+	h = h.Copy()
+	if !h.Has("Mime-Version") {
+		h.Set("MIME-Version", "1.0")
+	}
+	h.SetContentType("application/pkcs7-mime", map[string]string{
+		"smime-type": smimeType,
+		"name":       "smime.p7m",
+	})
+	h.SetContentDisposition("attachment", map[string]string{"filename": "smime.p7m"})
+	return h.Header
+}
+
+// CreateOpaqueSignedWriter writes a message with an opaque signature to w,
+// using an application/pkcs7-mime entity. h is the header of the message, its
+// Content-Type is overwritten.
+//
+// The entity to sign, including its header, must be written to the returned
+// io.WriteCloser. The whole entity is buffered, the message is written when
+// the io.WriteCloser is closed.
+func CreateOpaqueSignedWriter(w io.Writer, h message.Header, opts *SignOptions) (io.WriteCloser, error) {
+	s, err := opts.signer()
+	if err != nil {
+		return nil, err
+	}
+	return newBufferedWriter(func(b []byte) error {
+		digest := sha256.Sum256(b)
+		sig, err := s.sign(digest[:], b)
+		if err != nil {
+			return err
+		}
+		return writeBase64Entity(w, pkcs7MimeHeader(h, "signed-data"), sig)
+	}), nil
+}
+
+// CreateEncryptedWriter writes an encrypted message to w, using an
+// application/pkcs7-mime entity. h is the header of the message, its
+// Content-Type is overwritten. Recipient certificates must contain RSA keys.
+//
+// The entity to encrypt, including its header, must be written to the
+// returned io.WriteCloser. The whole entity is buffered, the message is
+// written when the io.WriteCloser is closed.
+func CreateEncryptedWriter(w io.Writer, h message.Header, recipients []*x509.Certificate) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("smime: no recipients specified")
+	}
+	return newBufferedWriter(func(b []byte) error {
+		enc, err := encrypt(b, recipients)
+		if err != nil {
+			return err
+		}
+		return writeBase64Entity(w, pkcs7MimeHeader(h, "enveloped-data"), enc)
+	}), nil
+}
+
+// VerifyOptions contains options for Verify.
+type VerifyOptions struct {
+	// Roots is the set of trusted root certificates. If nil, the system
+	// roots are used.
+	Roots *x509.CertPool
+	// CurrentTime is used to check the validity of certificates. If zero,
+	// the current time is used.
+	CurrentTime time.Time
+	// UseSigningTime makes Verify check the validity of certificates at the
+	// signing time, if available and if CurrentTime is zero. The signing
+	// time is chosen by the signer: with this option, an attacker can
+	// backdate a signature made with an expired certificate.
+	UseSigningTime bool
+	// KeyUsages lists the acceptable extended key usages of the signer
+	// certificate. If nil, ExtKeyUsageEmailProtection is required.
+	KeyUsages []x509.ExtKeyUsage
+}
+
+// A Signer is a verified signer of a message.
+type Signer struct {
+	// The signer certificate.
+	Certificate *x509.Certificate
+	// The certificate chains to the trusted roots.
+	Chains [][]*x509.Certificate
+	// The signing time. If zero, the signing time is unknown.
+	SigningTime time.Time
+}
+
+// A Verification is the result of the verification of a signed message.
+type Verification struct {
+	// The signed entity.
+	Entity *message.Entity
+	// The signers. All signatures are valid.
+	Signers []*Signer
+}
+
+// IsSigned reports whether an entity is an S/MIME signed message, with a
+// detached or opaque signature.
+func IsSigned(h message.Header) bool {
+	t, params, _ := h.ContentType()
+	switch t {
+	case "multipart/signed":
+		protocol := strings.ToLower(params["protocol"])
+		return protocol == "application/pkcs7-signature" || protocol == "application/x-pkcs7-signature"
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+		return strings.ToLower(params["smime-type"]) == "signed-data"
+	}
+	return false
+}
+
+// IsEncrypted reports whether an entity is an S/MIME encrypted message.
+func IsEncrypted(h message.Header) bool {
+	t, params, _ := h.ContentType()
+	switch t {
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+		smimeType := strings.ToLower(params["smime-type"])
+		// The smime-type parameter is optional for enveloped data
+		return smimeType == "enveloped-data" || smimeType == ""
+	}
+	return false
+}
+
+// Verify checks the S/MIME signature of an entity, with a detached or opaque
+// signature. The body of e is consumed.
+//
+// If all signatures are valid and the signer certificates chain to trusted
+// roots, the signed entity is returned. The signed entity can be encrypted,
+// in which case Decrypt can be used.
+func Verify(e *message.Entity, opts *VerifyOptions) (*Verification, error) {
+	if opts == nil {
+		opts = new(VerifyOptions)
+	}
+
+	var content, sig []byte
+	t, params, err := e.Header.ContentType()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case "multipart/signed":
+		if !IsSigned(e.Header) {
+			return nil, fmt.Errorf("smime: unsupported multipart/signed protocol %q", params["protocol"])
+		}
+		var sigEntity *message.Entity
+		content, sigEntity, err = mimeutil.ReadMultipartSigned(e.Body, params["boundary"])
+		if err != nil {
+			return nil, fmt.Errorf("smime: %v", err)
+		}
+		if sig, err = ioutil.ReadAll(sigEntity.Body); err != nil {
+			return nil, fmt.Errorf("smime: failed to read signature: %v", err)
+		}
+	case "application/pkcs7-mime", "application/x-pkcs7-mime":
+		if sig, err = ioutil.ReadAll(e.Body); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("smime: unsupported media type %q", t)
+	}
+
+	sd, encapContent, certs, err := parseSignedData(sig)
+	if err != nil {
+		return nil, err
+	}
+	if content == nil {
+		if encapContent == nil {
+			return nil, errors.New("smime: opaque signature without content")
+		}
+		content = encapContent
+	}
+	if len(sd.SignerInfos) == 0 {
+		return nil, errors.New("smime: no signer")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs {
+		intermediates.AddCert(cert)
+	}
+	keyUsages := opts.KeyUsages
+	if keyUsages == nil {
+		keyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}
+	}
+
+	v := new(Verification)
+	for i := range sd.SignerInfos {
+		cert, signingTime, err := verifySignerInfo(&sd.SignerInfos[i], certs, sd.EncapContentInfo.EContentType, content)
+		if err != nil {
+			return nil, err
+		}
+
+		currentTime := opts.CurrentTime
+		if currentTime.IsZero() {
+			if opts.UseSigningTime && !signingTime.IsZero() {
+				currentTime = signingTime
+			} else {
+				currentTime = time.Now()
+			}
+		}
+		chains, err := cert.Verify(x509.VerifyOptions{
+			Roots:         opts.Roots,
+			Intermediates: intermediates,
+			CurrentTime:   currentTime,
+			KeyUsages:     keyUsages,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("smime: invalid signer certificate: %v", err)
+		}
+
+		v.Signers = append(v.Signers, &Signer{
+			Certificate: cert,
+			Chains:      chains,
+			SigningTime: signingTime,
+		})
+	}
+
+	if v.Entity, err = message.Read(bytes.NewReader(content)); err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Decrypt decrypts an S/MIME encrypted entity with the recipient's
+// certificate and private key. The body of e is consumed.
+//
+// The decrypted entity is returned. It can be signed, in which case Verify
+// can be used.
+func Decrypt(e *message.Entity, cert *x509.Certificate, key crypto.Decrypter) (*message.Entity, error) {
+	if !IsEncrypted(e.Header) {
+		t, _, _ := e.Header.ContentType()
+		return nil, fmt.Errorf("smime: unsupported media type %q", t)
+	}
+
+	b, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		return nil, err
+	}
+	content, err := decrypt(b, cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := message.Read(bytes.NewReader(content))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, err
+	}
+	return dec, nil
+}
@@ -0,0 +1,295 @@
+package smime
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message"
+)
+
+var testTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type testIdentity struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// newTestIdentities creates a root certificate and an RSA leaf certificate
+// signed by the root.
+func newTestIdentities(t *testing.T) (*x509.CertPool, *testIdentity) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate root key: %v", err)
+	}
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root"},
+		NotBefore:             testTime.Add(-time.Hour),
+		NotAfter:              testTime.Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, rootKey.Public(), rootKey)
+	if err != nil {
+		t.Fatalf("failed to create root certificate: %v", err)
+	}
+	root, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		t.Fatalf("failed to parse root certificate: %v", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	// Use a random serial number, since all roots have the same name
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatalf("failed to generate serial number: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   serial,
+		Subject:        pkix.Name{CommonName: "Mitsuha Miyamizu"},
+		EmailAddresses: []string{"mitsuha.miyamizu@example.org"},
+		NotBefore:      testTime.Add(-time.Hour),
+		NotAfter:       testTime.Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, key.Public(), rootKey)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return roots, &testIdentity{cert: cert, key: key}
+}
+
+const testInnerEntity = "Content-Type: text/plain; charset=us-ascii\n" +
+	"X-Raw:   preserved  as-is\n" +
+	"\n" +
+	"Hi Taki,\n" +
+	"\n" +
+	"Did you get my message?\n"
+
+func testOuterHeader() message.Header {
+	var h message.Header
+	h.Set("From", "Mitsuha Miyamizu <mitsuha.miyamizu@example.org>")
+	h.Set("Subject", "Your Name")
+	return h
+}
+
+func writeTestMessage(t *testing.T, create func(w *bytes.Buffer) (io.WriteCloser, error)) *message.Entity {
+	var buf bytes.Buffer
+	w, err := create(&buf)
+	if err != nil {
+		t.Fatalf("failed to create writer: %v", err)
+	}
+	if _, err := w.Write([]byte(testInnerEntity)); err != nil {
+		t.Fatalf("Write() = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	e, err := message.Read(&buf)
+	if err != nil {
+		t.Fatalf("message.Read() = %v", err)
+	}
+	return e
+}
+
+func checkInnerEntity(t *testing.T, e *message.Entity) {
+	if got := e.Header.Get("X-Raw"); got != "preserved  as-is" {
+		t.Errorf("Expected X-Raw to be %q, got %q", "preserved  as-is", got)
+	}
+	b, err := ioutil.ReadAll(e.Body)
+	if err != nil {
+		t.Fatalf("failed to read signed entity body: %v", err)
+	}
+	if want := "Hi Taki,\r\n\r\nDid you get my message?\r\n"; string(b) != want {
+		t.Errorf("Expected signed body to be %q, got %q", want, string(b))
+	}
+}
+
+func TestSignVerify(t *testing.T) {
+	roots, id := newTestIdentities(t)
+	opts := &SignOptions{Certificate: id.cert, Signer: id.key, Time: testTime}
+
+	tests := []struct {
+		name   string
+		create func(w *bytes.Buffer) (io.WriteCloser, error)
+	}{
+		{"detached", func(w *bytes.Buffer) (io.WriteCloser, error) {
+			return CreateSignedWriter(w, testOuterHeader(), opts)
+		}},
+		{"opaque", func(w *bytes.Buffer) (io.WriteCloser, error) {
+			return CreateOpaqueSignedWriter(w, testOuterHeader(), opts)
+		}},
+	}
+	for _, test := range tests {
+		e := writeTestMessage(t, test.create)
+		if !IsSigned(e.Header) {
+			t.Fatalf("%v: expected message to be signed", test.name)
+		}
+
+		v, err := Verify(e, &VerifyOptions{Roots: roots, CurrentTime: testTime})
+		if err != nil {
+			t.Fatalf("%v: Verify() = %v", test.name, err)
+		}
+		if len(v.Signers) != 1 || !v.Signers[0].Certificate.Equal(id.cert) {
+			t.Errorf("%v: expected a single signer with the test certificate", test.name)
+		} else if !v.Signers[0].SigningTime.Equal(testTime) {
+			t.Errorf("%v: expected signing time %v, got %v", test.name, testTime, v.Signers[0].SigningTime)
+		}
+		checkInnerEntity(t, v.Entity)
+	}
+}
+
+func TestVerify_tampered(t *testing.T) {
+	roots, id := newTestIdentities(t)
+
+	var buf bytes.Buffer
+	w, err := CreateSignedWriter(&buf, testOuterHeader(), &SignOptions{Certificate: id.cert, Signer: id.key, Time: testTime})
+	if err != nil {
+		t.Fatalf("CreateSignedWriter() = %v", err)
+	}
+	w.Write([]byte(testInnerEntity))
+	w.Close()
+
+	tests := map[string]string{
+		"body":   strings.Replace(buf.String(), "Did you", "Didn't you", 1),
+		"header": strings.Replace(buf.String(), "X-Raw:   preserved", "X-Raw: preserved", 1),
+	}
+	for name, s := range tests {
+		e, err := message.Read(strings.NewReader(s))
+		if err != nil {
+			t.Fatalf("%v: message.Read() = %v", name, err)
+		}
+		if _, err := Verify(e, &VerifyOptions{Roots: roots, CurrentTime: testTime}); err == nil {
+			t.Errorf("%v: expected verification to fail", name)
+		}
+	}
+
+	// Untrusted signer
+	e, _ := message.Read(bytes.NewReader(buf.Bytes()))
+	if _, err := Verify(e, &VerifyOptions{Roots: x509.NewCertPool(), CurrentTime: testTime}); err == nil {
+		t.Errorf("Expected verification with an untrusted signer to fail")
+	}
+}
+
+func TestVerify_signingTime(t *testing.T) {
+	roots, id := newTestIdentities(t)
+
+	var buf bytes.Buffer
+	w, err := CreateSignedWriter(&buf, testOuterHeader(), &SignOptions{Certificate: id.cert, Signer: id.key, Time: testTime})
+	if err != nil {
+		t.Fatalf("CreateSignedWriter() = %v", err)
+	}
+	w.Write([]byte(testInnerEntity))
+	w.Close()
+
+	// The certificate has expired, the signing time must not be trusted by
+	// default
+	e, _ := message.Read(bytes.NewReader(buf.Bytes()))
+	if _, err := Verify(e, &VerifyOptions{Roots: roots}); err == nil {
+		t.Errorf("Expected verification with an expired certificate to fail")
+	}
+
+	e, _ = message.Read(bytes.NewReader(buf.Bytes()))
+	if _, err := Verify(e, &VerifyOptions{Roots: roots, UseSigningTime: true}); err != nil {
+		t.Errorf("Verify() with UseSigningTime = %v", err)
+	}
+}
+
+func TestVerifySignerInfo_contentType(t *testing.T) {
+	_, id := newTestIdentities(t)
+
+	content := []byte(testInnerEntity)
+	digest := sha256.Sum256(content)
+	s := &signer{cert: id.cert, key: id.key, time: testTime}
+	b, err := s.sign(digest[:], nil)
+	if err != nil {
+		t.Fatalf("sign() = %v", err)
+	}
+	sd, _, certs, err := parseSignedData(b)
+	if err != nil {
+		t.Fatalf("parseSignedData() = %v", err)
+	}
+
+	if _, _, err := verifySignerInfo(&sd.SignerInfos[0], certs, oidData, content); err != nil {
+		t.Errorf("verifySignerInfo() = %v", err)
+	}
+	if _, _, err := verifySignerInfo(&sd.SignerInfos[0], certs, oidEnvelopedData, content); err == nil {
+		t.Errorf("Expected verification with a mismatched content type to fail")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	_, id := newTestIdentities(t)
+	_, other := newTestIdentities(t)
+
+	e := writeTestMessage(t, func(w *bytes.Buffer) (io.WriteCloser, error) {
+		return CreateEncryptedWriter(w, testOuterHeader(), []*x509.Certificate{other.cert, id.cert})
+	})
+	if !IsEncrypted(e.Header) {
+		t.Fatalf("Expected message to be encrypted")
+	}
+	if subject := e.Header.Get("Subject"); subject != "Your Name" {
+		t.Errorf("Expected outer header to be preserved, got Subject %q", subject)
+	}
+
+	dec, err := Decrypt(e, id.cert, id.key)
+	if err != nil {
+		t.Fatalf("Decrypt() = %v", err)
+	}
+	checkInnerEntity(t, dec)
+}
+
+func TestDecrypt_wrongRecipient(t *testing.T) {
+	_, id := newTestIdentities(t)
+	_, other := newTestIdentities(t)
+
+	e := writeTestMessage(t, func(w *bytes.Buffer) (io.WriteCloser, error) {
+		return CreateEncryptedWriter(w, testOuterHeader(), []*x509.Certificate{other.cert})
+	})
+	if _, err := Decrypt(e, id.cert, id.key); err == nil {
+		t.Errorf("Expected decryption for another recipient to fail")
+	}
+}
+
+func TestBERToDER(t *testing.T) {
+	// SEQUENCE (indefinite) { OCTET STRING (constructed, indefinite) { "ab", "c" } }
+	ber := []byte{0x30, 0x80, 0x24, 0x80, 0x04, 0x02, 'a', 'b', 0x04, 0x01, 'c', 0x00, 0x00, 0x00, 0x00}
+	want := []byte{0x30, 0x05, 0x04, 0x03, 'a', 'b', 'c'}
+
+	der, err := berToDER(ber)
+	if err != nil {
+		t.Fatalf("berToDER() = %v", err)
+	}
+	if !bytes.Equal(der, want) {
+		t.Errorf("berToDER() = %x, want %x", der, want)
+	}
+
+	if _, err := berToDER(ber[:len(ber)-3]); err == nil {
+		t.Errorf("Expected an error for truncated BER")
+	}
+}
@@ -0,0 +1,346 @@
+package message
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message/internal/rfc2047"
+	"github.com/emersion/go-message/textproto"
+	"golang.org/x/net/idna"
+)
+
+// unstructuredFields are header fields whose value is free-form text.
+var unstructuredFields = map[string]bool{
+	"Subject":             true,
+	"Comments":            true,
+	"Content-Description": true,
+}
+
+// addressFields are header fields whose value is an address list.
+var addressFields = map[string]bool{
+	"From":          true,
+	"Sender":        true,
+	"Reply-To":      true,
+	"To":            true,
+	"Cc":            true,
+	"Bcc":           true,
+	"Resent-From":   true,
+	"Resent-Sender": true,
+	"Resent-To":     true,
+	"Resent-Cc":     true,
+	"Resent-Bcc":    true,
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteHeader calls fn for each header field. If fn returns true, the field
+// is replaced with the returned key and value. The order of the fields is
+// preserved, and fields that are not replaced keep their raw representation.
+func rewriteHeader(h *Header, fn func(k, v string) (string, string, bool)) {
+	type field struct {
+		k, v string
+		raw  []byte
+	}
+
+	var l []field
+	changed := false
+	fields := h.Fields()
+	for fields.Next() {
+		if k, v, ok := fn(fields.Key(), fields.Value()); ok {
+			l = append(l, field{k: k, v: v})
+			changed = true
+		} else if raw, err := fields.Raw(); err == nil && bytes.IndexByte(raw, ':') >= 0 {
+			l = append(l, field{raw: raw})
+		} else {
+			// Malformed fields can't be represented in a header built from
+			// scratch, drop them
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	var nh textproto.Header
+	for i := len(l) - 1; i >= 0; i-- {
+		if l[i].raw != nil {
+			nh.AddRaw(l[i].raw)
+		} else {
+			nh.Add(l[i].k, l[i].v)
+		}
+	}
+	h.Header = nh
+}
+
+// isPhraseChar reports whether c can appear in an unquoted phrase, as
+// extended by RFC 6532 section 3.2.
+func isPhraseChar(c rune) bool {
+	if c >= utf8.RuneSelf || c == ' ' {
+		return true
+	}
+	return c > ' ' && c <= '~' && !strings.ContainsRune(`()<>[]:;@\,."`, c)
+}
+
+// formatUTF8Address formats an address with a raw UTF-8 display name.
+func formatUTF8Address(addr *mail.Address) string {
+	// Let net/mail quote the local part if necessary
+	s := (&mail.Address{Address: addr.Address}).String()
+	if addr.Name == "" {
+		return s
+	}
+
+	name := addr.Name
+	for _, c := range name {
+		if !isPhraseChar(c) {
+			name = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+			break
+		}
+	}
+	return name + " " + s
+}
+
+func parseAddressList(v string) ([]*mail.Address, error) {
+	parser := mail.AddressParser{WordDecoder: &mime.WordDecoder{CharsetReader: CharsetReader}}
+	return parser.ParseList(v)
+}
+
+// toUTF8Header converts RFC 2047 encoded-words to raw UTF-8, as allowed by
+// RFC 6532.
+func toUTF8Header(h *Header) {
+	rewriteHeader(h, func(k, v string) (string, string, bool) {
+		if !strings.Contains(v, "=?") {
+			return k, v, false
+		}
+
+		switch {
+		case unstructuredFields[k]:
+			dec, err := decodeHeader(v)
+			if err != nil {
+				return k, v, false
+			}
+			return k, dec, true
+		case addressFields[k]:
+			addrs, err := parseAddressList(v)
+			if err != nil {
+				return k, v, false
+			}
+			l := make([]string, len(addrs))
+			for i, addr := range addrs {
+				l[i] = formatUTF8Address(addr)
+			}
+			return k, strings.Join(l, ", "), true
+		default:
+			return k, v, false
+		}
+	})
+
+	if t, params, err := h.ContentType(); err == nil && strings.EqualFold(t, "message/rfc822") {
+		h.SetContentType("message/global", params)
+	}
+}
+
+// toASCIIDomain converts an internationalized domain name to its ASCII form,
+// as defined in RFC 5891, with the UTS #46 mapping.
+func toASCIIDomain(domain string) (string, error) {
+	if isASCII(domain) {
+		return domain, nil
+	}
+	return idna.Lookup.ToASCII(domain)
+}
+
+// downgradeAddress formats an address in 7-bit-safe form, as defined in RFC
+// 6857 section 3.1.
+func downgradeAddress(addr *mail.Address) string {
+	local, domain := addr.Address, ""
+	if i := strings.LastIndexByte(addr.Address, '@'); i >= 0 {
+		local, domain = addr.Address[:i], addr.Address[i+1:]
+	}
+
+	asciiDomain, err := toASCIIDomain(domain)
+	if !isASCII(local) || err != nil {
+		// The address can't be represented: replace it with an empty group
+		// whose display name contains the original address
+		return rfc2047.EncodePhrase(formatUTF8Address(addr), 0) + " :;"
+	}
+
+	a := &mail.Address{Address: local}
+	if domain != "" {
+		a.Address += "@" + asciiDomain
+	}
+	if addr.Name == "" || isASCII(addr.Name) {
+		a.Name = addr.Name
+		return a.String()
+	}
+	return rfc2047.EncodePhrase(addr.Name, 0) + " " + a.String()
+}
+
+// DowngradeHeader converts header fields containing raw UTF-8, as allowed by
+// RFC 6532, to a 7-bit-safe form, as defined in RFC 6857 section 3.
+//
+// Unstructured fields and address display names are encoded with RFC 2047.
+// Domain names are converted to their ASCII form. Addresses with a non-ASCII
+// local part are replaced with an empty group whose display name contains
+// the original address. Content-Type and Content-Disposition parameters are
+// encoded with RFC 2231. Other fields containing raw UTF-8 are renamed with a
+// "Downgraded-" prefix and encoded with RFC 2047.
+func DowngradeHeader(h *Header) {
+	for _, k := range []string{"Content-Type", "Content-Disposition"} {
+		v := h.Get(k)
+		if isASCII(v) {
+			continue
+		}
+		t, params, err := parseHeaderWithParams(v)
+		if err != nil {
+			continue
+		}
+		h.Set(k, formatHeaderWithParams(t, params))
+	}
+
+	rewriteHeader(h, func(k, v string) (string, string, bool) {
+		if isASCII(v) {
+			return k, v, false
+		}
+
+		switch {
+		case unstructuredFields[k]:
+			return k, encodeHeader(k, v), true
+		case addressFields[k]:
+			addrs, err := parseAddressList(v)
+			if err != nil {
+				break
+			}
+			l := make([]string, len(addrs))
+			for i, addr := range addrs {
+				l[i] = downgradeAddress(addr)
+			}
+			return k, strings.Join(l, ", "), true
+		}
+		k = "Downgraded-" + k
+		return k, encodeHeader(k, v), true
+	})
+}
+
+// downgradePartHeader prepares the header of a non-multipart entity for
+// Downgrade.
+func downgradePartHeader(h *Header, e *Entity) {
+	DowngradeHeader(h)
+	if isEmbeddedMessage(e.mediaType) {
+		// RFC 2046 section 5.2.1: message/rfc822 entities can only use the
+		// 7bit, 8bit or binary encoding. The embedded message is downgraded
+		// too, so it's 7-bit.
+		_, params, _ := h.ContentType()
+		h.SetContentType("message/rfc822", params)
+		h.Set("Content-Transfer-Encoding", "7bit")
+		return
+	}
+	switch strings.ToLower(h.Get("Content-Transfer-Encoding")) {
+	case "8bit", "binary":
+		// Let the Writer pick a 7-bit encoding
+		h.Del("Content-Transfer-Encoding")
+	}
+}
+
+// Downgrade writes e to w in a 7-bit-safe form, for delivery to a server
+// which doesn't support the SMTPUTF8 extension. This is the downgrade
+// procedure defined in RFC 6857.
+//
+// Headers are converted with DowngradeHeader. Embedded message/global
+// messages are downgraded too and converted to message/rfc822. Parts using
+// the 8bit or binary transfer encoding are encoded again with a 7-bit
+// encoding, as with WriterOptions.AutoEncoding. Text is left in its original
+// charset, even if the charset is unknown. The entity is consumed, and its
+// body must not have been read.
+func Downgrade(w io.Writer, e *Entity) error {
+	h := e.Header.Copy()
+	downgradePartHeader(&h, e)
+	mw, err := CreateWriterWithOptions(w, h, &downgradeWriterOptions)
+	if err != nil {
+		return err
+	}
+	return downgradeAndClose(mw, e)
+}
+
+var downgradeWriterOptions = WriterOptions{AutoEncoding: true, noCharsetConversion: true}
+
+// downgradeEmbedded is like Downgrade, but for a message embedded in a
+// message/rfc822 or message/global entity: its header is written without
+// adding a MIME-Version field.
+func downgradeEmbedded(w io.Writer, e *Entity) error {
+	h := e.Header.Copy()
+	downgradePartHeader(&h, e)
+	mw, err := createWriter(&h, downgradeWriterOptions, func(h *Header) (io.Writer, error) {
+		if err := textproto.WriteHeader(w, h.Header); err != nil {
+			return nil, err
+		}
+		return w, nil
+	})
+	if err != nil {
+		return err
+	}
+	return downgradeAndClose(mw, e)
+}
+
+func downgradeAndClose(w *Writer, e *Entity) error {
+	if err := downgradeBody(w, e); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func downgradeBody(w *Writer, e *Entity) error {
+	if mr := e.MultipartReader(); mr != nil {
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			} else if err != nil && !IsUnknownCharset(err) {
+				return err
+			}
+
+			h := p.Header.Copy()
+			if p.MultipartReader() == nil {
+				downgradePartHeader(&h, p)
+			} else {
+				DowngradeHeader(&h)
+			}
+			pw, err := w.CreatePart(h)
+			if err != nil {
+				return err
+			}
+			if err := downgradeBody(pw, p); err != nil {
+				pw.Close()
+				return err
+			}
+			if err := pw.Close(); err != nil {
+				return err
+			}
+		}
+	}
+
+	embedded, err := e.EmbeddedMessage()
+	if err != nil && !IsUnknownCharset(err) && !IsUnknownEncoding(err) {
+		return err
+	} else if embedded != nil {
+		return downgradeEmbedded(w, embedded)
+	}
+
+	// The writer doesn't convert the charset, copy the body as-is
+	body, err := e.BodyWithOptions(&BodyOptions{NoCharsetDecoding: true})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, body)
+	return err
+}
@@ -0,0 +1,253 @@
+package message
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestToASCIIDomain(t *testing.T) {
+	tests := map[string]string{
+		"example.org": "example.org",
+		"exämple.org": "xn--exmple-cua.org",
+		"MÜNCHEN.de":  "xn--mnchen-3ya.de",
+		"bücher.例え":   "xn--bcher-kva.xn--r8jz45g",
+		"faß.example": "fass.example",
+		"ｅｘａｍｐｌｅ.org": "example.org",
+	}
+	for in, want := range tests {
+		if got, err := toASCIIDomain(in); err != nil {
+			t.Errorf("toASCIIDomain(%q) = %v", in, err)
+		} else if got != want {
+			t.Errorf("toASCIIDomain(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if _, err := toASCIIDomain("exä mple.org"); err == nil {
+		t.Errorf("Expected toASCIIDomain to fail with a disallowed character")
+	}
+}
+
+func TestWriter_utf8Header(t *testing.T) {
+	var h Header
+	h.SetText("Subject", "Café")
+	h.Set("From", "=?utf-8?q?Jos=C3=A9?= <josé@exämple.org>")
+	h.Set("To", "=?utf-8?q?Doe=2C_J=C3=B6hn?= <john@example.org>")
+	h.Set("Content-Type", "multipart/mixed")
+
+	var b bytes.Buffer
+	w, err := CreateWriterWithOptions(&b, h, &WriterOptions{UTF8Header: true})
+	if err != nil {
+		t.Fatalf("CreateWriterWithOptions() = %v", err)
+	}
+	var ph Header
+	ph.Set("Content-Type", "message/rfc822")
+	pw, err := w.CreatePart(ph)
+	if err != nil {
+		t.Fatalf("CreatePart() = %v", err)
+	}
+	io.WriteString(pw, "Subject: Hi\r\n\r\nHello\r\n")
+	pw.Close()
+	w.Close()
+
+	s := b.String()
+	for _, want := range []string{
+		"Subject: Café\r\n",
+		"From: José <josé@exämple.org>\r\n",
+		"To: \"Doe, Jöhn\" <john@example.org>\r\n",
+		"Content-Type: message/global\r\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("Expected output to contain %q, got:\n%v", want, s)
+		}
+	}
+	if strings.Contains(s, "=?") {
+		t.Errorf("Expected no encoded-word in output, got:\n%v", s)
+	}
+}
+
+func TestDowngradeHeader(t *testing.T) {
+	var h Header
+	h.Set("Subject", "Café")
+	h.Set("From", "José <jose@exämple.org>")
+	h.Set("To", "Jöhn <jöhn@example.org>, jane@example.org")
+	h.Set("Message-Id", "<café@example.org>")
+	h.Set("Content-Disposition", `attachment; filename="café.txt"`)
+	h.Set("Date", "Wed, 11 May 2016 14:31:59 +0000")
+
+	DowngradeHeader(&h)
+
+	want := map[string]string{
+		"Subject":               "=?utf-8?b?Q2Fmw6k=?=",
+		"From":                  "=?utf-8?b?Sm9zw6k=?= <jose@xn--exmple-cua.org>",
+		"To":                    "=?utf-8?b?SsO2aG4gPGrDtmhuQGV4YW1wbGUub3JnPg==?= :;, <jane@example.org>",
+		"Downgraded-Message-Id": "=?utf-8?q?<caf=C3=A9@example.org>?=",
+		"Content-Disposition":   "attachment; filename*=utf-8''caf%C3%A9.txt",
+		"Date":                  "Wed, 11 May 2016 14:31:59 +0000",
+	}
+	for k, v := range want {
+		if got := h.Get(k); got != v {
+			t.Errorf("Expected %v to be %q, got %q", k, v, got)
+		}
+	}
+	if h.Has("Message-Id") {
+		t.Errorf("Expected Message-Id to be renamed")
+	}
+
+	fields := h.Fields()
+	for fields.Next() {
+		if !isASCII(fields.Value()) {
+			t.Errorf("Expected %v to be ASCII, got %q", fields.Key(), fields.Value())
+		}
+	}
+}
+
+func TestDowngrade(t *testing.T) {
+	s := "Subject: Café\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Café au lait\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/global\r\n" +
+		"\r\n" +
+		"Subject: Thé\r\n" +
+		"\r\n" +
+		"Hello\r\n" +
+		"--outer--\r\n"
+
+	e, err := Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := Downgrade(&b, e); err != nil {
+		t.Fatalf("Downgrade() = %v", err)
+	}
+
+	out := b.String()
+	for i := 0; i < len(out); i++ {
+		if out[i] >= 0x80 {
+			t.Fatalf("Expected 7-bit output, got:\n%v", out)
+		}
+	}
+	for _, want := range []string{
+		"Subject: =?utf-8?b?Q2Fmw6k=?=\r\n",
+		"Content-Type: message/rfc822\r\n",
+		"Subject: =?utf-8?q?Th=C3=A9?=\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected output to contain %q, got:\n%v", want, out)
+		}
+	}
+
+	e, err = Read(&b)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	p, err := e.MultipartReader().NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	body, _ := ioutil.ReadAll(p.Body)
+	if string(body) != "Café au lait" {
+		t.Errorf("Expected decoded body %q, got %q", "Café au lait", body)
+	}
+}
+
+func TestDowngrade_passThrough(t *testing.T) {
+	s := "Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain; charset=x-unknown\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Caf\xe9\r\n" +
+		"--outer\r\n" +
+		"Content-Type: message/global\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"Subject: Thé\r\n" +
+		"\r\n" +
+		"Hello\n" +
+		strings.Repeat("long line ", 50) + "\r\n" +
+		"--outer--\r\n"
+
+	e, err := Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := Downgrade(&b, e); err != nil {
+		t.Fatalf("Downgrade() = %v", err)
+	}
+
+	e, err = Read(&b)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	mr := e.MultipartReader()
+
+	p, err := mr.NextPart()
+	if !IsUnknownCharset(err) {
+		t.Fatalf("NextPart() = %v, want an unknown charset error", err)
+	}
+	if body, _ := ioutil.ReadAll(p.Body); string(body) != "Caf\xe9" {
+		t.Errorf("Expected body %q, got %q", "Caf\xe9", body)
+	}
+
+	p, err = mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	if enc := p.Header.Get("Content-Transfer-Encoding"); enc != "7bit" {
+		t.Errorf("Expected message/rfc822 to use the 7bit encoding, got %q", enc)
+	}
+	embedded, err := p.EmbeddedMessage()
+	if err != nil {
+		t.Fatalf("EmbeddedMessage() = %v", err)
+	}
+	want := "Hello\r\n" + strings.Repeat("long line ", 50)
+	if body, _ := ioutil.ReadAll(embedded.Body); string(body) != want {
+		t.Errorf("Expected embedded body to be %q, got %q", want, body)
+	}
+}
+
+func TestDowngrade_embeddedUnknownCharset(t *testing.T) {
+	s := "Content-Type: message/global\r\n" +
+		"\r\n" +
+		"Subject: Thé\r\n" +
+		"Content-Type: text/plain; charset=x-unknown\r\n" +
+		"\r\n" +
+		"Caf\xe9\r\n"
+
+	e, err := Read(strings.NewReader(s))
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := Downgrade(&b, e); err != nil {
+		t.Fatalf("Downgrade() = %v", err)
+	}
+
+	out := b.String()
+	i := strings.Index(out, "\r\n\r\n")
+	if i < 0 {
+		t.Fatalf("Expected a header, got %q", out)
+	}
+	embedded := out[i+4:]
+	if strings.Contains(embedded, "Mime-Version") {
+		t.Errorf("Expected no MIME-Version field in the embedded message, got %q", embedded)
+	}
+	if !strings.Contains(embedded, "Subject: =?utf-8?q?Th=C3=A9?=\r\n") {
+		t.Errorf("Expected the embedded header to be downgraded, got %q", embedded)
+	}
+}
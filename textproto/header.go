@@ -3,14 +3,17 @@ package textproto
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/textproto"
 	"strings"
+	"unicode/utf8"
 )
 
 type headerField struct {
-	b []byte // Raw header field, including whitespace
+	b      []byte // Raw header field, including whitespace
+	bareLF bool   // b has been read with bare LF line endings
 
 	k string
 	v string
@@ -20,9 +23,20 @@ func newHeaderField(k, v string, b []byte) *headerField {
 	return &headerField{k: textproto.CanonicalMIMEHeaderKey(k), v: v, b: b}
 }
 
+// raw returns the raw header field. Unlike format, the original line endings
+// of fields read with ReadHeader are kept.
 func (f *headerField) raw() ([]byte, error) {
 	if f.b != nil {
 		return f.b, nil
+	}
+	return f.format(nil)
+}
+
+func (f *headerField) format(opts *WriteHeaderOptions) ([]byte, error) {
+	if f.bareLF {
+		return toCRLF(f.b), nil
+	} else if f.b != nil {
+		return f.b, nil
 	} else {
 		for pos, ch := range f.k {
 			// check if character is a printable US-ASCII except ':'
@@ -35,10 +49,32 @@ func (f *headerField) raw() ([]byte, error) {
 			return nil, fmt.Errorf("field value contains \\r\\n (at %v)", pos)
 		}
 
-		return []byte(formatHeaderField(f.k, f.v)), nil
+		s, err := formatHeaderField(f.k, f.v, opts)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(s), nil
 	}
 }
 
+// hasBareLF reports whether b contains a LF not preceded by a CR.
+func hasBareLF(b []byte) bool {
+	return bytes.Count(b, []byte{'\n'}) != bytes.Count(b, []byte("\r\n"))
+}
+
+// toCRLF converts the bare LF line endings of b to CRLF.
+func toCRLF(b []byte) []byte {
+	n := bytes.Count(b, []byte{'\n'}) - bytes.Count(b, []byte("\r\n"))
+	out := make([]byte, 0, len(b)+n)
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
 // A Header represents the key-value pairs in a message header.
 //
 // The header representation is idempotent: if the header can be read and
@@ -136,7 +172,8 @@ func (h *Header) Get(k string) string {
 // Raw gets the first raw header field associated with the given key.
 //
 // The returned bytes contain a complete field in the "Key: value" form,
-// including trailing CRLF.
+// including trailing CRLF. The fields read by ReadHeader keep their original
+// line endings, which can be bare LF.
 //
 // The returned slice should not be modified and becomes invalid when the
 // header is updated.
@@ -367,20 +404,47 @@ func (h *Header) FieldsByKey(k string) HeaderFields {
 	return &headerFieldsByKey{h, textproto.CanonicalMIMEHeaderKey(k), -1}
 }
 
-func readLineSlice(r *bufio.Reader, line []byte) ([]byte, error) {
+// errLineTooLong is returned by readLineSlice when a line exceeds the
+// maximum length.
+var errLineTooLong = errors.New("textproto: line too long")
+
+// readLineSlice reads a line from r and appends it to line, without the
+// trailing CRLF or LF. It also returns the number of bytes consumed from r.
+//
+// If max is positive and line grows longer than max, errLineTooLong is
+// returned.
+func readLineSlice(r *bufio.Reader, line []byte, max int) ([]byte, int, error) {
+	start := len(line)
+	n := 0
 	for {
-		l, more, err := r.ReadLine()
+		l, err := r.ReadSlice('\n')
+		n += len(l)
 		line = append(line, l...)
-		if err != nil {
-			return line, err
+		if err == bufio.ErrBufferFull {
+			if max > 0 && len(line) > max {
+				return line, n, errLineTooLong
+			}
+			continue
 		}
 
-		if !more {
-			break
+		// Strip the line ending
+		if i := len(line); i > start && line[i-1] == '\n' {
+			line = line[:i-1]
+			if i := len(line); i > start && line[i-1] == '\r' {
+				line = line[:i-1]
+			}
 		}
-	}
 
-	return line, nil
+		if max > 0 && len(line) > max {
+			return line, n, errLineTooLong
+		}
+		if err == io.EOF && n > 0 {
+			// The last line isn't terminated, the next read will return
+			// io.EOF
+			err = nil
+		}
+		return line, n, err
+	}
 }
 
 func isSpace(c byte) bool {
@@ -415,34 +479,57 @@ func hasContinuationLine(r *bufio.Reader) bool {
 	return isSpace(c)
 }
 
-func readContinuedLineSlice(r *bufio.Reader) ([]byte, error) {
+// appendLineEnding appends to line the line ending stripped by readLineSlice,
+// stripped being its length in bytes. A line without a line ending gets a
+// CRLF.
+func appendLineEnding(line []byte, stripped int) []byte {
+	if stripped == 1 {
+		return append(line, '\n')
+	}
+	return append(line, '\r', '\n')
+}
+
+// readContinuedLineSlice reads a possibly continued line from r. It also
+// returns the number of bytes consumed from r. The original line endings of
+// the line and its continuation lines are kept.
+//
+// If max is positive and the line (including continuation lines) grows
+// longer than max, errLineTooLong is returned.
+func readContinuedLineSlice(r *bufio.Reader, max int) ([]byte, int, error) {
 	// Read the first line. We preallocate slice that it enough
 	// for most fields.
-	line, err := readLineSlice(r, make([]byte, 0, 256))
+	line, n, err := readLineSlice(r, make([]byte, 0, 256), max)
 	if err == io.EOF && len(line) == 0 {
 		// Header without a body
-		return nil, nil
+		return nil, n, nil
+	} else if err == errLineTooLong {
+		return line, n, err
 	} else if err != nil {
-		return nil, err
+		return nil, n, err
 	}
 
 	if len(line) == 0 { // blank line - no continuation
-		return line, nil
+		return line, n, nil
 	}
 
-	line = append(line, '\r', '\n')
+	line = appendLineEnding(line, n-len(line))
 
 	// Read continuation lines.
 	for hasContinuationLine(r) {
-		line, err = readLineSlice(r, line)
-		if err != nil {
+		start := len(line)
+		var m int
+		line, m, err = readLineSlice(r, line, max)
+		n += m
+		if err == errLineTooLong {
+			return line, n, err
+		} else if err != nil {
 			break // bufio will keep err until next read.
 		}
 
-		line = append(line, '\r', '\n')
+		line = appendLineEnding(line, m-(len(line)-start))
 	}
 
-	return line, nil
+	return line, n, nil
 }
 
 func writeContinued(b *strings.Builder, l []byte) {
@@ -477,51 +564,186 @@ func trimAroundNewlines(v []byte) string {
 	return b.String()
 }
 
+// MalformedLinePolicy defines how malformed header lines are handled by
+// ReadHeaderWithOptions.
+type MalformedLinePolicy int
+
+const (
+	// MalformedLineError aborts reading the header with an error. This is the
+	// behavior of ReadHeader.
+	MalformedLineError MalformedLinePolicy = iota
+	// MalformedLineSkip drops malformed lines from the header.
+	MalformedLineSkip
+	// MalformedLinePreserve keeps malformed lines in the header as junk
+	// fields. Junk fields have an empty key, and are written back unchanged
+	// by WriteHeader.
+	MalformedLinePreserve
+)
+
+// ReadHeaderOptions contains options for ReadHeaderWithOptions.
+type ReadHeaderOptions struct {
+	// MalformedLines defines how malformed header lines are handled.
+	MalformedLines MalformedLinePolicy
+
+	// MaxBytes is the maximum size of the header in bytes, including the
+	// blank line terminating it. Zero means no limit.
+	MaxBytes int64
+	// MaxFields is the maximum number of header fields. Zero means no limit.
+	MaxFields int
+	// MaxLineLength is the maximum length of a header field in bytes,
+	// including continuation lines. Zero means no limit.
+	MaxLineLength int
+}
+
+// maxLineLength returns the maximum length of the next header field, given
+// the number of bytes already read.
+func (opts *ReadHeaderOptions) maxLineLength(offset int64) int {
+	max := opts.MaxLineLength
+	if opts.MaxBytes > 0 {
+		remaining := opts.MaxBytes - offset
+		if remaining < 1 {
+			remaining = 1
+		}
+		if max <= 0 || remaining < int64(max) {
+			max = int(remaining)
+		}
+	}
+	return max
+}
+
+// limitError returns the error to return when a header field is longer than
+// maxLineLength.
+func (opts *ReadHeaderOptions) limitError(n int) error {
+	if opts.MaxLineLength > 0 && n > opts.MaxLineLength {
+		return LimitExceededError{Limit: "header field length", Max: int64(opts.MaxLineLength)}
+	}
+	return LimitExceededError{Limit: "header size", Max: opts.MaxBytes}
+}
+
+// A HeaderWarning describes a malformed header line that has been skipped or
+// preserved by ReadHeaderWithOptions.
+type HeaderWarning struct {
+	// Offset is the position of the malformed line in bytes, relative to the
+	// start of the header.
+	Offset int64
+	// Line is the malformed line, including continuation lines and the
+	// trailing CRLF.
+	Line []byte
+	// Err describes the problem.
+	Err error
+}
+
 // ReadHeader reads a MIME header from r. The header is a sequence of possibly
 // continued Key: Value lines ending in a blank line.
 //
 // To avoid denial of service attacks, the provided bufio.Reader should be
 // reading from an io.LimitedReader or a similar Reader to bound the size of
-// headers.
+// headers. Alternatively, ReadHeaderWithOptions can be used to set limits.
 func ReadHeader(r *bufio.Reader) (Header, error) {
+	h, _, err := ReadHeaderWithOptions(r, nil)
+	return h, err
+}
+
+// ReadHeaderWithOptions is like ReadHeader, but with options. If opts is nil,
+// the defaults are used.
+//
+// Malformed lines that have been recovered from are reported as warnings. If
+// the header exceeds one of the limits set in opts, an error of type
+// LimitExceededError is returned.
+func ReadHeaderWithOptions(r *bufio.Reader, opts *ReadHeaderOptions) (Header, []HeaderWarning, error) {
+	if opts == nil {
+		opts = new(ReadHeaderOptions)
+	}
+
 	fs := make([]*headerField, 0, 32)
+	var warnings []HeaderWarning
+	var offset int64
+
+	// malformed handles a malformed line according to opts. It returns a
+	// non-nil error if reading should be aborted.
+	malformed := func(kv []byte, err error) error {
+		switch opts.MalformedLines {
+		case MalformedLineSkip:
+		case MalformedLinePreserve:
+			fs = append(fs, &headerField{v: trimAroundNewlines(kv), b: kv, bareLF: hasBareLF(kv)})
+		default:
+			return err
+		}
+		warnings = append(warnings, HeaderWarning{Offset: offset, Line: kv, Err: err})
+		return nil
+	}
 
 	// The first line cannot start with a leading space.
 	if buf, err := r.Peek(1); err == nil && isSpace(buf[0]) {
-		line, err := readLineSlice(r, nil)
-		if err != nil {
-			return newHeader(fs), err
+		if opts.MalformedLines == MalformedLineError {
+			line, _, err := readLineSlice(r, nil, opts.maxLineLength(0))
+			if err == errLineTooLong {
+				return newHeader(fs), warnings, opts.limitError(len(line))
+			} else if err != nil {
+				return newHeader(fs), warnings, err
+			}
+
+			return newHeader(fs), warnings, fmt.Errorf("message: malformed MIME header initial line: %v", string(line))
 		}
 
-		return newHeader(fs), fmt.Errorf("message: malformed MIME header initial line: %v", string(line))
+		// Consume the line and its continuation lines
+		kv, n, err := readContinuedLineSlice(r, opts.maxLineLength(0))
+		if err == errLineTooLong {
+			return newHeader(fs), warnings, opts.limitError(len(kv))
+		}
+		if len(kv) > 0 {
+			malformed(kv, fmt.Errorf("message: malformed MIME header initial line: %v", string(kv)))
+		}
+		offset += int64(n)
+		if err != nil {
+			return newHeader(fs), warnings, err
+		}
 	}
 
 	for {
-		var (
-			kv  []byte
-			err error
-		)
-		kv, err = readContinuedLineSlice(r)
+		kv, n, err := readContinuedLineSlice(r, opts.maxLineLength(offset))
+		if err == errLineTooLong {
+			return newHeader(fs), warnings, opts.limitError(len(kv))
+		}
+		if opts.MaxBytes > 0 && offset+int64(n) > opts.MaxBytes {
+			return newHeader(fs), warnings, LimitExceededError{Limit: "header size", Max: opts.MaxBytes}
+		}
 		if len(kv) == 0 {
-			return newHeader(fs), err
+			return newHeader(fs), warnings, err
+		}
+		if opts.MaxFields > 0 && len(fs) >= opts.MaxFields {
+			return newHeader(fs), warnings, LimitExceededError{Limit: "header fields", Max: int64(opts.MaxFields)}
 		}
 
 		// Key ends at first colon; should not have trailing spaces but they
 		// appear in the wild, violating specs, so we remove them if present.
 		i := bytes.IndexByte(kv, ':')
 		if i < 0 {
-			return newHeader(fs), fmt.Errorf("message: malformed MIME header line: %v", string(kv))
+			if malformedErr := malformed(kv, fmt.Errorf("message: malformed MIME header line: %v", string(kv))); malformedErr != nil {
+				return newHeader(fs), warnings, malformedErr
+			}
+			offset += int64(n)
+			continue
 		}
 
 		keyBytes := trim(kv[:i])
 
 		// Verify that there are no invalid characters in the header key.
 		// See RFC 5322 Section 2.2
+		validKey := true
 		for _, c := range keyBytes {
 			if !validHeaderKeyByte(c) {
-				return newHeader(fs), fmt.Errorf("message: malformed MIME header key: %v", string(keyBytes))
+				validKey = false
+				break
 			}
 		}
+		if !validKey {
+			if malformedErr := malformed(kv, fmt.Errorf("message: malformed MIME header key: %v", string(keyBytes))); malformedErr != nil {
+				return newHeader(fs), warnings, malformedErr
+			}
+			offset += int64(n)
+			continue
+		}
 
 		key := textproto.CanonicalMIMEHeaderKey(string(keyBytes))
 
@@ -529,6 +751,10 @@ func ReadHeader(r *bufio.Reader) (Header, error) {
 		// chars. We could return a an error here, but better to be liberal in
 		// what we accept, so if we get an empty key, skip it.
 		if key == "" {
+			if opts.MalformedLines != MalformedLineError {
+				malformed(kv, fmt.Errorf("message: empty MIME header key: %v", string(kv)))
+			}
+			offset += int64(n)
 			continue
 		}
 
@@ -536,111 +762,280 @@ func ReadHeader(r *bufio.Reader) (Header, error) {
 		v := kv[i:]
 
 		value := trimAroundNewlines(v)
-		fs = append(fs, newHeaderField(key, value, kv))
+		f := newHeaderField(key, value, kv)
+		f.bareLF = hasBareLF(kv)
+		fs = append(fs, f)
+		offset += int64(n)
 
 		if err != nil {
-			return newHeader(fs), err
+			return newHeader(fs), warnings, err
 		}
 	}
 }
 
-func foldLine(v string, maxlen int) (line, next string, ok bool) {
-	ok = true
+const (
+	preferredHeaderLen = 76
+	maxHeaderLen       = 998
+)
 
-	// We'll need to fold before maxlen
-	foldBefore := maxlen + 1
-	foldAt := len(v)
+// WriteHeaderOptions contains options for WriteHeaderWithOptions and
+// HeaderWriter.
+type WriteHeaderOptions struct {
+	// PreferredLineLength is the length of header field lines in bytes,
+	// excluding the trailing CRLF, above which header fields are folded. Zero
+	// means 76.
+	PreferredLineLength int
+	// MaxLineLength is the maximum length of header field lines in bytes,
+	// excluding the trailing CRLF. Zero means 998, the limit defined in RFC
+	// 5322 section 2.1.1.
+	MaxLineLength int
+	// Strict makes formatting fail with an error of type LimitExceededError
+	// when a header field can't be folded under MaxLineLength without
+	// inserting whitespace into its value. By default, whitespace is inserted.
+	Strict bool
+}
 
-	var folding string
-	if foldBefore > len(v) {
-		// We reached the end of the string
-		if v[len(v)-1] != '\n' {
-			// If there isn't already a trailing CRLF, insert one
-			folding = "\r\n"
-		}
-	} else {
-		// Find the closest whitespace before maxlen
-		foldAt = strings.LastIndexAny(v[:foldBefore], " \t\n")
+// lineLengths returns the preferred and maximum lengths of header field
+// lines.
+func (opts *WriteHeaderOptions) lineLengths() (preferred, max int) {
+	preferred, max = preferredHeaderLen, maxHeaderLen
+	if opts == nil {
+		return preferred, max
+	}
+	if opts.MaxLineLength > 0 {
+		max = opts.MaxLineLength
+	}
+	if opts.PreferredLineLength > 0 {
+		preferred = opts.PreferredLineLength
+	}
+	if preferred > max {
+		preferred = max
+	}
+	return preferred, max
+}
 
-		if foldAt == 0 {
-			// The whitespace we found was the previous folding WSP
-			foldAt = foldBefore - 1
-		} else if foldAt < 0 {
-			// We didn't find any whitespace, we have to insert one
-			foldAt = foldBefore - 2
-		}
+// A foldingPoint is a whitespace character in a header field value, before
+// which a CRLF can be inserted without changing the value.
+type foldingPoint struct {
+	i      int
+	nested bool // inside a quoted string or a comment
+}
 
-		switch v[foldAt] {
-		case ' ', '\t':
-			if v[foldAt-1] != '\n' {
-				folding = "\r\n" // The next char will be a WSP, don't need to insert one
-			}
-		case '\n':
-			folding = "" // There is already a CRLF, nothing to do
-		default:
-			// Another char, we need to insert CRLF + WSP. This will insert an
-			// extra space in the string, so this should be avoided if
-			// possible.
-			folding = "\r\n "
-			ok = false
+// foldingPoints returns the folding points of a header field value. Escaped
+// whitespace in quoted strings and comments isn't a folding point.
+func foldingPoints(v string) []foldingPoint {
+	var points []foldingPoint
+	var quoted, escaped bool
+	depth := 0 // comment nesting level
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\' && (quoted || depth > 0):
+			escaped = true
+		case c == '"' && depth == 0:
+			quoted = !quoted
+		case c == '(' && !quoted:
+			depth++
+		case c == ')' && !quoted && depth > 0:
+			depth--
+		case c == ' ' || c == '\t':
+			points = append(points, foldingPoint{i, quoted || depth > 0})
 		}
 	}
+	return points
+}
 
-	return v[:foldAt] + folding, v[foldAt:], ok
+// encodedWordLen returns the length of the RFC 2047 encoded-word at the start
+// of s, or -1 if s doesn't start with an encoded-word.
+func encodedWordLen(s string) int {
+	if !strings.HasPrefix(s, "=?") {
+		return -1
+	}
+	// Skip the charset and the encoding
+	i := 2
+	for n := 0; n < 2; n++ {
+		j := strings.IndexByte(s[i:], '?')
+		if j < 0 {
+			return -1
+		}
+		i += j + 1
+	}
+	j := strings.Index(s[i:], "?=")
+	if j < 0 {
+		return -1
+	}
+	return i + j + 2
 }
 
-const (
-	preferredHeaderLen = 76
-	maxHeaderLen       = 998
-)
+// splitPoint returns the index at which v is split when there is no folding
+// point in v[start+1:end+1]. It avoids splitting UTF-8 characters and
+// encoded-words.
+func splitPoint(v string, start, end int) int {
+	i := end
+	if i <= start {
+		i = start + 1
+	}
+	for i > start+1 && !utf8.RuneStart(v[i]) {
+		i--
+	}
+	if j := strings.LastIndex(v[start+1:i], "=?"); j >= 0 {
+		j += start + 1
+		if n := encodedWordLen(v[j:]); n > 0 && j+n > i {
+			i = j
+		}
+	}
+	return i
+}
 
 // formatHeaderField formats a header field, ensuring each line is no longer
-// than 76 characters. It tries to fold lines at whitespace characters if
-// possible. If the header contains a word longer than this limit, it will be
-// split.
-func formatHeaderField(k, v string) string {
-	s := k + ": "
+// than the preferred line length if possible, and no longer than the maximum
+// line length.
+//
+// Lines are folded at whitespace characters, preferably outside of quoted
+// strings and comments. If a line can't be folded under the preferred length,
+// the maximum length is used instead. If the value contains a word longer than
+// the maximum length, the word is split (without splitting encoded-words if
+// possible), unless opts.Strict is set.
+func formatHeaderField(k, v string, opts *WriteHeaderOptions) (string, error) {
+	preferred, max := opts.lineLengths()
+
+	var b strings.Builder
+	b.WriteString(k)
+	b.WriteString(": ")
+	used := b.Len() // length of the current line
 
 	if v == "" {
-		return s + "\r\n"
+		b.WriteString("\r\n")
+		return b.String(), nil
 	}
 
-	first := true
-	for len(v) > 0 {
-		// If this is the first line, substract the length of the key
-		keylen := 0
-		if first {
-			keylen = len(s)
+	// A continuation line can't contain only whitespace
+	last := strings.LastIndexFunc(v, func(r rune) bool {
+		return r != ' ' && r != '\t'
+	})
+	points := foldingPoints(v)
+
+	start := 0
+	for used+len(v)-start > preferred {
+		softEnd := start + preferred - used
+		hardEnd := start + max - used
+
+		// Continuation lines start with whitespace, skip it
+		first := start
+		if start > 0 {
+			first = len(v) - len(strings.TrimLeft(v[start:], " \t"))
 		}
 
-		// First try with a soft limit
-		l, next, ok := foldLine(v, preferredHeaderLen-keylen)
-		if !ok {
-			// Folding failed to preserve the original header field value. Try
-			// with a larger, hard limit.
-			l, next, _ = foldLine(v, maxHeaderLen-keylen)
+		fold, nestedFold, hardFold := -1, -1, -1
+		for _, p := range points {
+			if p.i <= first || p.i >= last {
+				continue
+			}
+			if p.i > hardEnd {
+				break
+			}
+			if p.i > softEnd {
+				hardFold = p.i
+			} else if p.nested {
+				nestedFold = p.i
+			} else {
+				fold = p.i
+			}
+		}
+		if fold < 0 {
+			fold = nestedFold
 		}
-		v = next
-		s += l
-		first = false
+		if fold < 0 {
+			// The line can't be folded under the preferred length, try with
+			// the maximum length
+			if used+len(v)-start <= max {
+				break
+			}
+			fold = hardFold
+		}
+
+		if fold >= 0 {
+			b.WriteString(v[start:fold])
+			b.WriteString("\r\n")
+			start, used = fold, 0
+			continue
+		}
+		if opts != nil && opts.Strict {
+			return "", LimitExceededError{Limit: "header line length", Max: int64(max)}
+		}
+
+		// Insert CRLF + WSP. This inserts an extra space in the value.
+		i := splitPoint(v, start, hardEnd-1)
+		b.WriteString(v[start:i])
+		b.WriteString("\r\n ")
+		start, used = i, 1
 	}
 
-	return s
+	b.WriteString(v[start:])
+	b.WriteString("\r\n")
+	return b.String(), nil
+}
+
+// A HeaderWriter writes a MIME header field by field. This avoids building a
+// Header when the header fields are streamed from another source.
+type HeaderWriter struct {
+	w    io.Writer
+	opts WriteHeaderOptions
+	n    int // number of header fields written
+}
+
+// NewHeaderWriter creates a new header writer writing to w. If opts is nil,
+// the defaults are used.
+func NewHeaderWriter(w io.Writer, opts *WriteHeaderOptions) *HeaderWriter {
+	hw := &HeaderWriter{w: w}
+	if opts != nil {
+		hw.opts = *opts
+	}
+	return hw
+}
+
+func (hw *HeaderWriter) writeField(f *headerField) error {
+	hw.n++
+	rawField, err := f.format(&hw.opts)
+	if err != nil {
+		return fmt.Errorf("failed to write header field #%v (%q): %w", hw.n, f.k, err)
+	}
+	_, err = hw.w.Write(rawField)
+	return err
+}
+
+// WriteField formats and writes a header field. Long lines are folded
+// according to the HeaderWriter options.
+func (hw *HeaderWriter) WriteField(k, v string) error {
+	return hw.writeField(newHeaderField(k, v, nil))
+}
+
+// Close writes the blank line terminating the header. It doesn't close the
+// underlying io.Writer.
+func (hw *HeaderWriter) Close() error {
+	_, err := hw.w.Write([]byte{'\r', '\n'})
+	return err
 }
 
 // WriteHeader writes a MIME header to w.
 func WriteHeader(w io.Writer, h Header) error {
+	return WriteHeaderWithOptions(w, h, nil)
+}
+
+// WriteHeaderWithOptions is like WriteHeader, but with options. If opts is
+// nil, the defaults are used.
+//
+// Options only apply to header fields which have been added with Add or Set.
+// Header fields read with ReadHeader or added with AddRaw are written
+// unchanged, except bare LF line endings of fields read with ReadHeader which
+// are converted to CRLF.
+func WriteHeaderWithOptions(w io.Writer, h Header, opts *WriteHeaderOptions) error {
+	hw := NewHeaderWriter(w, opts)
 	for i := len(h.l) - 1; i >= 0; i-- {
-		f := h.l[i]
-		if rawField, err := f.raw(); err == nil {
-			if _, err := w.Write(rawField); err != nil {
-				return err
-			}
-		} else {
-			return fmt.Errorf("failed to write header field #%v (%q): %w", len(h.l)-i, f.k, err)
+		if err := hw.writeField(h.l[i]); err != nil {
+			return err
 		}
 	}
-
-	_, err := w.Write([]byte{'\r', '\n'})
-	return err
+	return hw.Close()
 }
@@ -3,6 +3,7 @@ package textproto
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"reflect"
 	"strings"
@@ -518,3 +519,299 @@ func TestWriteHeader_failed_multiple(t *testing.T) {
 		}
 	}
 }
+
+var writeHeaderWithOptionsTests = []struct {
+	name      string
+	opts      WriteHeaderOptions
+	k, v      string
+	formatted string
+}{
+	{
+		name:      "preferred line length",
+		opts:      WriteHeaderOptions{PreferredLineLength: 20},
+		k:         "Subject",
+		v:         "one two three four five six",
+		formatted: "Subject: one two\r\n three four five six\r\n",
+	},
+	{
+		name:      "comment",
+		opts:      WriteHeaderOptions{PreferredLineLength: 30},
+		k:         "To",
+		v:         "<taki@example.org> (Taki Tachibana)",
+		formatted: "To: <taki@example.org>\r\n (Taki Tachibana)\r\n",
+	},
+	{
+		name:      "quoted string",
+		opts:      WriteHeaderOptions{PreferredLineLength: 30},
+		k:         "To",
+		v:         "<taki@example.org> \"Taki\\ Tachibana\"",
+		formatted: "To: <taki@example.org>\r\n \"Taki\\ Tachibana\"\r\n",
+	},
+	{
+		name:      "long comment",
+		opts:      WriteHeaderOptions{PreferredLineLength: 20},
+		k:         "To",
+		v:         "(a comment that is quite long) x",
+		formatted: "To: (a comment that\r\n is quite long) x\r\n",
+	},
+	{
+		name:      "max line length",
+		opts:      WriteHeaderOptions{MaxLineLength: 30},
+		k:         "Subject",
+		v:         "abcdefgh=?utf-8?q?caf=C3=A9?=",
+		formatted: "Subject: abcdefgh\r\n =?utf-8?q?caf=C3=A9?=\r\n",
+	},
+}
+
+func TestWriteHeaderWithOptions(t *testing.T) {
+	for _, test := range writeHeaderWithOptionsTests {
+		var h Header
+		h.Add(test.k, test.v)
+
+		var b bytes.Buffer
+		if err := WriteHeaderWithOptions(&b, h, &test.opts); err != nil {
+			t.Fatalf("%v: WriteHeaderWithOptions() returned error: %v", test.name, err)
+		}
+		if b.String() != test.formatted+"\r\n" {
+			t.Errorf("%v: Expected formatted header to be \n%v\n but got \n%v", test.name, test.formatted+"\r\n", b.String())
+		}
+	}
+}
+
+func TestWriteHeaderWithOptions_strict(t *testing.T) {
+	var h Header
+	h.Add("Subject", "InCaseOfVeryVeryVeryLongString")
+
+	opts := &WriteHeaderOptions{MaxLineLength: 30, Strict: true}
+	var b bytes.Buffer
+	err := WriteHeaderWithOptions(&b, h, opts)
+	var limitErr LimitExceededError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("Expected a LimitExceededError, got %v", err)
+	}
+	if limitErr.Max != 30 {
+		t.Errorf("Expected the limit to be 30, got %v", limitErr.Max)
+	}
+
+	opts.Strict = false
+	b.Reset()
+	if err := WriteHeaderWithOptions(&b, h, opts); err != nil {
+		t.Fatalf("WriteHeaderWithOptions() returned error: %v", err)
+	}
+	want := "Subject: InCaseOfVeryVeryVery\r\n LongString\r\n\r\n"
+	if b.String() != want {
+		t.Errorf("Expected formatted header to be \n%v\n but got \n%v", want, b.String())
+	}
+}
+
+func TestHeaderWriter(t *testing.T) {
+	var b bytes.Buffer
+	hw := NewHeaderWriter(&b, &WriteHeaderOptions{PreferredLineLength: 25})
+	if err := hw.WriteField("from", "Mitsuha Miyamizu <mitsuha.miyamizu@example.com>"); err != nil {
+		t.Fatalf("WriteField() returned error: %v", err)
+	}
+	if err := hw.WriteField("Subject", "Hi"); err != nil {
+		t.Fatalf("WriteField() returned error: %v", err)
+	}
+	if err := hw.WriteField("Bad Key", "Value"); err == nil {
+		t.Errorf("Expected an error when writing an invalid header field")
+	}
+	if err := hw.Close(); err != nil {
+		t.Fatalf("Close() returned error: %v", err)
+	}
+
+	want := "From: Mitsuha Miyamizu\r\n <mitsuha.miyamizu@example.com>\r\n" +
+		"Subject: Hi\r\n" +
+		"\r\n"
+	if b.String() != want {
+		t.Errorf("HeaderWriter wrote invalid data: got \n%v\n but want \n%v", b.String(), want)
+	}
+}
+
+const testMalformedHeader = " leading continuation\r\n" +
+	"Received: from example.com by example.org\r\n" +
+	"this line has no colon\r\n" +
+	" and is continued\r\n" +
+	"Bad Key: value\r\n" +
+	"To: Taki Tachibana <taki.tachibana@example.org>\r\n" +
+	"\r\n"
+
+func TestReadHeaderWithOptions_malformed(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(testMalformedHeader))
+	_, _, err := ReadHeaderWithOptions(r, nil)
+	if err == nil {
+		t.Errorf("Expected an error when reading a malformed header")
+	}
+
+	wantWarnings := []HeaderWarning{
+		{Offset: 0, Line: []byte(" leading continuation\r\n")},
+		{Offset: 66, Line: []byte("this line has no colon\r\n and is continued\r\n")},
+		{Offset: 109, Line: []byte("Bad Key: value\r\n")},
+	}
+
+	tests := []struct {
+		policy MalformedLinePolicy
+		fields []string
+	}{
+		{
+			policy: MalformedLineSkip,
+			fields: []string{
+				"Received: from example.com by example.org",
+				"To: Taki Tachibana <taki.tachibana@example.org>",
+			},
+		},
+		{
+			policy: MalformedLinePreserve,
+			fields: []string{
+				": leading continuation",
+				"Received: from example.com by example.org",
+				": this line has no colon and is continued",
+				": Bad Key: value",
+				"To: Taki Tachibana <taki.tachibana@example.org>",
+			},
+		},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(testMalformedHeader))
+		h, warnings, err := ReadHeaderWithOptions(r, &ReadHeaderOptions{MalformedLines: test.policy})
+		if err != nil {
+			t.Fatalf("ReadHeaderWithOptions() returned error: %v", err)
+		}
+
+		if l := collectHeaderFields(h.Fields()); !reflect.DeepEqual(l, test.fields) {
+			t.Errorf("Fields() reported incorrect values: got \n%#v\n but want \n%#v", l, test.fields)
+		}
+
+		if len(warnings) != len(wantWarnings) {
+			t.Fatalf("Expected %v warnings, got %v", len(wantWarnings), len(warnings))
+		}
+		for i, w := range warnings {
+			want := wantWarnings[i]
+			if w.Offset != want.Offset || !bytes.Equal(w.Line, want.Line) || w.Err == nil {
+				t.Errorf("Expected warning #%v to be at offset %v with line %q, got offset %v with line %q and error %v", i, want.Offset, want.Line, w.Offset, w.Line, w.Err)
+			}
+		}
+
+		if test.policy == MalformedLinePreserve {
+			var b bytes.Buffer
+			if err := WriteHeader(&b, h); err != nil {
+				t.Fatalf("WriteHeader() returned error: %v", err)
+			}
+			if s := b.String(); s != testMalformedHeader {
+				t.Errorf("Expected header to be written back unchanged, got \n%v", s)
+			}
+		}
+	}
+}
+
+func TestReadHeaderWithOptions_bareLF(t *testing.T) {
+	raw := "Subject: Hello\n" +
+		"Received: from example.com\n by example.org\r\n" +
+		"\n"
+	r := bufio.NewReader(strings.NewReader(raw))
+	h, _, err := ReadHeaderWithOptions(r, nil)
+	if err != nil {
+		t.Fatalf("ReadHeaderWithOptions() returned error: %v", err)
+	}
+
+	if b, err := h.Raw("Subject"); err != nil {
+		t.Errorf("Raw(Subject) returned error: %v", err)
+	} else if want := "Subject: Hello\n"; string(b) != want {
+		t.Errorf("Raw(Subject) = %q, want %q", b, want)
+	}
+	if b, err := h.Raw("Received"); err != nil {
+		t.Errorf("Raw(Received) returned error: %v", err)
+	} else if want := "Received: from example.com\n by example.org\r\n"; string(b) != want {
+		t.Errorf("Raw(Received) = %q, want %q", b, want)
+	}
+	if got, want := h.Get("Received"), "from example.com by example.org"; got != want {
+		t.Errorf("Get(Received) = %q, want %q", got, want)
+	}
+
+	var b bytes.Buffer
+	if err := WriteHeader(&b, h); err != nil {
+		t.Fatalf("WriteHeader() returned error: %v", err)
+	}
+	want := "Subject: Hello\r\n" +
+		"Received: from example.com\r\n by example.org\r\n" +
+		"\r\n"
+	if b.String() != want {
+		t.Errorf("WriteHeader() wrote \n%q\n but want \n%q", b.String(), want)
+	}
+}
+
+func TestReadHeaderWithOptions_emptyKey(t *testing.T) {
+	raw := "Subject: Hello\r\n" +
+		": no key\r\n" +
+		"\r\n"
+
+	tests := []struct {
+		policy MalformedLinePolicy
+		fields []string
+	}{
+		{MalformedLineError, []string{"Subject: Hello"}},
+		{MalformedLineSkip, []string{"Subject: Hello"}},
+		{MalformedLinePreserve, []string{"Subject: Hello", ": : no key"}},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(raw))
+		h, warnings, err := ReadHeaderWithOptions(r, &ReadHeaderOptions{MalformedLines: test.policy})
+		if err != nil {
+			t.Fatalf("ReadHeaderWithOptions() returned error: %v", err)
+		}
+
+		if l := collectHeaderFields(h.Fields()); !reflect.DeepEqual(l, test.fields) {
+			t.Errorf("Fields() reported incorrect values: got \n%#v\n but want \n%#v", l, test.fields)
+		}
+		if test.policy == MalformedLineError {
+			// Empty keys are skipped silently by default
+			if len(warnings) != 0 {
+				t.Errorf("Expected no warning for the empty key, got %v", warnings)
+			}
+		} else if len(warnings) != 1 || string(warnings[0].Line) != ": no key\r\n" {
+			t.Errorf("Expected a warning for the empty key, got %v", warnings)
+		}
+	}
+}
+
+func TestReadHeader_emptyKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(": foo\r\nSubject: x\r\n\r\n"))
+	h, err := ReadHeader(r)
+	if err != nil {
+		t.Fatalf("ReadHeader() returned error: %v", err)
+	}
+	if l, want := collectHeaderFields(h.Fields()), []string{"Subject: x"}; !reflect.DeepEqual(l, want) {
+		t.Errorf("Fields() reported incorrect values: got \n%#v\n but want \n%#v", l, want)
+	}
+}
+
+func TestReadHeaderWithOptions_limits(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  ReadHeaderOptions
+		limit string
+	}{
+		{"bytes", ReadHeaderOptions{MaxBytes: 100}, "header size"},
+		{"fields", ReadHeaderOptions{MaxFields: 3}, "header fields"},
+		{"line length", ReadHeaderOptions{MaxLineLength: 40}, "header field length"},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(testHeader))
+		_, _, err := ReadHeaderWithOptions(r, &test.opts)
+		if limitErr, ok := err.(LimitExceededError); !ok {
+			t.Errorf("%v: expected a LimitExceededError, got: %v", test.name, err)
+		} else if limitErr.Limit != test.limit {
+			t.Errorf("%v: expected limit %q to be exceeded, got %q", test.name, test.limit, limitErr.Limit)
+		}
+	}
+
+	opts := ReadHeaderOptions{
+		MaxBytes:      int64(len(testHeader)),
+		MaxFields:     4,
+		MaxLineLength: 56,
+	}
+	r := bufio.NewReader(strings.NewReader(testHeader))
+	if _, _, err := ReadHeaderWithOptions(r, &opts); err != nil {
+		t.Errorf("Expected no error when the header is within limits, got: %v", err)
+	}
+}
@@ -37,6 +37,8 @@ type Part struct {
 	total   int64 // total data bytes read already
 	err     error // error to return when n == 0
 	readErr error // read error observed from mr.bufReader
+
+	warnings []MultipartWarning
 }
 
 // NewMultipartReader creates a new multipart reader reading from r using the
@@ -46,14 +48,81 @@ type Part struct {
 // the message's "Content-Type" header. Use mime.ParseMediaType to
 // parse such headers.
 func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
+	return NewMultipartReaderWithOptions(r, boundary, nil)
+}
+
+// MultipartReaderOptions contains options for NewMultipartReaderWithOptions.
+type MultipartReaderOptions struct {
+	// Header contains options used to read part headers. If nil, the defaults
+	// are used.
+	Header *ReadHeaderOptions
+	// MaxParts is the maximum number of parts. Zero means no limit.
+	MaxParts int
+	// MaxPreambleBytes is the maximum size of the preamble in bytes. If
+	// positive, NextPart fails with an error of type LimitExceededError when
+	// the preamble is larger. Zero means the preamble is truncated to 64 KiB,
+	// see MultipartReader.PreambleTruncated. A negative value means no limit.
+	MaxPreambleBytes int64
+	// MaxEpilogueBytes is the maximum size of the epilogue read by
+	// MultipartReader.Epilogue, in bytes. Zero means 64 KiB, a negative value
+	// means no limit.
+	MaxEpilogueBytes int64
+	// Lenient enables recovery from malformed multipart structures: a missing
+	// final boundary is treated as the end of the multipart body, stray text
+	// and junk after boundaries are ignored, and CRLF and bare LF line endings
+	// can be mixed. Each recovery is recorded as a warning, see
+	// MultipartReader.Warnings. A part truncated by the end of the multipart
+	// body is reported by Part.Warnings too.
+	Lenient bool
+}
+
+const maxPreambleBytes = 64 << 10 // 64 KiB
+
+func (opts *MultipartReaderOptions) maxEpilogueBytes() int64 {
+	if opts.MaxEpilogueBytes == 0 {
+		return maxPreambleBytes
+	}
+	return opts.MaxEpilogueBytes
+}
+
+// A MultipartWarning describes a malformed multipart structure that has been
+// recovered from by a lenient MultipartReader.
+type MultipartWarning struct {
+	// Offset is the position of the problem in bytes, relative to the start
+	// of the multipart body.
+	Offset int64
+	// Line is the malformed line, if any.
+	Line []byte
+	// Err describes the problem.
+	Err error
+}
+
+// NewMultipartReaderWithOptions is like NewMultipartReader, but with options.
+// If opts is nil, the defaults are used.
+//
+// If the multipart body exceeds one of the limits set in opts, NextPart
+// returns an error of type LimitExceededError.
+func NewMultipartReaderWithOptions(r io.Reader, boundary string, opts *MultipartReaderOptions) *MultipartReader {
+	if opts == nil {
+		opts = new(MultipartReaderOptions)
+	}
 	b := []byte("\r\n--" + boundary + "--")
-	return &MultipartReader{
-		bufReader:        bufio.NewReaderSize(&stickyErrorReader{r: r}, peekBufferSize),
+	src := &stickyErrorReader{r: r}
+	mr := &MultipartReader{
+		opts:             *opts,
+		src:              src,
+		bufReader:        bufio.NewReaderSize(src, peekBufferSize),
 		nl:               b[:2],
 		nlDashBoundary:   b[:len(b)-2],
 		dashBoundaryDash: b[2:],
 		dashBoundary:     b[2 : len(b)-2],
 	}
+	if opts.Lenient {
+		// Look for "\n--boundary", partReader takes care of the "\r"
+		mr.nl = b[1:2]
+		mr.nlDashBoundary = b[1 : len(b)-2]
+	}
+	return mr
 }
 
 // stickyErrorReader is an io.Reader which never calls Read on its
@@ -64,6 +133,7 @@ func NewMultipartReader(r io.Reader, boundary string) *MultipartReader {
 type stickyErrorReader struct {
 	r   io.Reader
 	err error
+	n   int64 // number of bytes read
 }
 
 func (r *stickyErrorReader) Read(p []byte) (n int, _ error) {
@@ -71,6 +141,7 @@ func (r *stickyErrorReader) Read(p []byte) (n int, _ error) {
 		return 0, r.err
 	}
 	n, r.err = r.r.Read(p)
+	r.n += int64(n)
 	return n, r.err
 }
 
@@ -84,7 +155,7 @@ func newPart(mr *MultipartReader) (*Part, error) {
 }
 
 func (bp *Part) populateHeaders() error {
-	header, err := ReadHeader(bp.mr.bufReader)
+	header, _, err := ReadHeaderWithOptions(bp.mr.bufReader, bp.mr.opts.Header)
 	if err == nil {
 		bp.Header = header
 	}
@@ -112,10 +183,27 @@ func (pr partReader) Read(d []byte) (int, error) {
 	for p.n == 0 && p.err == nil {
 		peek, _ := br.Peek(br.Buffered())
 		p.n, p.err = scanUntilBoundary(peek, p.mr.dashBoundary, p.mr.nlDashBoundary, p.total, p.readErr)
+		if p.mr.opts.Lenient && p.n > 0 && peek[p.n-1] == '\r' {
+			// The "\r" may belong to a "\r\n--boundary" delimiter
+			if p.n < len(peek) && peek[p.n] == '\n' || p.n == len(peek) && p.readErr == nil {
+				p.n--
+			}
+			if p.n == 0 && p.err == nil && p.readErr != nil {
+				// Truncated delimiter
+				p.err = p.readErr
+			}
+		}
+		if p.mr.opts.Lenient && p.err == io.EOF && !p.atBoundary(peek[p.n:]) {
+			// Report the truncation before the last bytes of the part are
+			// returned
+			p.warn(p.mr.src.n, errors.New("multipart: missing final boundary"))
+		}
 		if p.n == 0 && p.err == nil {
 			// Force buffered I/O to read more into buffer.
 			_, p.readErr = br.Peek(len(peek) + 1)
-			if p.readErr == io.EOF {
+			if p.readErr == io.EOF && !p.mr.opts.Lenient {
+				// In lenient mode, the missing final boundary is reported by
+				// NextPart
 				p.readErr = io.ErrUnexpectedEOF
 			}
 		}
@@ -217,6 +305,31 @@ func matchAfterPrefix(buf, prefix []byte, readErr error) int {
 	return -1
 }
 
+// atBoundary reports whether buf, the data following the part body, starts
+// with a boundary delimiter.
+func (p *Part) atBoundary(buf []byte) bool {
+	if p.total == 0 && p.n == 0 && bytes.HasPrefix(buf, p.mr.dashBoundary) {
+		return true
+	}
+	buf = bytes.TrimPrefix(buf, []byte("\r"))
+	return bytes.HasPrefix(buf, p.mr.nlDashBoundary)
+}
+
+func (p *Part) warn(offset int64, err error) {
+	w := MultipartWarning{Offset: offset, Err: err}
+	p.warnings = append(p.warnings, w)
+	p.mr.warnings = append(p.mr.warnings, w)
+	p.mr.truncated = true
+}
+
+// Warnings returns the malformed multipart structures that have been
+// recovered from while reading the part. If the part is truncated by the end
+// of the multipart body, a warning is recorded before Read returns io.EOF. It
+// always returns nil if MultipartReaderOptions.Lenient isn't set.
+func (p *Part) Warnings() []MultipartWarning {
+	return p.warnings
+}
+
 func (p *Part) Close() error {
 	io.Copy(ioutil.Discard, p)
 	return nil
@@ -226,10 +339,20 @@ func (p *Part) Close() error {
 // MultipartReader's underlying parser consumes its input as needed. Seeking
 // isn't supported.
 type MultipartReader struct {
+	src       *stickyErrorReader
 	bufReader *bufio.Reader
-
-	currentPart *Part
-	partsRead   int
+	opts      MultipartReaderOptions
+	warnings  []MultipartWarning
+	truncated bool // the missing final boundary has been reported by a part
+
+	currentPart       *Part
+	partsRead         int
+	preamble          []byte
+	preambleTruncated bool
+	done              bool // the final boundary has been read
+	epilogue          []byte
+	epilogueErr       error
+	epilogueOK        bool // the epilogue has been read
 
 	nl               []byte // "\r\n" or "\n" (set after seeing first boundary line)
 	nlDashBoundary   []byte // nl + "--boundary"
@@ -246,16 +369,51 @@ func (r *MultipartReader) NextPart() (*Part, error) {
 	if string(r.dashBoundary) == "--" {
 		return nil, fmt.Errorf("multipart: boundary is empty")
 	}
+	if r.done {
+		return nil, io.EOF
+	}
 	expectNewPart := false
+	midLine := false // the previous line was too long, and has been split
 	for {
+		offset := r.offset()
 		line, err := r.bufReader.ReadSlice('\n')
 
+		if r.opts.Lenient {
+			if err == bufio.ErrBufferFull || midLine {
+				// Long lines can't be boundary delimiter lines
+				midLine = err == bufio.ErrBufferFull
+				if r.partsRead == 0 {
+					if err := r.appendPreamble(line); err != nil {
+						return nil, err
+					}
+				} else {
+					r.warn(offset, line, errors.New("multipart: unexpected line between parts"))
+				}
+				continue
+			}
+			if err == io.EOF && !r.isFinalBoundary(line) {
+				if len(line) > 0 && r.partsRead == 0 {
+					if err := r.appendPreamble(line); err != nil {
+						return nil, err
+					}
+				} else if len(line) > 0 && !bytes.HasPrefix(line, r.dashBoundary) {
+					r.warn(offset, line, errors.New("multipart: unexpected line between parts"))
+				}
+				if !r.truncated {
+					r.warn(offset+int64(len(line)), nil, errors.New("multipart: missing final boundary"))
+				}
+				r.done = true
+				return nil, io.EOF
+			}
+		}
+
 		if err == io.EOF && r.isFinalBoundary(line) {
 			// If the buffer ends in "--boundary--" without the
 			// trailing "\r\n", ReadSlice will return an error
 			// (since it's missing the '\n'), but this is a valid
 			// multipart EOF so we need to return io.EOF instead of
 			// a fmt-wrapped one.
+			r.done = true
 			return nil, io.EOF
 		}
 		if err != nil {
@@ -263,26 +421,49 @@ func (r *MultipartReader) NextPart() (*Part, error) {
 		}
 
 		if r.isBoundaryDelimiterLine(line) {
-			r.partsRead++
-			bp, err := newPart(r)
-			if err != nil {
-				return nil, err
-			}
-			r.currentPart = bp
-			return bp, nil
+			return r.startPart()
 		}
 
 		if r.isFinalBoundary(line) {
 			// Expected EOF
+			r.done = true
 			return nil, io.EOF
 		}
 
+		if r.opts.Lenient {
+			// A boundary followed by junk ends the previous part, see
+			// matchAfterPrefix
+			junkBoundary := bytes.HasPrefix(line, r.dashBoundary) && matchAfterPrefix(line, r.dashBoundary, io.EOF) == +1
+			if r.partsRead == 0 && !junkBoundary {
+				if err := r.appendPreamble(line); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if junkBoundary {
+				r.warn(offset, line, errors.New("multipart: junk after boundary"))
+				if bytes.HasPrefix(line, r.dashBoundaryDash) {
+					r.done = true
+					return nil, io.EOF
+				}
+				return r.startPart()
+			}
+			if expectNewPart || !isLineEnding(line) {
+				r.warn(offset, line, errors.New("multipart: unexpected line between parts"))
+			}
+			expectNewPart = true
+			continue
+		}
+
 		if expectNewPart {
 			return nil, fmt.Errorf("multipart: expecting a new Part; got line %q", string(line))
 		}
 
 		if r.partsRead == 0 {
-			// skip line
+			// Preamble line
+			if err := r.appendPreamble(line); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -299,6 +480,104 @@ func (r *MultipartReader) NextPart() (*Part, error) {
 	}
 }
 
+// startPart reads the header of the part following a boundary delimiter line.
+func (r *MultipartReader) startPart() (*Part, error) {
+	if r.opts.MaxParts > 0 && r.partsRead >= r.opts.MaxParts {
+		return nil, LimitExceededError{Limit: "multipart parts", Max: int64(r.opts.MaxParts)}
+	}
+	r.partsRead++
+	bp, err := newPart(r)
+	if err != nil {
+		return nil, err
+	}
+	r.currentPart = bp
+	return bp, nil
+}
+
+// appendPreamble appends line to the preamble, according to
+// MultipartReaderOptions.MaxPreambleBytes.
+func (r *MultipartReader) appendPreamble(line []byte) error {
+	max := r.opts.MaxPreambleBytes
+	if max > 0 && int64(len(r.preamble)+len(line)) > max {
+		return LimitExceededError{Limit: "multipart preamble", Max: max}
+	}
+	if max == 0 && len(r.preamble)+len(line) > maxPreambleBytes {
+		line = line[:maxPreambleBytes-len(r.preamble)]
+		r.preambleTruncated = true
+	}
+	r.preamble = append(r.preamble, line...)
+	return nil
+}
+
+// offset returns the current position in the multipart body.
+func (r *MultipartReader) offset() int64 {
+	return r.src.n - int64(r.bufReader.Buffered())
+}
+
+func (r *MultipartReader) warn(offset int64, line []byte, err error) {
+	if line != nil {
+		line = append([]byte(nil), line...)
+	}
+	r.warnings = append(r.warnings, MultipartWarning{Offset: offset, Line: line, Err: err})
+}
+
+// Warnings returns the malformed multipart structures that have been
+// recovered from so far. It always returns nil if MultipartReaderOptions.Lenient
+// isn't set.
+func (r *MultipartReader) Warnings() []MultipartWarning {
+	return r.warnings
+}
+
+// isLineEnding reports whether b is "\r\n" or "\n".
+func isLineEnding(b []byte) bool {
+	return string(b) == "\r\n" || string(b) == "\n"
+}
+
+// Preamble returns the bytes preceding the first boundary delimiter line,
+// including the line break before the boundary. It returns nil if there is no
+// preamble or if NextPart hasn't been called yet.
+//
+// Unless MultipartReaderOptions.MaxPreambleBytes is set, the preamble is
+// truncated to 64 KiB.
+func (r *MultipartReader) Preamble() []byte {
+	return r.preamble
+}
+
+// PreambleTruncated reports whether the preamble returned by Preamble has been
+// truncated.
+func (r *MultipartReader) PreambleTruncated() bool {
+	return r.preambleTruncated
+}
+
+// Epilogue reads and returns the bytes following the final boundary delimiter
+// line. It must be called after NextPart has returned io.EOF, and consumes the
+// rest of the underlying reader. If the epilogue exceeds
+// MultipartReaderOptions.MaxEpilogueBytes, an error of type LimitExceededError
+// is returned.
+func (r *MultipartReader) Epilogue() ([]byte, error) {
+	if !r.done {
+		return nil, errors.New("multipart: Epilogue called before the final boundary")
+	}
+	if !r.epilogueOK {
+		r.epilogueOK = true
+		var lr io.Reader = r.bufReader
+		max := r.opts.maxEpilogueBytes()
+		if max > 0 {
+			// Read one more byte to detect an epilogue exceeding the limit
+			lr = io.LimitReader(lr, max+1)
+		}
+		r.epilogue, r.epilogueErr = ioutil.ReadAll(lr)
+		if r.epilogueErr == nil && max > 0 && int64(len(r.epilogue)) > max {
+			r.epilogue = nil
+			r.epilogueErr = LimitExceededError{Limit: "multipart epilogue", Max: max}
+		}
+		if len(r.epilogue) == 0 {
+			r.epilogue = nil
+		}
+	}
+	return r.epilogue, r.epilogueErr
+}
+
 // isFinalBoundary reports whether line is the final boundary line
 // indicating that all parts are over.
 // It matches `^--boundary--[ \t]*(\r\n)?$`
@@ -308,7 +587,7 @@ func (mr *MultipartReader) isFinalBoundary(line []byte) bool {
 	}
 	rest := line[len(mr.dashBoundaryDash):]
 	rest = skipLWSPChar(rest)
-	return len(rest) == 0 || bytes.Equal(rest, mr.nl)
+	return len(rest) == 0 || bytes.Equal(rest, mr.nl) || (mr.opts.Lenient && isLineEnding(rest))
 }
 
 func (mr *MultipartReader) isBoundaryDelimiterLine(line []byte) (ret bool) {
@@ -327,6 +606,10 @@ func (mr *MultipartReader) isBoundaryDelimiterLine(line []byte) (ret bool) {
 	// On the first part, see our lines are ending in \n instead of \r\n
 	// and switch into that mode if so. This is a violation of the spec,
 	// but occurs in practice.
+	if mr.opts.Lenient {
+		// Both line endings are always accepted
+		return isLineEnding(rest)
+	}
 	if mr.partsRead == 0 && len(rest) == 1 && rest[0] == '\n' {
 		mr.nl = mr.nl[1:]
 		mr.nlDashBoundary = mr.nlDashBoundary[1:]
@@ -349,6 +632,9 @@ type MultipartWriter struct {
 	w        io.Writer
 	boundary string
 	lastpart *part
+
+	preamble []byte
+	epilogue []byte
 }
 
 // NewMultipartWriter returns a new multipart Writer with a random boundary,
@@ -398,6 +684,35 @@ func (w *MultipartWriter) SetBoundary(boundary string) error {
 	return nil
 }
 
+// SetPreamble sets the text written before the first boundary delimiter line.
+// A line break is appended if the preamble doesn't end with one.
+//
+// SetPreamble must be called before any parts are created.
+func (w *MultipartWriter) SetPreamble(preamble []byte) error {
+	if w.lastpart != nil {
+		return errors.New("multipart: SetPreamble called after write")
+	}
+	w.preamble = preamble
+	return nil
+}
+
+// SetEpilogue sets the text written by Close after the final boundary
+// delimiter line.
+func (w *MultipartWriter) SetEpilogue(epilogue []byte) {
+	w.epilogue = epilogue
+}
+
+// writePreamble writes the preamble to b, if any.
+func (w *MultipartWriter) writePreamble(b *bytes.Buffer) {
+	if len(w.preamble) == 0 {
+		return
+	}
+	b.Write(w.preamble)
+	if w.preamble[len(w.preamble)-1] != '\n' {
+		b.WriteString("\r\n")
+	}
+}
+
 func randomBoundary() string {
 	var buf [30]byte
 	_, err := io.ReadFull(rand.Reader, buf[:])
@@ -421,6 +736,7 @@ func (w *MultipartWriter) CreatePart(header Header) (io.Writer, error) {
 	if w.lastpart != nil {
 		fmt.Fprintf(&b, "\r\n--%s\r\n", w.boundary)
 	} else {
+		w.writePreamble(&b)
 		fmt.Fprintf(&b, "--%s\r\n", w.boundary)
 	}
 
@@ -438,15 +754,23 @@ func (w *MultipartWriter) CreatePart(header Header) (io.Writer, error) {
 }
 
 // Close finishes the multipart message and writes the trailing
-// boundary end line to the output.
+// boundary end line to the output, followed by the epilogue.
 func (w *MultipartWriter) Close() error {
+	var b bytes.Buffer
 	if w.lastpart != nil {
 		if err := w.lastpart.close(); err != nil {
 			return err
 		}
 		w.lastpart = nil
+		fmt.Fprintf(&b, "\r\n--%s--\r\n", w.boundary)
+	} else if len(w.preamble) > 0 {
+		w.writePreamble(&b)
+		fmt.Fprintf(&b, "--%s--\r\n", w.boundary)
+	} else {
+		fmt.Fprintf(&b, "\r\n--%s--\r\n", w.boundary)
 	}
-	_, err := fmt.Fprintf(w.w, "\r\n--%s--\r\n", w.boundary)
+	b.Write(w.epilogue)
+	_, err := io.Copy(w.w, &b)
 	return err
 }
 
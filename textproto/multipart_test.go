@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"net/textproto"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -853,3 +854,326 @@ func TestNoBoundary(t *testing.T) {
 		t.Errorf("NextPart error = %v; want %v", got, want)
 	}
 }
+
+func TestMultipartPreambleEpilogue(t *testing.T) {
+	const raw = "This is the preamble.\r\n" +
+		"It spans two lines.\r\n" +
+		"--MyBoundary\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Part body\r\n" +
+		"--MyBoundary--\r\n" +
+		"This is the epilogue.\r\n"
+
+	mr := NewMultipartReader(strings.NewReader(raw), "MyBoundary")
+	if _, err := mr.Epilogue(); err == nil {
+		t.Error("Epilogue() before the final boundary: expected an error")
+	}
+
+	var parts []string
+	var headers []Header
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("NextPart() = %v", err)
+		}
+		b, err := ioutil.ReadAll(p)
+		if err != nil {
+			t.Fatalf("ReadAll(part) = %v", err)
+		}
+		headers = append(headers, p.Header)
+		parts = append(parts, string(b))
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("NextPart() after the final boundary = %v, want io.EOF", err)
+	}
+
+	preamble := mr.Preamble()
+	if want := "This is the preamble.\r\nIt spans two lines.\r\n"; string(preamble) != want {
+		t.Errorf("Preamble() = %q, want %q", preamble, want)
+	}
+	epilogue, err := mr.Epilogue()
+	if err != nil {
+		t.Fatalf("Epilogue() = %v", err)
+	}
+	if want := "This is the epilogue.\r\n"; string(epilogue) != want {
+		t.Errorf("Epilogue() = %q, want %q", epilogue, want)
+	}
+
+	var b bytes.Buffer
+	mw := NewMultipartWriter(&b)
+	mw.SetBoundary("MyBoundary")
+	if err := mw.SetPreamble(preamble); err != nil {
+		t.Fatalf("SetPreamble() = %v", err)
+	}
+	mw.SetEpilogue(epilogue)
+	for i, body := range parts {
+		pw, err := mw.CreatePart(headers[i])
+		if err != nil {
+			t.Fatalf("CreatePart() = %v", err)
+		}
+		io.WriteString(pw, body)
+	}
+	if err := mw.SetPreamble(nil); err == nil {
+		t.Error("SetPreamble() after CreatePart: expected an error")
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if b.String() != raw {
+		t.Errorf("Round-tripped multipart body = \n%q\n, want \n%q", b.String(), raw)
+	}
+}
+
+func TestMultipartPreambleEpilogue_limits(t *testing.T) {
+	const raw = "0123456789\r\n" +
+		"--MyBoundary\r\n" +
+		"\r\n" +
+		"Part body\r\n" +
+		"--MyBoundary--\r\n" +
+		"0123456789\r\n"
+
+	for _, lenient := range []bool{false, true} {
+		mr := NewMultipartReaderWithOptions(strings.NewReader(raw), "MyBoundary", &MultipartReaderOptions{
+			MaxPreambleBytes: 10,
+			Lenient:          lenient,
+		})
+		_, err := mr.NextPart()
+		if limitErr, ok := err.(LimitExceededError); !ok {
+			t.Errorf("NextPart() with a too large preamble (lenient=%v) = %v, want a LimitExceededError", lenient, err)
+		} else if limitErr.Limit != "multipart preamble" || limitErr.Max != 10 {
+			t.Errorf("NextPart() with a too large preamble (lenient=%v) = %#v", lenient, limitErr)
+		}
+	}
+
+	mr := NewMultipartReaderWithOptions(strings.NewReader(raw), "MyBoundary", &MultipartReaderOptions{
+		MaxPreambleBytes: 12,
+		MaxEpilogueBytes: 10,
+	})
+	for {
+		if _, err := mr.NextPart(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("NextPart() = %v", err)
+		}
+	}
+	if _, err := mr.Epilogue(); err == nil {
+		t.Error("Epilogue() with a too large epilogue: expected an error")
+	} else if limitErr, ok := err.(LimitExceededError); !ok || limitErr.Limit != "multipart epilogue" {
+		t.Errorf("Epilogue() with a too large epilogue = %v, want a LimitExceededError", err)
+	}
+}
+
+func TestMultipartPreamble_truncated(t *testing.T) {
+	preamble := strings.Repeat("0123456789abcdef\r\n", 5000)
+	raw := preamble +
+		"--MyBoundary\r\n" +
+		"\r\n" +
+		"Part body\r\n" +
+		"--MyBoundary--\r\n"
+
+	for _, lenient := range []bool{false, true} {
+		mr := NewMultipartReaderWithOptions(strings.NewReader(raw), "MyBoundary", &MultipartReaderOptions{Lenient: lenient})
+		if _, err := mr.NextPart(); err != nil {
+			t.Fatalf("NextPart() with a large preamble (lenient=%v) = %v", lenient, err)
+		}
+		if got, want := string(mr.Preamble()), preamble[:maxPreambleBytes]; got != want {
+			t.Errorf("Preamble() (lenient=%v) has %v bytes, want %v", lenient, len(got), len(want))
+		}
+		if !mr.PreambleTruncated() {
+			t.Errorf("PreambleTruncated() (lenient=%v) = false, want true", lenient)
+		}
+	}
+
+	mr := NewMultipartReaderWithOptions(strings.NewReader(raw), "MyBoundary", &MultipartReaderOptions{MaxPreambleBytes: -1})
+	if _, err := mr.NextPart(); err != nil {
+		t.Fatalf("NextPart() without a preamble limit = %v", err)
+	}
+	if got := string(mr.Preamble()); got != preamble || mr.PreambleTruncated() {
+		t.Errorf("Preamble() without a preamble limit has %v bytes, want %v", len(got), len(preamble))
+	}
+}
+
+func TestMultipartWriterPreambleWithoutLineBreak(t *testing.T) {
+	var b bytes.Buffer
+	mw := NewMultipartWriter(&b)
+	mw.SetBoundary("MyBoundary")
+	mw.SetPreamble([]byte("This is a multi-part message in MIME format."))
+	if err := mw.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	want := "This is a multi-part message in MIME format.\r\n--MyBoundary--\r\n"
+	if b.String() != want {
+		t.Errorf("Multipart body = %q, want %q", b.String(), want)
+	}
+}
+
+var lenientMultipartTests = []struct {
+	name     string
+	body     string
+	parts    []string
+	preamble string
+	warnings []string
+}{
+	{
+		name:     "missingFinalBoundary",
+		body:     "--b\r\nA: 1\r\n\r\none\r\n--b\r\nA: 2\r\n\r\ntwo\r\n",
+		parts:    []string{"one", "two"},
+		warnings: []string{"multipart: missing final boundary"},
+	},
+	{
+		name:     "truncatedBoundary",
+		body:     "--b\r\nA: 1\r\n\r\none\r\n--b",
+		parts:    []string{"one"},
+		warnings: []string{"multipart: missing final boundary"},
+	},
+	{
+		name:     "noBoundary",
+		body:     "Just some text\r\n",
+		preamble: "Just some text\r\n",
+		warnings: []string{"multipart: missing final boundary"},
+	},
+	{
+		name:  "mixedLineEndings",
+		body:  "--b\r\nA: 1\r\n\r\none\n--b\nA: 2\n\ntwo\r\n--b \r\nA: 3\r\n\r\nthree\n--b--\n",
+		parts: []string{"one", "two", "three"},
+	},
+	{
+		name:     "junkAfterBoundary",
+		body:     "Preamble\r\n--b junk\r\nA: 1\r\n\r\none\r\n--b--junk\r\n",
+		parts:    []string{"one"},
+		preamble: "Preamble\r\n",
+		warnings: []string{"multipart: junk after boundary", "multipart: junk after boundary"},
+	},
+	{
+		name:     "truncatedHeader",
+		body:     "--b\r\nA: 1\r\n",
+		parts:    []string{""},
+		warnings: []string{"multipart: missing final boundary"},
+	},
+	{
+		name: "longLines",
+		body: strings.Repeat("a", 2*peekBufferSize) + "\r\n" +
+			"--b\r\nA: 1\r\n\r\none\r\n--b--\r\n",
+		parts:    []string{"one"},
+		preamble: strings.Repeat("a", 2*peekBufferSize) + "\r\n",
+	},
+}
+
+func TestMultipartReaderLenient(t *testing.T) {
+	for _, tc := range lenientMultipartTests {
+		t.Run(tc.name, func(t *testing.T) {
+			mr := NewMultipartReaderWithOptions(strings.NewReader(tc.body), "b", &MultipartReaderOptions{Lenient: true})
+
+			var parts []string
+			for {
+				p, err := mr.NextPart()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("NextPart() = %v", err)
+				}
+				if got := p.Header.Get("A"); got != strconv.Itoa(len(parts)+1) {
+					t.Errorf("Part %v: header field A = %q", len(parts), got)
+				}
+				b, err := ioutil.ReadAll(p)
+				if err != nil {
+					t.Fatalf("ReadAll(part) = %v", err)
+				}
+				parts = append(parts, string(b))
+			}
+			if !reflect.DeepEqual(parts, tc.parts) {
+				t.Errorf("Parts = %q, want %q", parts, tc.parts)
+			}
+			if got := string(mr.Preamble()); got != tc.preamble {
+				t.Errorf("Preamble() = %q, want %q", got, tc.preamble)
+			}
+
+			var warnings []string
+			for _, w := range mr.Warnings() {
+				warnings = append(warnings, w.Err.Error())
+			}
+			if !reflect.DeepEqual(warnings, tc.warnings) {
+				t.Errorf("Warnings() = %q, want %q", warnings, tc.warnings)
+			}
+		})
+	}
+}
+
+func TestMultipartReaderLenient_warningOffset(t *testing.T) {
+	body := "--b\r\nA: 1\r\n\r\none\r\n--b  junk\r\nA: 2\r\n\r\ntwo"
+	mr := NewMultipartReaderWithOptions(strings.NewReader(body), "b", &MultipartReaderOptions{Lenient: true})
+	for {
+		if _, err := mr.NextPart(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("NextPart() = %v", err)
+		}
+	}
+
+	want := []MultipartWarning{
+		{Offset: int64(strings.Index(body, "--b  junk")), Line: []byte("--b  junk\r\n")},
+		{Offset: int64(len(body))},
+	}
+	warnings := mr.Warnings()
+	if len(warnings) != len(want) {
+		t.Fatalf("Warnings() = %v, want %v warnings", warnings, len(want))
+	}
+	for i, w := range warnings {
+		if w.Offset != want[i].Offset || !bytes.Equal(w.Line, want[i].Line) {
+			t.Errorf("Warning %v: offset %v, line %q, want offset %v, line %q", i, w.Offset, w.Line, want[i].Offset, want[i].Line)
+		}
+	}
+}
+
+func TestMultipartReaderLenient_partWarnings(t *testing.T) {
+	body := "--b\r\nA: 1\r\n\r\none\r\n--b\r\nA: 2\r\n\r\ntwo"
+	mr := NewMultipartReaderWithOptions(strings.NewReader(body), "b", &MultipartReaderOptions{Lenient: true})
+
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	ioutil.ReadAll(p)
+	if warnings := p.Warnings(); len(warnings) != 0 {
+		t.Errorf("Warnings() = %v for a complete part", warnings)
+	}
+
+	p, err = mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	// The warning is recorded before Read returns io.EOF, NextPart doesn't
+	// need to be called
+	if b, err := ioutil.ReadAll(p); err != nil || string(b) != "two" {
+		t.Fatalf("ReadAll(part) = %q, %v, want %q", b, err, "two")
+	}
+	warnings := p.Warnings()
+	if len(warnings) != 1 || warnings[0].Offset != int64(len(body)) {
+		t.Fatalf("Warnings() = %v, want a missing final boundary warning", warnings)
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Fatalf("NextPart() = %v, want io.EOF", err)
+	}
+	if got := mr.Warnings(); !reflect.DeepEqual(got, warnings) {
+		t.Errorf("MultipartReader.Warnings() = %v, want %v", got, warnings)
+	}
+}
+
+func TestMultipartReaderStrict_missingFinalBoundary(t *testing.T) {
+	mr := NewMultipartReader(strings.NewReader("--b\r\nA: 1\r\n\r\none\r\n"), "b")
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart() = %v", err)
+	}
+	if _, err := ioutil.ReadAll(p); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadAll(part) = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if _, err := mr.NextPart(); err == nil || err == io.EOF {
+		t.Errorf("NextPart() = %v, want an error", err)
+	}
+}
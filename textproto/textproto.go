@@ -1,2 +1,19 @@
 // Package textproto implements low-level manipulation of MIME messages.
 package textproto
+
+import (
+	"fmt"
+)
+
+// A LimitExceededError is returned when parsing or formatting is aborted
+// because the input exceeds a limit, e.g. the maximum number of header fields.
+type LimitExceededError struct {
+	// Limit describes the limit, e.g. "header fields".
+	Limit string
+	// Max is the value of the limit.
+	Max int64
+}
+
+func (err LimitExceededError) Error() string {
+	return fmt.Sprintf("message: %v exceeds maximum (%v)", err.Limit, err.Max)
+}
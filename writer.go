@@ -2,7 +2,6 @@ package message
 
 import (
 	"errors"
-	"fmt"
 	"io"
 	"strings"
 
@@ -21,16 +20,69 @@ type Writer struct {
 	w  io.Writer
 	c  io.Closer
 	mw *textproto.MultipartWriter
+
+	opts     WriterOptions
+	deferred bool    // the header is written by Close, see AutoEncoding
+	pending  *Writer // last deferred part created with CreatePart
+	closed   bool
 }
 
-// createWriter creates a new Writer writing to w with the provided header.
-// Nothing is written to w when it is called. header is modified in-place.
-func createWriter(w io.Writer, header *Header) (*Writer, error) {
-	ww := &Writer{w: w}
+// WriterOptions contains options for CreateWriterWithOptions.
+type WriterOptions struct {
+	// AutoEncoding enables automatic selection of the Content-Transfer-Encoding
+	// for non-multipart entities whose header doesn't specify one. The body is
+	// inspected and the smallest valid encoding among 7bit, quoted-printable
+	// and base64 is picked.
+	//
+	// Since the header can only be written once the whole body is known, the
+	// body is buffered in memory and spooled to a temporary file if it grows
+	// too large. Nothing is written until the Writer is closed, and Close
+	// must be called to remove the temporary file.
+	//
+	// This option applies to parts created with Writer.CreatePart too. Such
+	// parts are closed automatically when the next part is created or when
+	// the parent Writer is closed.
+	AutoEncoding bool
+
+	// UTF8Header enables internationalized headers, as defined in RFC 6532.
+	// Header field values are written as raw UTF-8 instead of RFC 2047
+	// encoded-words: encoded-words in unstructured fields and in the display
+	// names of address fields are decoded. Embedded message/rfc822 parts are
+	// written as message/global.
+	//
+	// The resulting message must only be sent to servers supporting the
+	// SMTPUTF8 extension. Downgrade converts it back to a 7-bit-safe form.
+	//
+	// This option applies to parts created with Writer.CreatePart too.
+	UTF8Header bool
+
+	// noCharsetConversion disables CharsetWriter: text is written in its
+	// charset as-is. This is used by Downgrade.
+	noCharsetConversion bool
+}
+
+// headerWriterFunc writes an entity's header and returns the io.Writer its
+// body should be written to.
+type headerWriterFunc func(header *Header) (io.Writer, error)
+
+// createWriter creates a new Writer with the provided header. header is
+// modified in-place. writeHeader is called with the final header before the
+// body is written.
+func createWriter(header *Header, opts WriterOptions, writeHeader headerWriterFunc) (*Writer, error) {
+	ww := &Writer{opts: opts}
+
+	if opts.UTF8Header {
+		toUTF8Header(header)
+	}
+
+	// bw is set to the writer returned by writeHeader, once all checks have
+	// passed and the header is final
+	bw := &struct{ io.Writer }{nil}
 
 	mediaType, mediaParams, _ := header.ContentType()
 	if strings.HasPrefix(mediaType, "multipart/") {
-		ww.mw = textproto.NewMultipartWriter(ww.w)
+		ww.w = bw
+		ww.mw = textproto.NewMultipartWriter(bw)
 
 		// Do not set ww's io.Closer for now: if this is a multipart entity but
 		// CreatePart is not used (only Write is used), then the final boundary
@@ -46,20 +98,38 @@ func createWriter(w io.Writer, header *Header) (*Writer, error) {
 
 		header.Del("Content-Transfer-Encoding")
 	} else {
-		wc, err := encodingWriter(header.Get("Content-Transfer-Encoding"), ww.w)
-		if err != nil {
-			return nil, err
+		var wc io.WriteCloser
+		if opts.AutoEncoding && !header.Has("Content-Transfer-Encoding") {
+			// The header will be written when the body is complete
+			wc = newAutoEncoder(header, strings.HasPrefix(mediaType, "text/"), writeHeader)
+			ww.deferred = true
+		} else {
+			var err error
+			wc, err = encodingWriter(header.Get("Content-Transfer-Encoding"), bw)
+			if err != nil {
+				return nil, err
+			}
 		}
 		ww.w = wc
 		ww.c = wc
+
+		// RFC 2046 section 4.1.2: charset only applies to text/*
+		if ch, ok := mediaParams["charset"]; ok && strings.HasPrefix(mediaType, "text/") && !opts.noCharsetConversion {
+			cw, err := charsetWriter(ch, ww.w)
+			if err != nil {
+				return nil, err
+			}
+			ww.w = cw
+			ww.c = multiCloser{cw, wc}
+		}
 	}
 
-	switch strings.ToLower(mediaParams["charset"]) {
-	case "", "us-ascii", "utf-8":
-		// This is OK
-	default:
-		// Anything else is invalid
-		return nil, fmt.Errorf("unhandled charset %q", mediaParams["charset"])
+	if !ww.deferred {
+		w, err := writeHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		bw.Writer = w
 	}
 
 	return ww, nil
@@ -67,8 +137,21 @@ func createWriter(w io.Writer, header *Header) (*Writer, error) {
 
 // CreateWriter creates a new message writer to w. If header contains an
 // encoding, data written to the Writer will automatically be encoded with it.
-// The charset needs to be utf-8 or us-ascii.
+//
+// Data written to the Writer must be UTF-8. If header contains a text media
+// type with a charset other than utf-8 or us-ascii, data is converted to this
+// charset with CharsetWriter. If the charset is unknown, CreateWriter returns
+// an error that verifies IsUnknownCharset.
 func CreateWriter(w io.Writer, header Header) (*Writer, error) {
+	return CreateWriterWithOptions(w, header, nil)
+}
+
+// CreateWriterWithOptions is like CreateWriter, but with options. If opts is
+// nil, the defaults are used.
+func CreateWriterWithOptions(w io.Writer, header Header, opts *WriterOptions) (*Writer, error) {
+	if opts == nil {
+		opts = new(WriterOptions)
+	}
 
 	// ensure that modifications are invisible to the caller
 	header = header.Copy()
@@ -78,14 +161,12 @@ func CreateWriter(w io.Writer, header Header) (*Writer, error) {
 		header.Set("MIME-Version", "1.0")
 	}
 
-	ww, err := createWriter(w, &header)
-	if err != nil {
-		return nil, err
-	}
-	if err := textproto.WriteHeader(w, header.Header); err != nil {
-		return nil, err
-	}
-	return ww, nil
+	return createWriter(&header, *opts, func(header *Header) (io.Writer, error) {
+		if err := textproto.WriteHeader(w, header.Header); err != nil {
+			return nil, err
+		}
+		return w, nil
+	})
 }
 
 // Write implements io.Writer.
@@ -95,19 +176,68 @@ func (w *Writer) Write(b []byte) (int, error) {
 
 // Close implements io.Closer.
 func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	if err := w.closePending(); err != nil {
+		return err
+	}
 	if w.c != nil {
 		return w.c.Close()
 	}
 	return nil
 }
 
+// closePending closes the last part created with CreatePart, if its header
+// hasn't been written yet.
+func (w *Writer) closePending() error {
+	if w.pending == nil {
+		return nil
+	}
+	err := w.pending.Close()
+	w.pending = nil
+	return err
+}
+
+// SetPreamble sets the text written before the first part of this multipart
+// entity. It must be called before CreatePart. If this entity is not
+// multipart, it fails.
+func (w *Writer) SetPreamble(preamble []byte) error {
+	if w.mw == nil {
+		return errors.New("cannot set the preamble of a non-multipart message")
+	}
+	return w.mw.SetPreamble(preamble)
+}
+
+// SetEpilogue sets the text written by Close after the last part of this
+// multipart entity. If this entity is not multipart, it fails.
+//
+// The preamble and epilogue are only written if parts are created with
+// CreatePart.
+func (w *Writer) SetEpilogue(epilogue []byte) error {
+	if w.mw == nil {
+		return errors.New("cannot set the epilogue of a non-multipart message")
+	}
+	w.mw.SetEpilogue(epilogue)
+	return nil
+}
+
 // CreatePart returns a Writer to a new part in this multipart entity. If this
 // entity is not multipart, it fails. The body of the part should be written to
 // the returned io.WriteCloser.
+//
+// If the Writer was created with WriterOptions.AutoEncoding, the previous
+// part is closed, since its header and body have to be written before the
+// next part.
 func (w *Writer) CreatePart(header Header) (*Writer, error) {
 	if w.mw == nil {
 		return nil, errors.New("cannot create a part in a non-multipart message")
 	}
+	if err := w.closePending(); err != nil {
+		return nil, err
+	}
 
 	if w.c == nil {
 		// We know that the user calls CreatePart so Close should write the final
@@ -115,21 +245,16 @@ func (w *Writer) CreatePart(header Header) (*Writer, error) {
 		w.c = w.mw
 	}
 
-	// cw -> ww -> pw -> w.mw -> w.w
-
-	ww := &struct{ io.Writer }{nil}
-
 	// ensure that modifications are invisible to the caller
 	header = header.Copy()
-	cw, err := createWriter(ww, &header)
+	pw, err := createWriter(&header, w.opts, func(header *Header) (io.Writer, error) {
+		return w.mw.CreatePart(header.Header)
+	})
 	if err != nil {
 		return nil, err
 	}
-	pw, err := w.mw.CreatePart(header.Header)
-	if err != nil {
-		return nil, err
+	if pw.deferred {
+		w.pending = pw
 	}
-
-	ww.Writer = pw
-	return cw, nil
+	return pw, nil
 }
@@ -3,6 +3,8 @@ package message
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
 )
 
@@ -49,3 +51,252 @@ func TestWriter_multipartWithoutBoundary(t *testing.T) {
 		t.Error("Expected boundary to be automatically generated")
 	}
 }
+
+func TestWriter_preambleEpilogue(t *testing.T) {
+	const raw = "Mime-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=IMTHEBOUNDARY\r\n" +
+		"\r\n" +
+		"This is a multi-part message in MIME format.\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Hello!\r\n" +
+		"--IMTHEBOUNDARY--\r\n" +
+		"Epilogue\r\n"
+
+	e, err := Read(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal("Expected no error while reading message, got:", err)
+	}
+	mr, ok := e.MultipartReader().(MultipartPreambleEpilogueReader)
+	if !ok {
+		t.Fatal("Expected MultipartReader to implement MultipartPreambleEpilogueReader")
+	}
+
+	var b bytes.Buffer
+	mw, err := CreateWriter(&b, e.Header)
+	if err != nil {
+		t.Fatal("Expected no error while creating message writer, got:", err)
+	}
+	for i := 0; ; i++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("Expected no error while reading part, got:", err)
+		}
+		if i == 0 {
+			// The preamble is known once the first part has been reached
+			if err := mw.SetPreamble(mr.Preamble()); err != nil {
+				t.Fatal("Expected no error while setting preamble, got:", err)
+			}
+		}
+		pw, err := mw.CreatePart(p.Header)
+		if err != nil {
+			t.Fatal("Expected no error while creating part, got:", err)
+		}
+		io.Copy(pw, p.Body)
+		pw.Close()
+	}
+
+	epilogue, err := mr.Epilogue()
+	if err != nil {
+		t.Fatal("Expected no error while reading epilogue, got:", err)
+	}
+	if err := mw.SetEpilogue(epilogue); err != nil {
+		t.Fatal("Expected no error while setting epilogue, got:", err)
+	}
+	mw.Close()
+
+	if s := b.String(); s != raw {
+		t.Errorf("Expected output to be \n%q\n but got \n%q", raw, s)
+	}
+}
+
+func TestWriter_bareLFHeader(t *testing.T) {
+	e, err := Read(strings.NewReader("Subject: hi\nFrom: a@b\n\nbody\n"))
+	if err != nil {
+		t.Fatal("Expected no error while reading message, got:", err)
+	}
+
+	var b bytes.Buffer
+	w, err := CreateWriter(&b, e.Header)
+	if err != nil {
+		t.Fatal("Expected no error while creating message writer, got:", err)
+	}
+	w.Close()
+
+	expected := "Mime-Version: 1.0\r\nSubject: hi\r\nFrom: a@b\r\n\r\n"
+	if s := b.String(); s != expected {
+		t.Errorf("Expected output to be \n%q\n but got \n%q", expected, s)
+	}
+}
+
+func TestWriter_preambleNotMultipart(t *testing.T) {
+	var h Header
+	h.Set("Content-Type", "text/plain")
+
+	var b bytes.Buffer
+	w, err := CreateWriter(&b, h)
+	if err != nil {
+		t.Fatal("Expected no error while creating message writer, got:", err)
+	}
+	if err := w.SetPreamble([]byte("Preamble")); err == nil {
+		t.Error("Expected an error while setting the preamble of a non-multipart message")
+	}
+	if err := w.SetEpilogue([]byte("Epilogue")); err == nil {
+		t.Error("Expected an error while setting the epilogue of a non-multipart message")
+	}
+}
+
+func TestWriter_unknownCharset(t *testing.T) {
+	var h Header
+	h.Set("Content-Type", "text/plain; charset=idontexist")
+
+	var b bytes.Buffer
+	_, err := CreateWriter(&b, h)
+	if err == nil {
+		t.Fatal("Expected an error while creating message writer with an unknown charset")
+	} else if !IsUnknownCharset(err) {
+		t.Error("Expected error to verify IsUnknownCharset, got:", err)
+	}
+}
+
+func TestWriter_autoEncoding(t *testing.T) {
+	var h Header
+	h.Set("Content-Type", "multipart/mixed; boundary=IMTHEBOUNDARY")
+
+	var b bytes.Buffer
+	mw, err := CreateWriterWithOptions(&b, h, &WriterOptions{AutoEncoding: true})
+	if err != nil {
+		t.Fatal("Expected no error while creating message writer, got:", err)
+	}
+
+	parts := []struct {
+		mediaType string
+		enc       string
+		body      string
+	}{
+		{"text/plain", "", "Hello\nworld"},
+		{"text/plain", "", "Plan de complémentarité"},
+		{"application/octet-stream", "", "\x00\x01\x02"},
+		{"text/plain", "base64", "Hi"},
+	}
+	for _, p := range parts {
+		var ph Header
+		ph.SetContentType(p.mediaType, nil)
+		if p.enc != "" {
+			ph.Set("Content-Transfer-Encoding", p.enc)
+		}
+		pw, err := mw.CreatePart(ph)
+		if err != nil {
+			t.Fatal("Expected no error while creating part writer, got:", err)
+		}
+		io.WriteString(pw, p.body)
+		if err := pw.Close(); err != nil {
+			t.Fatal("Expected no error while closing part writer, got:", err)
+		}
+	}
+	mw.Close()
+
+	expected := "Mime-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=IMTHEBOUNDARY\r\n" +
+		"\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Hello\r\nworld\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Plan de compl=C3=A9mentarit=C3=A9\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"\r\n" +
+		"AAEC\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"SGk=\r\n" +
+		"--IMTHEBOUNDARY--\r\n"
+	if s := b.String(); s != expected {
+		t.Errorf("Expected output to be \n%s\n but got \n%s", expected, s)
+	}
+}
+
+func TestWriter_autoEncodingUnclosedParts(t *testing.T) {
+	var h Header
+	h.Set("Content-Type", "multipart/mixed; boundary=IMTHEBOUNDARY")
+
+	var b bytes.Buffer
+	mw, err := CreateWriterWithOptions(&b, h, &WriterOptions{AutoEncoding: true})
+	if err != nil {
+		t.Fatal("Expected no error while creating message writer, got:", err)
+	}
+	for _, body := range []string{"First", "Second"} {
+		var ph Header
+		ph.SetContentType("text/plain", nil)
+		pw, err := mw.CreatePart(ph)
+		if err != nil {
+			t.Fatal("Expected no error while creating part writer, got:", err)
+		}
+		// The part is closed by the next CreatePart call, or by mw.Close
+		io.WriteString(pw, body)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal("Expected no error while closing message writer, got:", err)
+	}
+
+	expected := "Mime-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=IMTHEBOUNDARY\r\n" +
+		"\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"First\r\n" +
+		"--IMTHEBOUNDARY\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Second\r\n" +
+		"--IMTHEBOUNDARY--\r\n"
+	if s := b.String(); s != expected {
+		t.Errorf("Expected output to be \n%s\n but got \n%s", expected, s)
+	}
+}
+
+func TestWriter_autoEncodingSpool(t *testing.T) {
+	var h Header
+	h.Set("Content-Type", "application/octet-stream")
+
+	body := bytes.Repeat([]byte("\x00\xff"), maxAutoEncodingMemory)
+
+	var b bytes.Buffer
+	w, err := CreateWriterWithOptions(&b, h, &WriterOptions{AutoEncoding: true})
+	if err != nil {
+		t.Fatal("Expected no error while creating message writer, got:", err)
+	}
+	w.Write(body[:len(body)/2])
+	w.Write(body[len(body)/2:])
+	if err := w.Close(); err != nil {
+		t.Fatal("Expected no error while closing message writer, got:", err)
+	}
+
+	e, err := Read(&b)
+	if err != nil {
+		t.Fatal("Expected no error while reading message, got:", err)
+	}
+	if enc := e.Header.Get("Content-Transfer-Encoding"); enc != "base64" {
+		t.Errorf("Expected encoding to be %q, but got %q", "base64", enc)
+	}
+	if got, err := ioutil.ReadAll(e.Body); err != nil {
+		t.Fatal("Expected no error while reading body, got:", err)
+	} else if !bytes.Equal(got, body) {
+		t.Error("Expected body to be preserved")
+	}
+}